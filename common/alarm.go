@@ -0,0 +1,28 @@
+/*ModuleAB common/alarm.go -- tell someone something failed.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/astaxie/beego"
+)
+
+// Alarm runs alarm script with host name and message. The script is
+// misc::alarm, or "alarm" next to the server binary if not set.
+func Alarm(host, message string) error {
+	script := beego.AppConfig.String("misc::alarm")
+	if script == "" {
+		fp, err := filepath.Abs(os.Args[0])
+		if err != nil {
+			return err
+		}
+		script = filepath.Join(filepath.Dir(fp), "alarm")
+	}
+	return exec.Command(script, host, message).Run()
+}
@@ -0,0 +1,118 @@
+/*ModuleAB common/archive.go -- cold archive tier interface.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	ArchiveDriverOas   = "oas"
+	ArchiveDriverLocal = "local"
+)
+
+// DefaultArchiveDriver is used when models.Oas does not set a driver.
+const DefaultArchiveDriver = ArchiveDriverOas
+
+// ArchiveJob is status of a job submitted to archive tier.
+type ArchiveJob struct {
+	JobId     string `json:"jobid"`
+	ArchiveId string `json:"archiveid"` // Set when an archive job is completed
+	Completed bool   `json:"completed"`
+	Failed    bool   `json:"failed"`
+	Message   string `json:"message"`
+}
+
+// ArchiveInventory lists archives in a vault as it was at Date.
+type ArchiveInventory struct {
+	VaultId  string         `json:"vaultid"`
+	Date     time.Time      `json:"date"`
+	Archives []ArchiveEntry `json:"archives"`
+}
+
+// ArchiveEntry is an archive in inventory.
+type ArchiveEntry struct {
+	ArchiveId   string    `json:"archiveid"`
+	Description string    `json:"description"`
+	Size        int64     `json:"size"`
+	CreatedTime time.Time `json:"createdtime"`
+}
+
+// ArchiveConfig is what a driver needs to reach a vault.
+type ArchiveConfig struct {
+	Driver   string
+	Endpoint string
+	VaultId  string
+}
+
+// Archive is a vault on some cold archive tier. Archiving and recovering
+// take long, so they are submitted as jobs and polled with GetJob.
+type Archive interface {
+	Driver() string
+	// LookupVault gets vault id by its name.
+	LookupVault(name string) (string, error)
+
+	// ArchiveFrom copies key in src into vault.
+	ArchiveFrom(src Storage, key, desc string) (requestId, jobId string, err error)
+	// RecoverTo copies archive back to key in dst.
+	RecoverTo(archiveId string, dst Storage, key, desc string) (requestId, jobId string, err error)
+	GetJob(jobId string) (*ArchiveJob, error)
+	DeleteArchive(archiveId string) (requestId string, err error)
+	// RetrieveInventory submits a job listing archives in vault, which
+	// is read with GetInventory when completed.
+	RetrieveInventory(desc string) (requestId, jobId string, err error)
+	GetInventory(jobId string) (*ArchiveInventory, error)
+}
+
+// ArchiveDriverFunc makes an Archive from config.
+type ArchiveDriverFunc func(conf *ArchiveConfig) (Archive, error)
+
+var (
+	archiveDrivers     = make(map[string]ArchiveDriverFunc)
+	archiveDriversLock = new(sync.RWMutex)
+)
+
+// RegisterArchiveDriver makes an archive driver available by name.
+func RegisterArchiveDriver(name string, f ArchiveDriverFunc) {
+	archiveDriversLock.Lock()
+	defer archiveDriversLock.Unlock()
+	if f == nil {
+		panic("archive: Register driver is nil")
+	}
+	if _, ok := archiveDrivers[name]; ok {
+		panic("archive: Register called twice for driver " + name)
+	}
+	archiveDrivers[name] = f
+}
+
+// HasArchiveDriver tells if driver of name is registered, empty name is
+// DefaultArchiveDriver.
+func HasArchiveDriver(name string) bool {
+	if name == "" {
+		name = DefaultArchiveDriver
+	}
+	archiveDriversLock.RLock()
+	defer archiveDriversLock.RUnlock()
+	_, ok := archiveDrivers[name]
+	return ok
+}
+
+// NewArchive connect to vault with driver named in conf.
+func NewArchive(conf *ArchiveConfig) (Archive, error) {
+	name := conf.Driver
+	if name == "" {
+		name = DefaultArchiveDriver
+	}
+	archiveDriversLock.RLock()
+	f, ok := archiveDrivers[name]
+	archiveDriversLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown archive driver: %s", name)
+	}
+	return f(conf)
+}
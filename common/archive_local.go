@@ -0,0 +1,279 @@
+/*ModuleAB common/archive_local.go -- local "tape" directory archive driver.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterArchiveDriver(ArchiveDriverLocal, newLocalArchive)
+}
+
+// localArchive keeps archives as files under Endpoint/VaultId/archives.
+// Jobs are done at once when submitted and kept under Endpoint/VaultId/jobs,
+// so polling them gives the same answer every time. Inventories are kept
+// under Endpoint/VaultId/inventories, without descriptions of archives.
+// Ids are made from key and a counter, which makes the driver deterministic
+// enough for tests.
+type localArchive struct {
+	root    string
+	vaultId string
+}
+
+// One lock for all vaults, job counter must not be raced.
+var localArchiveLock = new(sync.Mutex)
+
+// Ids made by driver, others come from records and API and must not reach
+// outside vault.
+var (
+	localArchiveIdPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	localJobIdPattern     = regexp.MustCompile(`^job-[0-9]{8}$`)
+)
+
+func newLocalArchive(conf *ArchiveConfig) (Archive, error) {
+	root, err := filepath.Abs(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	a := &localArchive{
+		root:    root,
+		vaultId: conf.VaultId,
+	}
+	if conf.VaultId != "" {
+		err = a.makeVault(conf.VaultId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *localArchive) makeVault(id string) error {
+	if strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return fmt.Errorf("Bad vault name: %s", id)
+	}
+	for _, d := range []string{"archives", "jobs", "inventories"} {
+		err := os.MkdirAll(filepath.Join(a.root, id, d), 0750)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *localArchive) Driver() string {
+	return ArchiveDriverLocal
+}
+
+// LookupVault makes vault directory, its name is the id.
+func (a *localArchive) LookupVault(name string) (string, error) {
+	return name, a.makeVault(name)
+}
+
+func (a *localArchive) archivePath(archiveId string) (string, error) {
+	if !localArchiveIdPattern.MatchString(archiveId) {
+		return "", fmt.Errorf("Bad archive id: %s", archiveId)
+	}
+	return filepath.Join(a.root, a.vaultId, "archives", archiveId), nil
+}
+
+func (a *localArchive) jobPath(jobId string) (string, error) {
+	if !localJobIdPattern.MatchString(jobId) {
+		return "", fmt.Errorf("Bad job id: %s", jobId)
+	}
+	return filepath.Join(a.root, a.vaultId, "jobs", jobId+".json"), nil
+}
+
+func (a *localArchive) inventoryPath(jobId string) (string, error) {
+	if !localJobIdPattern.MatchString(jobId) {
+		return "", fmt.Errorf("Bad job id: %s", jobId)
+	}
+	return filepath.Join(a.root, a.vaultId, "inventories", jobId+".json"), nil
+}
+
+// newJob makes next job id and saves job status.
+func (a *localArchive) newJob(job *ArchiveJob) (string, string, error) {
+	localArchiveLock.Lock()
+	defer localArchiveLock.Unlock()
+	files, err := ioutil.ReadDir(filepath.Join(a.root, a.vaultId, "jobs"))
+	if err != nil {
+		return "", "", err
+	}
+	job.JobId = fmt.Sprintf("job-%08d", len(files)+1)
+	b, err := json.Marshal(job)
+	if err != nil {
+		return "", "", err
+	}
+	p, err := a.jobPath(job.JobId)
+	if err != nil {
+		return "", "", err
+	}
+	err = ioutil.WriteFile(p, b, 0640)
+	if err != nil {
+		return "", "", err
+	}
+	return job.JobId, job.JobId, nil
+}
+
+func (a *localArchive) ArchiveFrom(src Storage, key, desc string) (string, string, error) {
+	h := sha1.New()
+	io.WriteString(h, src.BucketName()+"/"+key)
+	job := &ArchiveJob{
+		ArchiveId: hex.EncodeToString(h.Sum(nil)),
+		Completed: true,
+	}
+	p, err := a.archivePath(job.ArchiveId)
+	if err == nil {
+		err = a.copyFrom(src, key, p)
+	}
+	if err != nil {
+		job.ArchiveId = ""
+		job.Failed = true
+		job.Message = err.Error()
+	}
+	return a.newJob(job)
+}
+
+func (a *localArchive) copyFrom(src Storage, key, dst string) error {
+	r, err := src.GetObject(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(dst)
+		return err
+	}
+	return f.Close()
+}
+
+func (a *localArchive) RecoverTo(archiveId string, dst Storage, key, desc string) (string, string, error) {
+	_, err := a.archivePath(archiveId)
+	if err != nil {
+		return "", "", err
+	}
+	job := &ArchiveJob{
+		ArchiveId: archiveId,
+		Completed: true,
+	}
+	err = a.copyTo(archiveId, dst, key)
+	if err != nil {
+		job.Failed = true
+		job.Message = err.Error()
+	}
+	return a.newJob(job)
+}
+
+func (a *localArchive) copyTo(archiveId string, dst Storage, key string) error {
+	p, err := a.archivePath(archiveId)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return dst.PutObject(key, f)
+}
+
+func (a *localArchive) GetJob(jobId string) (*ArchiveJob, error) {
+	p, err := a.jobPath(jobId)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	job := new(ArchiveJob)
+	err = json.Unmarshal(b, job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (a *localArchive) DeleteArchive(archiveId string) (string, error) {
+	p, err := a.archivePath(archiveId)
+	if err != nil {
+		return "", err
+	}
+	err = os.Remove(p)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return archiveId, nil
+}
+
+// RetrieveInventory lists archive files now and keeps the list as output
+// of the job.
+func (a *localArchive) RetrieveInventory(desc string) (string, string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(a.root, a.vaultId, "archives"))
+	if err != nil {
+		return "", "", err
+	}
+	inventory := &ArchiveInventory{
+		VaultId:  a.vaultId,
+		Date:     time.Now(),
+		Archives: make([]ArchiveEntry, 0, len(files)),
+	}
+	for _, f := range files {
+		inventory.Archives = append(inventory.Archives, ArchiveEntry{
+			ArchiveId:   f.Name(),
+			Size:        f.Size(),
+			CreatedTime: f.ModTime(),
+		})
+	}
+	b, err := json.Marshal(inventory)
+	if err != nil {
+		return "", "", err
+	}
+	reqId, jobId, err := a.newJob(&ArchiveJob{Completed: true})
+	if err != nil {
+		return "", "", err
+	}
+	p, err := a.inventoryPath(jobId)
+	if err != nil {
+		return "", "", err
+	}
+	return reqId, jobId, ioutil.WriteFile(p, b, 0640)
+}
+
+func (a *localArchive) GetInventory(jobId string) (*ArchiveInventory, error) {
+	p, err := a.inventoryPath(jobId)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	inventory := new(ArchiveInventory)
+	err = json.Unmarshal(b, inventory)
+	if err != nil {
+		return nil, err
+	}
+	return inventory, nil
+}
@@ -0,0 +1,93 @@
+/*ModuleAB common/hub.go -- pass messages between server instances.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"sync"
+
+	"github.com/astaxie/beego"
+)
+
+// Messages a subscriber has not read yet, more are dropped.
+const hubBuffer = 1024
+
+// Hub passes messages published to a topic to everyone subscribing it.
+type Hub interface {
+	Publish(topic, msg string) error
+	// Subscribe returns channel of messages to topic, and func to stop
+	// subscribing, which closes the channel.
+	Subscribe(topic string) (<-chan string, func())
+}
+
+// DefaultHub is redis pub/sub if redis::host is set, so a message
+// published on one server reaches subscribers on all servers sharing the
+// redis. Otherwise messages stay in this server.
+var DefaultHub Hub
+
+func init() {
+	host := beego.AppConfig.String("redis::host")
+	if host == "" {
+		DefaultHub = NewLocalHub()
+		return
+	}
+	DefaultHub = NewRedisHub(
+		host,
+		beego.AppConfig.String("redis::password"),
+		beego.AppConfig.String("redis::key"),
+	)
+}
+
+// localHub passes messages to subscribers in this server.
+type localHub struct {
+	lock sync.Mutex
+	subs map[string]map[chan string]bool // topic -> subscribers
+}
+
+func NewLocalHub() Hub {
+	return newLocalHub()
+}
+
+func newLocalHub() *localHub {
+	return &localHub{
+		subs: make(map[string]map[chan string]bool),
+	}
+}
+
+func (h *localHub) Publish(topic, msg string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for c := range h.subs[topic] {
+		select {
+		case c <- msg:
+		default:
+			beego.Warn("Subscriber of:", topic, "is too slow, dropped:", msg)
+		}
+	}
+	return nil
+}
+
+func (h *localHub) Subscribe(topic string) (<-chan string, func()) {
+	c := make(chan string, hubBuffer)
+	h.lock.Lock()
+	if h.subs[topic] == nil {
+		h.subs[topic] = make(map[chan string]bool)
+	}
+	h.subs[topic][c] = true
+	h.lock.Unlock()
+
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			h.lock.Lock()
+			defer h.lock.Unlock()
+			delete(h.subs[topic], c)
+			if len(h.subs[topic]) == 0 {
+				delete(h.subs, topic)
+			}
+			close(c)
+		})
+	}
+}
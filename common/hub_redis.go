@@ -0,0 +1,87 @@
+/*ModuleAB common/hub_redis.go -- pass messages through redis pub/sub.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/garyburd/redigo/redis"
+)
+
+// redisHub publishes to redis channels. One connection per server listens
+// on all of them and hands messages to local subscribers.
+type redisHub struct {
+	pool   *redis.Pool
+	prefix string
+	local  *localHub
+
+	once  sync.Once
+	ready chan struct{} // Closed when listening
+}
+
+func NewRedisHub(host, password, key string) Hub {
+	if key == "" {
+		key = DefaultRedisKey
+	}
+	return &redisHub{
+		pool:   newRedisPool(host, password),
+		prefix: fmt.Sprintf("%s:hub:", key),
+		local:  newLocalHub(),
+		ready:  make(chan struct{}),
+	}
+}
+
+func (h *redisHub) Publish(topic, msg string) error {
+	c := h.pool.Get()
+	defer c.Close()
+	_, err := c.Do("PUBLISH", h.prefix+topic, msg)
+	return err
+}
+
+// Subscribe starts listening to redis on first call, and waits a while
+// for it so messages published right after are not missed.
+func (h *redisHub) Subscribe(topic string) (<-chan string, func()) {
+	h.once.Do(func() {
+		go h.listen()
+	})
+	select {
+	case <-h.ready:
+	case <-time.After(5 * time.Second):
+		beego.Warn("Hub is not listening to redis yet.")
+	}
+	return h.local.Subscribe(topic)
+}
+
+// listen keeps listening to redis, reconnecting if connection is lost.
+func (h *redisHub) listen() {
+	var once sync.Once
+	for {
+		c := redis.PubSubConn{Conn: h.pool.Get()}
+		err := c.PSubscribe(h.prefix + "*")
+		for err == nil {
+			switch v := c.Receive().(type) {
+			case redis.Subscription:
+				once.Do(func() {
+					close(h.ready)
+				})
+			case redis.PMessage:
+				h.local.Publish(
+					strings.TrimPrefix(v.Channel, h.prefix),
+					string(v.Data),
+				)
+			case error:
+				err = v
+			}
+		}
+		c.Close()
+		beego.Warn("Hub lost redis, reconnecting:", err)
+		time.Sleep(time.Second)
+	}
+}
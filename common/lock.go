@@ -0,0 +1,172 @@
+/*ModuleAB common/lock.go -- leases shared by server instances.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/pborman/uuid"
+)
+
+// ErrorLocked is returned when lock is taken by someone else.
+var ErrorLocked = errors.New("Locked by others")
+
+// Locker hands out leases on names. A lease expires after ttl unless it is
+// refreshed, so a crashed server does not keep a lock forever.
+type Locker interface {
+	// TryLock takes lease on name for ttl, with token as owner. It returns
+	// false if lease is taken by someone else.
+	TryLock(name, token string, ttl time.Duration) (bool, error)
+	// Refresh extends lease for another ttl, if token still owns it.
+	Refresh(name, token string, ttl time.Duration) (bool, error)
+	// Unlock gives lease up, if token still owns it.
+	Unlock(name, token string) error
+}
+
+// DefaultLocker is redis if redis::host is set, so that only one of
+// servers sharing the redis runs a job. Otherwise locks are local.
+var DefaultLocker Locker
+
+func init() {
+	host := beego.AppConfig.String("redis::host")
+	if host == "" {
+		DefaultLocker = NewLocalLocker()
+		return
+	}
+	DefaultLocker = NewRedisLocker(
+		host,
+		beego.AppConfig.String("redis::password"),
+		beego.AppConfig.String("redis::key"),
+	)
+}
+
+// LockTTL is how long a lease lasts without refresh, misc::lockttl seconds.
+func LockTTL() time.Duration {
+	return time.Duration(
+		beego.AppConfig.DefaultInt64("misc::lockttl", 60),
+	) * time.Second
+}
+
+// Acquire takes lock on name with DefaultLocker and keeps refreshing it
+// until release is called. ErrorLocked is returned if it is taken. The
+// context returned is cancelled when the lock is lost or released, so
+// work done under the lock can stop.
+func Acquire(parent context.Context, name string) (context.Context, func(), error) {
+	token := uuid.New()
+	ttl := LockTTL()
+	ok, err := DefaultLocker.TryLock(name, token, ttl)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, ErrorLocked
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ok, err := DefaultLocker.Refresh(name, token, ttl)
+				if err != nil {
+					beego.Warn("Cannot refresh lock:", name, "error:", err)
+				} else if !ok {
+					beego.Warn("Lock is lost:", name)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			cancel()
+			<-done
+			err := DefaultLocker.Unlock(name, token)
+			if err != nil {
+				beego.Warn("Cannot unlock:", name, "error:", err)
+			}
+		})
+	}, nil
+}
+
+// Claim takes lock on name with DefaultLocker for ttl and keeps it, so it
+// is not done again by anyone till ttl passes. ErrorLocked is returned if
+// it is taken.
+func Claim(name string, ttl time.Duration) error {
+	ok, err := DefaultLocker.TryLock(name, uuid.New(), ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrorLocked
+	}
+	return nil
+}
+
+type localLease struct {
+	token  string
+	expire time.Time
+}
+
+// localLocker keeps leases in memory, for a single server.
+type localLocker struct {
+	lock   sync.Mutex
+	leases map[string]*localLease
+}
+
+func NewLocalLocker() Locker {
+	return &localLocker{
+		leases: make(map[string]*localLease),
+	}
+}
+
+func (l *localLocker) TryLock(name, token string, ttl time.Duration) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	v, ok := l.leases[name]
+	if ok && v.token != token && time.Now().Before(v.expire) {
+		return false, nil
+	}
+	l.leases[name] = &localLease{
+		token:  token,
+		expire: time.Now().Add(ttl),
+	}
+	return true, nil
+}
+
+func (l *localLocker) Refresh(name, token string, ttl time.Duration) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	v, ok := l.leases[name]
+	if !ok || v.token != token || time.Now().After(v.expire) {
+		return false, nil
+	}
+	v.expire = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (l *localLocker) Unlock(name, token string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	v, ok := l.leases[name]
+	if ok && v.token == token {
+		delete(l.leases, name)
+	}
+	return nil
+}
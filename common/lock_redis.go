@@ -0,0 +1,78 @@
+/*ModuleAB common/lock_redis.go -- leases kept in redis.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Lease is changed only by its owner, so compare and act is done in lua.
+var (
+	redisRefreshScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	redisUnlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// redisLocker keeps a lease as a key with expire, value is owner's token.
+type redisLocker struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+func NewRedisLocker(host, password, key string) Locker {
+	if key == "" {
+		key = DefaultRedisKey
+	}
+	return &redisLocker{
+		pool:   newRedisPool(host, password),
+		prefix: fmt.Sprintf("%s:lock:", key),
+	}
+}
+
+func (l *redisLocker) TryLock(name, token string, ttl time.Duration) (bool, error) {
+	c := l.pool.Get()
+	defer c.Close()
+	_, err := redis.String(c.Do(
+		"SET", l.prefix+name, token,
+		"NX", "PX", int64(ttl/time.Millisecond),
+	))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *redisLocker) Refresh(name, token string, ttl time.Duration) (bool, error) {
+	c := l.pool.Get()
+	defer c.Close()
+	n, err := redis.Int(redisRefreshScript.Do(
+		c, l.prefix+name, token, int64(ttl/time.Millisecond),
+	))
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (l *redisLocker) Unlock(name, token string) error {
+	c := l.pool.Get()
+	defer c.Close()
+	_, err := redisUnlockScript.Do(c, l.prefix+name, token)
+	return err
+}
@@ -7,6 +7,7 @@ package common
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/astaxie/beego"
 	"github.com/tonychee7000/oas"
@@ -53,3 +54,125 @@ func NewOasClient(endpoint string) (*OasClient, error) {
 	)
 	return o, nil
 }
+
+func init() {
+	RegisterArchiveDriver(ArchiveDriverOas, newOasArchive)
+}
+
+// oasArchive is the Aliyun OAS archive driver.
+type oasArchive struct {
+	vaultId string
+	client  *OasClient
+}
+
+func newOasArchive(conf *ArchiveConfig) (Archive, error) {
+	client, err := NewOasClient(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &oasArchive{
+		vaultId: conf.VaultId,
+		client:  client,
+	}, nil
+}
+
+func (a *oasArchive) Driver() string {
+	return ArchiveDriverOas
+}
+
+func (a *oasArchive) LookupVault(name string) (string, error) {
+	return a.client.GetOasVaultId(name)
+}
+
+// OAS pulls from and pushes to OSS by itself, so only Aliyun OSS works.
+func (a *oasArchive) checkStorage(s Storage) error {
+	if s.Driver() != StorageDriverAliyun {
+		return fmt.Errorf(
+			"OAS can only work with Aliyun OSS, not %s", s.Driver(),
+		)
+	}
+	return nil
+}
+
+func (a *oasArchive) ArchiveFrom(src Storage, key, desc string) (string, string, error) {
+	err := a.checkStorage(src)
+	if err != nil {
+		return "", "", err
+	}
+	return a.client.ArchiveToOas(
+		a.vaultId,
+		ConvertOssAddrToInternal(src.Endpoint()),
+		src.BucketName(),
+		key,
+		desc,
+	)
+}
+
+func (a *oasArchive) RecoverTo(archiveId string, dst Storage, key, desc string) (string, string, error) {
+	err := a.checkStorage(dst)
+	if err != nil {
+		return "", "", err
+	}
+	return a.client.RecoverToOss(
+		a.vaultId,
+		archiveId,
+		ConvertOssAddrToInternal(dst.Endpoint()),
+		dst.BucketName(),
+		key,
+		desc,
+	)
+}
+
+func (a *oasArchive) GetJob(jobId string) (*ArchiveJob, error) {
+	reqId, jl, err := a.client.GetJobInfo(a.vaultId, jobId)
+	beego.Debug("OAS request ID:", reqId)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveJob{
+		JobId:     jobId,
+		ArchiveId: jl.ArchiveId,
+		Completed: jl.Completed,
+		Failed:    jl.StatusCode == "Failed",
+		Message:   jl.StatusMessage,
+	}, nil
+}
+
+func (a *oasArchive) DeleteArchive(archiveId string) (string, error) {
+	return a.client.DeleteArchive(a.vaultId, archiveId)
+}
+
+func (a *oasArchive) RetrieveInventory(desc string) (string, string, error) {
+	return a.client.RetrieveInventory(a.vaultId, desc)
+}
+
+func (a *oasArchive) GetInventory(jobId string) (*ArchiveInventory, error) {
+	reqId, v, err := a.client.GetInventory(a.vaultId, jobId)
+	beego.Debug("OAS request ID:", reqId)
+	if err != nil {
+		return nil, err
+	}
+	inventory := &ArchiveInventory{
+		VaultId:  v.VaultId,
+		Date:     parseOasTime(v.InventoryDate),
+		Archives: make([]ArchiveEntry, 0, len(v.ArchiveList)),
+	}
+	for _, x := range v.ArchiveList {
+		inventory.Archives = append(inventory.Archives, ArchiveEntry{
+			ArchiveId:   x.ArchiveId,
+			Description: x.ArchiveDescription,
+			Size:        x.Size,
+			CreatedTime: parseOasTime(x.CreationDate),
+		})
+	}
+	return inventory, nil
+}
+
+// parseOasTime reads time in ISO 8601 as OAS gives, zero if it cannot.
+func parseOasTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		beego.Debug("Bad OAS time:", s)
+	}
+	return t
+}
@@ -6,9 +6,15 @@
 package common
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/astaxie/beego"
@@ -19,6 +25,15 @@ type OssClient struct {
 }
 
 func NewOssClient(endpoint string) (*OssClient, error) {
+	return NewOssClientWithKey(
+		endpoint,
+		beego.AppConfig.String("aliapi::apikey"),
+		beego.AppConfig.String("aliapi::secret"),
+	)
+}
+
+// NewOssClientWithKey make a OSS instance with given key instead of aliapi.
+func NewOssClientWithKey(endpoint, key, secret string) (*OssClient, error) {
 	if !strings.HasPrefix(
 		"http://",
 		strings.ToLower(endpoint),
@@ -28,11 +43,7 @@ func NewOssClient(endpoint string) (*OssClient, error) {
 
 	var err error
 	o := new(OssClient)
-	o.Client, err = oss.New(
-		endpoint,
-		beego.AppConfig.String("aliapi::apikey"),
-		beego.AppConfig.String("aliapi::secret"),
-	)
+	o.Client, err = oss.New(endpoint, key, secret)
 	return o, err
 }
 
@@ -50,3 +61,112 @@ func ConvertOssAddrToInternal(addr string) string {
 	}
 	return addr
 }
+
+func init() {
+	RegisterStorageDriver(StorageDriverAliyun, newOssStorage)
+}
+
+// ossStorage is the Aliyun OSS storage driver.
+type ossStorage struct {
+	endpoint string
+	bucket   *oss.Bucket
+}
+
+func newOssStorage(conf *StorageConfig) (Storage, error) {
+	var (
+		client *OssClient
+		err    error
+	)
+	if conf.AccessKey != "" {
+		client, err = NewOssClientWithKey(
+			conf.Endpoint, conf.AccessKey, conf.SecretKey)
+	} else {
+		client, err = NewOssClient(conf.Endpoint)
+	}
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := client.Bucket(conf.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &ossStorage{
+		endpoint: conf.Endpoint,
+		bucket:   bucket,
+	}, nil
+}
+
+func (s *ossStorage) Driver() string {
+	return StorageDriverAliyun
+}
+
+func (s *ossStorage) Endpoint() string {
+	return s.endpoint
+}
+
+func (s *ossStorage) BucketName() string {
+	return s.bucket.BucketName
+}
+
+func (s *ossStorage) PutObject(key string, r io.Reader) error {
+	return s.bucket.PutObject(key, r)
+}
+
+func (s *ossStorage) GetObject(key string) (io.ReadCloser, error) {
+	return s.bucket.GetObject(key)
+}
+
+func (s *ossStorage) DeleteObject(key string) error {
+	return s.bucket.DeleteObject(key)
+}
+
+func (s *ossStorage) ListObjects(prefix string) ([]*ObjectInfo, error) {
+	r := make([]*ObjectInfo, 0)
+	marker := oss.Marker("")
+	for {
+		l, err := s.bucket.ListObjects(oss.Prefix(prefix), marker)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range l.Objects {
+			r = append(r, &ObjectInfo{
+				Key:          v.Key,
+				Size:         v.Size,
+				ETag:         strings.Trim(v.ETag, "\""),
+				LastModified: v.LastModified,
+				StorageClass: v.StorageClass,
+			})
+		}
+		if !l.IsTruncated {
+			return r, nil
+		}
+		marker = oss.Marker(l.NextMarker)
+	}
+}
+
+func (s *ossStorage) StatObject(key string) (*ObjectInfo, error) {
+	h, err := s.bucket.GetObjectDetailedMeta(key)
+	if e, ok := err.(oss.ServiceError); ok && e.StatusCode == http.StatusNotFound {
+		return nil, ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	size, _ := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	modified, _ := time.Parse(http.TimeFormat, h.Get("Last-Modified"))
+	// Content-MD5 is there only if it was given when uploading.
+	sum, _ := base64.StdEncoding.DecodeString(h.Get("Content-MD5"))
+	return &ObjectInfo{
+		Key:          key,
+		Size:         size,
+		ETag:         strings.Trim(h.Get("ETag"), "\""),
+		LastModified: modified,
+		Md5:          hex.EncodeToString(sum),
+		Crc64:        h.Get("X-Oss-Hash-Crc64ecma"),
+		StorageClass: h.Get("X-Oss-Storage-Class"),
+	}, nil
+}
+
+func (s *ossStorage) SignURL(key string, expire time.Duration) (string, error) {
+	return s.bucket.SignURL(key, oss.HTTPGet, int64(expire/time.Second))
+}
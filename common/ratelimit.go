@@ -0,0 +1,85 @@
+/*ModuleAB common/ratelimit.go -- limit requests sent to an endpoint.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+// RateLimiter lets requests go at most rate per second, evenly spaced.
+// Rate 0 means no limit.
+type RateLimiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func NewRateLimiter(rate float64) *RateLimiter {
+	l := new(RateLimiter)
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return l
+}
+
+// Wait blocks until a request may go, or ctx is done. A request given up
+// does not take its turn.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil || l.interval == 0 {
+		return err
+	}
+	l.lock.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next
+	l.next = l.next.Add(l.interval)
+	l.lock.Unlock()
+	wait := slot.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		l.lock.Lock()
+		// Turns taken after this one are kept as they are.
+		if l.next.Equal(slot.Add(l.interval)) {
+			l.next = slot
+		}
+		l.lock.Unlock()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+var (
+	endpointLimiters    = make(map[string]*RateLimiter)
+	endpointLimiterLock = new(sync.Mutex)
+)
+
+// EndpointLimiter returns limiter shared by everyone sending requests to
+// endpoint, rate is misc::endpointrate requests per second.
+func EndpointLimiter(endpoint string) *RateLimiter {
+	endpointLimiterLock.Lock()
+	defer endpointLimiterLock.Unlock()
+	l, ok := endpointLimiters[endpoint]
+	if !ok {
+		l = NewRateLimiter(
+			beego.AppConfig.DefaultFloat("misc::endpointrate", 0),
+		)
+		endpointLimiters[endpoint] = l
+	}
+	return l
+}
@@ -7,10 +7,12 @@ package common
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/cache"
 	_ "github.com/astaxie/beego/cache/redis" // redis driver
+	"github.com/garyburd/redigo/redis"
 )
 
 const DefaultRedisKey = "ModuleAB"
@@ -29,3 +31,26 @@ func init() {
 		beego.Alert("Connect to redis failed:", err)
 	}
 }
+
+// newRedisPool makes pool of connections to redis at host, for those
+// needing more than the cache does.
+func newRedisPool(host, password string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     4,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", host)
+			if err != nil {
+				return nil, err
+			}
+			if password != "" {
+				_, err = c.Do("AUTH", password)
+				if err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+			return c, nil
+		},
+	}
+}
@@ -0,0 +1,126 @@
+/*ModuleAB common/storage.go -- object storage driver interface.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	StorageDriverAliyun = "aliyun"
+	StorageDriverLocal  = "local"
+	StorageDriverS3     = "s3"
+)
+
+// DefaultStorageDriver is used when models.Oss does not set a driver,
+// so the rows created before drivers existed keep working.
+const DefaultStorageDriver = StorageDriverAliyun
+
+var (
+	// ErrorObjectNotFound is returned by StatObject if there is no such key.
+	ErrorObjectNotFound = errors.New("Object not found")
+	// ErrorSignURL is returned by SignURL of driver agents cannot download
+	// from by URL, they use keys and driver instead.
+	ErrorSignURL = errors.New("Storage driver cannot sign URL")
+)
+
+// ObjectInfo describes an object stored in a bucket. Checksums a driver
+// cannot tell are empty.
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastmodified"`
+	Md5          string    `json:"md5"`   // Hex
+	Crc64        string    `json:"crc64"` // CRC-64/ECMA in decimal, as OSS gives
+	StorageClass string    `json:"storageclass"`
+}
+
+// StorageConfig is what a driver needs to reach a bucket.
+type StorageConfig struct {
+	Driver   string
+	Endpoint string
+	Bucket   string
+
+	// Credentials of this bucket, Aliyun driver falls back to aliapi.
+	Region    string
+	AccessKey string
+	SecretKey string
+	// PathStyle uses http://endpoint/bucket instead of
+	// http://bucket.endpoint, most MinIO and Ceph RGW need this.
+	PathStyle bool
+	UseSSL    bool
+}
+
+// Storage is a bucket on some object storage service.
+type Storage interface {
+	Driver() string
+	Endpoint() string
+	BucketName() string
+
+	PutObject(key string, r io.Reader) error
+	GetObject(key string) (io.ReadCloser, error)
+	DeleteObject(key string) error
+	// ListObjects returns every object whose key begins with prefix.
+	ListObjects(prefix string) ([]*ObjectInfo, error)
+	// StatObject returns ErrorObjectNotFound if there is no key.
+	StatObject(key string) (*ObjectInfo, error)
+	// SignURL makes a URL the agent can download key from without keys,
+	// ErrorSignURL if driver cannot.
+	SignURL(key string, expire time.Duration) (string, error)
+}
+
+// StorageDriverFunc makes a Storage from config.
+type StorageDriverFunc func(conf *StorageConfig) (Storage, error)
+
+var (
+	storageDrivers     = make(map[string]StorageDriverFunc)
+	storageDriversLock = new(sync.RWMutex)
+)
+
+// RegisterStorageDriver makes a storage driver available by name.
+func RegisterStorageDriver(name string, f StorageDriverFunc) {
+	storageDriversLock.Lock()
+	defer storageDriversLock.Unlock()
+	if f == nil {
+		panic("storage: Register driver is nil")
+	}
+	if _, ok := storageDrivers[name]; ok {
+		panic("storage: Register called twice for driver " + name)
+	}
+	storageDrivers[name] = f
+}
+
+// HasStorageDriver tells if driver of name is registered, empty name is
+// DefaultStorageDriver.
+func HasStorageDriver(name string) bool {
+	if name == "" {
+		name = DefaultStorageDriver
+	}
+	storageDriversLock.RLock()
+	defer storageDriversLock.RUnlock()
+	_, ok := storageDrivers[name]
+	return ok
+}
+
+// NewStorage connect to bucket with driver named in conf.
+func NewStorage(conf *StorageConfig) (Storage, error) {
+	name := conf.Driver
+	if name == "" {
+		name = DefaultStorageDriver
+	}
+	storageDriversLock.RLock()
+	f, ok := storageDrivers[name]
+	storageDriversLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown storage driver: %s", name)
+	}
+	return f(conf)
+}
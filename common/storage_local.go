@@ -0,0 +1,206 @@
+/*ModuleAB common/storage_local.go -- local filesystem storage driver.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterStorageDriver(StorageDriverLocal, newLocalStorage)
+}
+
+// localTmpDir is under Endpoint, where objects are written before they are
+// moved into bucket. No bucket can be named so.
+const localTmpDir = ".tmp"
+
+// localStorage keeps objects as files under Endpoint/Bucket, so the
+// server can run against a directory on disk.
+type localStorage struct {
+	root   string
+	bucket string
+}
+
+func newLocalStorage(conf *StorageConfig) (Storage, error) {
+	root, err := filepath.Abs(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Bucket == "" || conf.Bucket == localTmpDir ||
+		strings.ContainsAny(conf.Bucket, `/\`) {
+		return nil, fmt.Errorf("Bad bucket name: %s", conf.Bucket)
+	}
+	err = os.MkdirAll(filepath.Join(root, conf.Bucket), 0750)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Join(root, localTmpDir), 0750)
+	if err != nil {
+		return nil, err
+	}
+	return &localStorage{
+		root:   root,
+		bucket: conf.Bucket,
+	}, nil
+}
+
+func (s *localStorage) Driver() string {
+	return StorageDriverLocal
+}
+
+func (s *localStorage) Endpoint() string {
+	return s.root
+}
+
+func (s *localStorage) BucketName() string {
+	return s.bucket
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	base := filepath.Join(s.root, s.bucket)
+	p := filepath.Join(base, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, base+string(filepath.Separator)) {
+		return "", fmt.Errorf("Bad object key: %s", key)
+	}
+	return p, nil
+}
+
+func (s *localStorage) PutObject(key string, r io.Reader) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(p), 0750)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, readers never see half an object.
+	f, err := ioutil.TempFile(filepath.Join(s.root, localTmpDir), "put-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Chmod(tmp, 0640)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *localStorage) GetObject(key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *localStorage) DeleteObject(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		// Same as OSS, deleting nothing is not an error.
+		return nil
+	}
+	return err
+}
+
+func (s *localStorage) ListObjects(prefix string) ([]*ObjectInfo, error) {
+	r := make([]*ObjectInfo, 0)
+	base := filepath.Join(s.root, s.bucket)
+	err := filepath.Walk(base, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		// Checksums are left to StatObject, reading every file is slow.
+		r = append(r, &ObjectInfo{
+			Key:          key,
+			Size:         fi.Size(),
+			LastModified: fi.ModTime(),
+			StorageClass: "Standard",
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *localStorage) StatObject(key string) (*ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := md5.New()
+	c := crc64.New(crc64.MakeTable(crc64.ECMA))
+	_, err = io.Copy(io.MultiWriter(h, c), f)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ETag:         strings.ToUpper(hex.EncodeToString(h.Sum(nil))),
+		LastModified: fi.ModTime(),
+		Md5:          hex.EncodeToString(h.Sum(nil)),
+		Crc64:        strconv.FormatUint(c.Sum64(), 10),
+		StorageClass: "Standard",
+	}, nil
+}
+
+// SignURL cannot make a URL of a file on server that agents can reach.
+func (s *localStorage) SignURL(key string, expire time.Duration) (string, error) {
+	return "", ErrorSignURL
+}
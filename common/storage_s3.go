@@ -0,0 +1,135 @@
+/*ModuleAB common/storage_s3.go -- S3 compatible storage driver.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package common
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/credentials"
+)
+
+func init() {
+	RegisterStorageDriver(StorageDriverS3, newS3Storage)
+}
+
+// s3Storage talks S3 protocol, for MinIO, Ceph RGW and so on.
+type s3Storage struct {
+	endpoint string
+	bucket   string
+	client   *minio.Client
+}
+
+func newS3Storage(conf *StorageConfig) (Storage, error) {
+	endpoint := conf.Endpoint
+	secure := conf.UseSSL
+	// Endpoint can be given as URL, scheme decides whether to use SSL.
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		endpoint = u.Host
+		secure = strings.ToLower(u.Scheme) == "https"
+	}
+
+	lookup := minio.BucketLookupDNS
+	if conf.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.NewWithOptions(endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4(
+			conf.AccessKey,
+			conf.SecretKey,
+			"",
+		),
+		Secure:       secure,
+		Region:       conf.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Storage{
+		endpoint: conf.Endpoint,
+		bucket:   conf.Bucket,
+		client:   client,
+	}, nil
+}
+
+func (s *s3Storage) Driver() string {
+	return StorageDriverS3
+}
+
+func (s *s3Storage) Endpoint() string {
+	return s.endpoint
+}
+
+func (s *s3Storage) BucketName() string {
+	return s.bucket
+}
+
+func (s *s3Storage) PutObject(key string, r io.Reader) error {
+	_, err := s.client.PutObject(s.bucket, key, r, -1, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *s3Storage) GetObject(key string) (io.ReadCloser, error) {
+	return s.client.GetObject(s.bucket, key, minio.GetObjectOptions{})
+}
+
+func (s *s3Storage) DeleteObject(key string) error {
+	return s.client.RemoveObject(s.bucket, key)
+}
+
+func (s *s3Storage) ListObjects(prefix string) ([]*ObjectInfo, error) {
+	r := make([]*ObjectInfo, 0)
+	done := make(chan struct{})
+	defer close(done)
+	for v := range s.client.ListObjectsV2(s.bucket, prefix, true, done) {
+		if v.Err != nil {
+			return nil, v.Err
+		}
+		r = append(r, &ObjectInfo{
+			Key:          v.Key,
+			Size:         v.Size,
+			ETag:         strings.Trim(v.ETag, "\""),
+			LastModified: v.LastModified,
+			StorageClass: v.StorageClass,
+		})
+	}
+	return r, nil
+}
+
+func (s *s3Storage) StatObject(key string) (*ObjectInfo, error) {
+	v, err := s.client.StatObject(s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			return nil, ErrorObjectNotFound
+		}
+		return nil, err
+	}
+	info := &ObjectInfo{
+		Key:          key,
+		Size:         v.Size,
+		ETag:         strings.Trim(v.ETag, "\""),
+		LastModified: v.LastModified,
+		StorageClass: v.StorageClass,
+	}
+	// ETag of object not uploaded in parts is its MD5.
+	if len(info.ETag) == 32 && !strings.Contains(info.ETag, "-") {
+		info.Md5 = strings.ToLower(info.ETag)
+	}
+	return info, nil
+}
+
+func (s *s3Storage) SignURL(key string, expire time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(s.bucket, key, expire, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
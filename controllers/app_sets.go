@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/ModuleAB/ModuleAB/server/common"
 	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
 	"github.com/astaxie/beego"
 )
 
@@ -231,3 +233,125 @@ func (a *AppSetsController) Put() {
 		a.Ctx.Output.SetStatus(http.StatusAccepted)
 	}
 }
+
+// @Title restoreAppSet
+// @Description restore each host and path of app set as it was at query at
+// @Description (RFC3339, default now), by the latest record at or before it.
+// @Success 202 {object} models.Restores
+// @router /:name/restore [post]
+func (a *AppSetsController) Restore() {
+	name := a.GetString(":name")
+	defer a.ServeJSON()
+	at := time.Now()
+	if s := a.GetString("at"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			beego.Warn("[C] Got error:", err)
+			a.Data["json"] = map[string]string{
+				"message": "Bad time, RFC3339 is needed",
+				"error":   err.Error(),
+			}
+			a.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
+		at = t
+	}
+	appSets, err := models.GetAppSets(&models.AppSets{Name: name}, 1, 0)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with name:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(appSets) == 0 {
+		beego.Debug("[C] Got nothing with name:", name)
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	restore, err := policies.RestoreAppSet(appSets[0], at, operator(&a.Controller))
+	switch err {
+	case nil:
+	case policies.ErrorNothingToRestore:
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Nothing to restore at:", at.Format(time.RFC3339)),
+			"error":   err.Error(),
+		}
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	default:
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to restore with name:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	beego.Info("[C] App set", name, "restored to", at, "by", restore.Operator)
+	a.Data["json"] = restore
+	a.Ctx.Output.SetStatus(http.StatusAccepted)
+}
+
+// @Title listRestores
+// @Success 200 {object} []models.Restores
+// @router /:name/restore [get]
+func (a *AppSetsController) GetRestores() {
+	a.getRestores("")
+}
+
+// @Title getRestore
+// @Success 200 {object} models.Restores
+// @router /:name/restore/:id [get]
+func (a *AppSetsController) GetRestore() {
+	a.getRestores(a.GetString(":id"))
+}
+
+// getRestores serves restores of app set, the one with id if not empty.
+func (a *AppSetsController) getRestores(id string) {
+	name := a.GetString(":name")
+	limit, _ := a.GetInt("limit", 0)
+	index, _ := a.GetInt("index", 0)
+	defer a.ServeJSON()
+	appSets, err := models.GetAppSets(&models.AppSets{Name: name}, 1, 0)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with name:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(appSets) == 0 {
+		beego.Debug("[C] Got nothing with name:", name)
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	restores, err := models.GetRestores(&models.Restores{
+		Id:     id,
+		AppSet: appSets[0],
+	}, limit, index)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get restores of:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(restores) == 0 {
+		beego.Debug("[C] Got nothing with id:", id)
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	if id != "" {
+		a.Data["json"] = restores[0]
+	} else {
+		a.Data["json"] = restores
+	}
+	a.Ctx.Output.SetStatus(http.StatusOK)
+}
@@ -15,10 +15,15 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// Agent of protocol version 0 replies "ACK <id>" (or "DONE <id>") when
+// signal is done, and "NACK <id> <reason>" when it cannot be done, to get
+// it sent again. Newer agents use envelopes, see models.Envelope.
 const (
 	ClientWebSocketReplyGot  = "GOT"
 	ClientWebSocketReplyDone = "DONE"
 	ClientWebSocketReplyBye  = "BYE"
+	ClientWebSocketReplyAck  = "ACK"
+	ClientWebSocketReplyNack = "NACK"
 )
 
 const (
@@ -27,30 +32,41 @@ const (
 	ClientRunStatusStopped
 )
 
-type ClientStatusMsg struct {
-	HostId string
-	Status int
+// ClientStatus is run status of hosts connected to this server.
+var ClientStatus = &clientStatus{
+	status: make(map[string]int),
 }
 
-var (
-	ClientStatus     map[string]int
-	ChanClientStatus chan ClientStatusMsg
-)
-
 func init() {
-	ClientStatus = make(map[string]int)
-	ChanClientStatus = make(chan ClientStatusMsg, 2<<10)
-	go clientStatus()
 	AddPrivilege("GET", "^/api/v1/client/signal/(.+)/ws$", models.RoleFlagNone)
 }
 
-func clientStatus() {
-	for {
-		select {
-		case s := <-ChanClientStatus:
-			ClientStatus[s.HostId] = s.Status
-		}
+type clientStatus struct {
+	lock   sync.RWMutex
+	status map[string]int // Host id -> status
+}
+
+func (s *clientStatus) Set(hostId string, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.status[hostId] = status
+}
+
+func (s *clientStatus) Get(hostId string) int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.status[hostId]
+}
+
+// All returns a copy of status of all hosts.
+func (s *clientStatus) All() map[string]int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	r := make(map[string]int, len(s.status))
+	for k, v := range s.status {
+		r[k] = v
 	}
+	return r
 }
 
 type ClientController struct {
@@ -157,10 +173,6 @@ func (c *ClientController) WebSocket() {
 			time.Duration(timeout) * time.Second),
 		)
 
-		var runningStatus = ClientStatusMsg{
-			HostId: HostId,
-			Status: ClientRunStatusStopped,
-		}
 		ws.SetPongHandler(func(string) error {
 			beego.Debug("Host:", name, "is still alive.")
 			ws.SetReadDeadline(time.Now().Add(
@@ -170,29 +182,25 @@ func (c *ClientController) WebSocket() {
 				time.Duration(timeout) * time.Second),
 			)
 
-			runningStatus.Status = ClientRunStatusRunning
-			ChanClientStatus <- runningStatus
+			ClientStatus.Set(HostId, ClientRunStatusRunning)
 
 			return nil
 		})
 
-		defer func() {
-			runningStatus.Status = ClientRunStatusStopped
-			ChanClientStatus <- runningStatus
-		}()
+		defer ClientStatus.Set(HostId, ClientRunStatusStopped)
 
-		var c chan models.Signal
-		c, ok := models.SignalChannels[HostId]
-		if !ok {
-			c = make(chan models.Signal, 1024)
-			models.SignalChannels[HostId] = c
-		}
+		// Subscribe before sending pending ones, so none is missed.
+		ids, stop := models.SubscribeSignals(HostId)
+		defer stop()
 
-		// Start read routine
+		// Agent saying hello speaks envelopes, it is waited for a while.
+		hellos := make(chan *models.HelloBody, 1)
+		closed := make(chan struct{})
 		go func() {
+			defer close(closed)
 			defer ws.Close()
 			for {
-				_, bConfirm, err := ws.ReadMessage()
+				_, b, err := ws.ReadMessage()
 				if websocket.IsCloseError(err,
 					websocket.CloseGoingAway) {
 					beego.Info("Host", name, "is offline.")
@@ -201,17 +209,62 @@ func (c *ClientController) WebSocket() {
 					beego.Warn("Error on reading:", err.Error())
 					return
 				}
-				s := strings.Split(string(bConfirm), " ")
-				if s[0] == ClientWebSocketReplyDone {
-					models.DeleteSignal(HostId, s[1])
+				err = handleReply(HostId, b, hellos)
+				if err != nil {
+					beego.Warn("Bad reply from:", name, "error:", err)
 				}
 			}
 		}()
 
+		agent := &agentConn{ws: ws}
+		helloTimeout := beego.AppConfig.DefaultInt64(
+			"websocket::hellotimeout", 1,
+		)
+		select {
+		case h := <-hellos:
+			err = agent.welcome(h)
+			if err != nil {
+				beego.Warn("Cannot welcome:", name, "error:", err)
+				return
+			}
+		case <-closed:
+			return
+		case <-time.After(time.Duration(helloTimeout) * time.Second):
+			beego.Debug("Host:", name, "speaks protocol version 0.")
+		}
+
+		// Signals not acked yet are sent again on connecting.
+		pending, err := models.PendingSignals(HostId)
+		if err != nil {
+			beego.Warn("Cannot get pending signals of:", name, "error:", err)
+		}
+		for _, v := range pending {
+			err = agent.send(v)
+			if err != nil {
+				beego.Warn("Cannot send signal:", v.Id, "error:", err)
+				return
+			}
+		}
+
 		for {
 			select {
-			case s := <-models.SignalChannels[HostId]:
-				ws.WriteJSON(s)
+			case id := <-ids:
+				v, err := models.GetSignalRecord(HostId, id)
+				if err != nil {
+					beego.Warn("Cannot get signal:", id, "error:", err)
+					continue
+				}
+				// Sent on connecting already.
+				if v.Status != models.SignalStatusPending {
+					continue
+				}
+				err = agent.send(v)
+				if err != nil {
+					beego.Warn("Cannot send signal:", id, "error:", err)
+					return
+				}
+			case <-closed:
+				return
 			case <-ticker.C:
 				beego.Debug("Websocket ping:", name)
 				err := ws.WriteMessage(websocket.PingMessage, []byte{})
@@ -224,6 +277,204 @@ func (c *ClientController) WebSocket() {
 	}
 }
 
+// agentConn writes to agent on signal websocket, in protocol version
+// agreed. Only one goroutine writes to it.
+type agentConn struct {
+	ws     *websocket.Conn
+	agreed *models.WelcomeBody // Nil for protocol version 0
+}
+
+// welcome answers hello of agent with version and capabilities agreed.
+func (a *agentConn) welcome(h *models.HelloBody) error {
+	w, err := models.Negotiate(h)
+	if err != nil {
+		e, _ := models.NewEnvelope(
+			models.EnvelopeKindError, "",
+			&models.ErrorBody{
+				Code:    models.SignalErrorUnsupported,
+				Message: err.Error(),
+			},
+		)
+		a.ws.WriteJSON(e)
+		return err
+	}
+	e, err := models.NewEnvelope(models.EnvelopeKindWelcome, "", w)
+	if err != nil {
+		return err
+	}
+	a.agreed = w
+	return a.ws.WriteJSON(e)
+}
+
+// send writes signal to agent, unless it is out of attempts or time.
+// Signal agent cannot do is left pending.
+func (a *agentConn) send(s *models.Signals) error {
+	if a.agreed == nil {
+		if !models.LegacyCan(s.Type) {
+			beego.Warn("Legacy agent cannot do signal:", s.Id, "type:", s.Type)
+			return nil
+		}
+		err := models.DeliverSignal(s, 0)
+		if err == models.ErrorSignalDone {
+			beego.Info("Signal:", s.Id, "is given up.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return a.ws.WriteJSON(s.Signal())
+	}
+
+	if !a.agreed.Can(s.Type) {
+		beego.Warn("Agent cannot do signal:", s.Id, "type:", s.Type)
+		return nil
+	}
+	e, err := s.Command()
+	if err != nil {
+		return err
+	}
+	err = models.DeliverSignal(s, a.agreed.Version)
+	if err == models.ErrorSignalDone {
+		beego.Info("Signal:", s.Id, "is given up.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return a.ws.WriteJSON(e)
+}
+
+// handleReply handles a message from agent of host. Hello is passed to
+// hellos, to be answered by writing goroutine.
+func handleReply(hostId string, b []byte, hellos chan<- *models.HelloBody) error {
+	if len(b) == 0 || b[0] != '{' {
+		return handleLegacyReply(hostId, string(b))
+	}
+	e, err := models.ParseEnvelope(b)
+	if err != nil {
+		return err
+	}
+	switch e.Kind {
+	case models.EnvelopeKindHello:
+		h := new(models.HelloBody)
+		err = e.Decode(h)
+		if err != nil {
+			return err
+		}
+		select {
+		case hellos <- h:
+		default:
+			return fmt.Errorf("Hello again")
+		}
+	case models.EnvelopeKindProgress:
+		p := new(models.ProgressBody)
+		err = e.Decode(p)
+		if err != nil {
+			return err
+		}
+		return models.ProgressSignal(hostId, e.Id, p.Percent, p.Message)
+	case models.EnvelopeKindDone:
+		d := new(models.DoneBody)
+		err = e.Decode(d)
+		if err != nil {
+			return err
+		}
+		err = models.ProgressSignal(hostId, e.Id, 100, d.Message)
+		if err != nil {
+			return err
+		}
+		return models.AckSignal(hostId, e.Id)
+	case models.EnvelopeKindError:
+		r := new(models.ErrorBody)
+		err = e.Decode(r)
+		if err != nil {
+			return err
+		}
+		reason := fmt.Sprintf("%s: %s", r.Code, r.Message)
+		if r.Retry {
+			return models.NackSignal(hostId, e.Id, reason)
+		}
+		return models.FailSignal(hostId, e.Id, reason)
+	}
+	return nil
+}
+
+// handleLegacyReply handles reply of protocol version 0, like "DONE <id>".
+func handleLegacyReply(hostId, reply string) error {
+	s := strings.SplitN(reply, " ", 3)
+	if len(s) < 2 {
+		return nil
+	}
+	switch s[0] {
+	case ClientWebSocketReplyDone, ClientWebSocketReplyAck:
+		// Legacy agent acks signals it does not know, too.
+		a, err := models.GetSignalRecord(hostId, s[1])
+		if err != nil {
+			return err
+		}
+		if !models.LegacyCan(a.Type) {
+			return models.FailSignal(hostId, s[1], fmt.Sprintf(
+				"%s: Legacy agent cannot do %s",
+				models.SignalErrorUnsupported,
+				models.SignalTypeNames[a.Type],
+			))
+		}
+		return models.AckSignal(hostId, s[1])
+	case ClientWebSocketReplyNack:
+		var reason string
+		if len(s) > 2 {
+			reason = s[2]
+		}
+		return models.NackSignal(hostId, s[1], reason)
+	}
+	return nil
+}
+
+// @Title getSignalHistory
+// @router /signal/:name/history [get]
+func (c *ClientController) GetSignalHistory() {
+	name := c.GetString(":name")
+	status, _ := c.GetInt("status", models.SignalStatusAll)
+	limit, _ := c.GetInt("limit", 50)
+	index, _ := c.GetInt("index", 0)
+	defer c.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := &models.Hosts{
+			Name: name,
+		}
+		hosts, err := models.GetHosts(host, 0, 0)
+		if err != nil {
+			c.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			c.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		signals, err := models.GetSignalHistory(
+			hosts[0].Id, status, limit, index,
+		)
+		if err != nil {
+			c.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get signals of:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			c.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Data["json"] = signals
+		c.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
 // @Title getSignals
 // @router /signal/:name [get]
 func (c *ClientController) GetSignals() {
@@ -445,10 +696,6 @@ func (c *ClientController) NotifySignal() {
 // @router /config/status [get]
 func (c *ClientController) GetStatus() {
 	defer c.ServeJSON()
-	var lock = new(sync.Mutex)
-	lock.Lock()
-	defer lock.Unlock()
-
-	c.Data["json"] = ClientStatus
+	c.Data["json"] = ClientStatus.All()
 	c.Ctx.Output.SetStatus(http.StatusOK)
 }
@@ -4,12 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
 	"time"
 
+	"github.com/ModuleAB/ModuleAB/server/common"
 	"github.com/ModuleAB/ModuleAB/server/models"
 	"github.com/astaxie/beego"
 )
@@ -88,19 +85,7 @@ func (h *FailLogContoller) Post() {
 	}
 	h.Ctx.Output.SetStatus(http.StatusCreated)
 
-	fp, err := filepath.Abs(os.Args[0])
-	if err != nil {
-		beego.Warn("[C] Got error:", err)
-		return
-	}
-	dir, _ := path.Split(fp)
-
-	cmd := exec.Command(
-		path.Join(dir, "alarm"),
-		failLog.Host.Name,
-		failLog.Log,
-	)
-	err = cmd.Run()
+	err = common.Alarm(failLog.Host.Name, failLog.Log)
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
 		return
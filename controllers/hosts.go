@@ -15,6 +15,17 @@ func init() {
 	AddPrivilege("GET", "^/api/v1/hosts", models.RoleFlagUser)
 }
 
+// BackupRequest asks host to back path up now.
+type BackupRequest struct {
+	Path string `json:"path"`
+}
+
+// BackupStatus is how far a backup asked for is, and records it made.
+type BackupStatus struct {
+	Signal  *models.Signals   `json:"signal"`
+	Records []*models.Records `json:"records"`
+}
+
 type HostsController struct {
 	beego.Controller
 }
@@ -251,3 +262,153 @@ func (h *HostsController) Put() {
 		h.Ctx.Output.SetStatus(http.StatusAccepted)
 	}
 }
+
+// @Title backupHost
+// @Description back a path of host up now
+// @Param	body	body	controllers.BackupRequest	true	"path to back up"
+// @Success 202 {string} signal id
+// @Failure 400 path is not of host
+// @Failure 404
+// @router /:name/backup [post]
+func (h *HostsController) Backup() {
+	name := h.GetString(":name")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := &models.Hosts{
+			Name: name,
+		}
+		hosts, err := models.GetHosts(host, 0, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+
+		req := new(BackupRequest)
+		err = json.Unmarshal(h.Ctx.Input.RequestBody, req)
+		if err != nil {
+			beego.Warn("[C] Got error:", err)
+			h.Data["json"] = map[string]string{
+				"message": "Bad request",
+				"error":   err.Error(),
+			}
+			h.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
+		var path *models.Paths
+		for _, v := range hosts[0].Paths {
+			if v.Path == req.Path {
+				path = v
+				break
+			}
+		}
+		if path == nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Host has no path:", req.Path),
+			}
+			h.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
+
+		signal := models.MakeBackupSignal(path)
+		err = models.ValidateSignal(signal)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Path cannot be backed up:", req.Path),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
+		// Agent which cannot back up gets it when one which can connects.
+		id, err := models.AddSignal(hosts[0].Id, signal)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": "Failed to add backup signal",
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		err = models.NotifySignal(hosts[0].Id, id)
+		if err != nil {
+			// Saved already, agent gets it when connecting.
+			beego.Warn("[C] Got error:", err)
+		}
+		h.Data["json"] = map[string]string{
+			"id":      id,
+			"message": "Backup is asked for, see its progress at backup/" + id,
+		}
+		h.Ctx.Output.SetStatus(http.StatusAccepted)
+	}
+}
+
+// @Title getHostBackup
+// @Description get progress of backup asked for, and records it made
+// @Success 200 {object} controllers.BackupStatus
+// @Failure 404
+// @router /:name/backup/:id [get]
+func (h *HostsController) GetBackup() {
+	name := h.GetString(":name")
+	id := h.GetString(":id")
+	defer h.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	if name != "" {
+		host := &models.Hosts{
+			Name: name,
+		}
+		hosts, err := models.GetHosts(host, 0, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		signal, err := models.GetSignalRecord(hosts[0].Id, id)
+		if err != nil || signal.Type != models.SignalTypeBackup {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Got no backup with id:", id),
+			}
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		records, err := models.GetRecords(
+			&models.Records{SignalId: id}, 0, 0,
+			models.OrderAsc, models.OrderAsc,
+		)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get records of backup:", id),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		h.Data["json"] = &BackupStatus{
+			Signal:  signal,
+			Records: records,
+		}
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/ModuleAB/ModuleAB/server/common"
 	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
 
 	"github.com/astaxie/beego"
 )
@@ -65,8 +66,16 @@ func (a *OasController) Post() {
 		return
 	}
 	beego.Debug("Got data:", oas)
+	if !common.HasArchiveDriver(oas.Driver) {
+		a.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   fmt.Sprint("Unknown archive driver: ", oas.Driver),
+		}
+		a.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
 
-	o, err := common.NewOasClient(oas.Endpoint)
+	o, err := oas.Archive()
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
 		a.Data["json"] = map[string]string{
@@ -76,7 +85,7 @@ func (a *OasController) Post() {
 		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
 		return
 	}
-	oas.VaultId, err = o.GetOasVaultId(oas.VaultName)
+	oas.VaultId, err = o.LookupVault(oas.VaultName)
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
 		a.Data["json"] = map[string]string{
@@ -242,6 +251,14 @@ func (a *OasController) Put() {
 			return
 		}
 		beego.Debug("[C] Got oas data:", oas)
+		if !common.HasArchiveDriver(oas.Driver) {
+			a.Data["json"] = map[string]string{
+				"message": "Bad request",
+				"error":   fmt.Sprint("Unknown archive driver: ", oas.Driver),
+			}
+			a.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
 		err = models.UpdateOas(oas)
 		if err != nil {
 			a.Data["json"] = map[string]string{
@@ -255,3 +272,47 @@ func (a *OasController) Put() {
 		a.Ctx.Output.SetStatus(http.StatusAccepted)
 	}
 }
+
+// @Title retrieveInventory
+// @Description submit inventory retrieval job of vault, it is reconciled
+// @Description with records when done.
+// @Success 202 {object} models.OasJobs
+// @router /:name/inventory [post]
+func (a *OasController) Inventory() {
+	name := a.GetString(":name")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	oass, err := models.GetOas(&models.Oas{VaultName: name}, 1, 0)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with name:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(oass) == 0 {
+		beego.Debug("[C] Got nothing with name:", name)
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	job, err := policies.RetrieveInventory(oass[0])
+	switch err {
+	case nil:
+	case policies.ErrorInventoryRunning:
+		a.Data["json"] = job
+		a.Ctx.Output.SetStatus(http.StatusConflict)
+		return
+	default:
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to retrieve inventory of:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	a.Data["json"] = job
+	a.Ctx.Output.SetStatus(http.StatusAccepted)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/ModuleAB/ModuleAB/server/common"
 	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
 
 	"github.com/astaxie/beego"
 )
@@ -105,3 +106,51 @@ func (a *OasJobsController) GetAll() {
 		a.Ctx.Output.SetStatus(http.StatusOK)
 	}
 }
+
+// @Title cancelOAS
+// @Description stop checking and retrying job, it is not cancelled in OAS.
+// @Success 200 {object} models.OasJobs
+// @router /:job_id/cancel [post]
+func (a *OasJobsController) Cancel() {
+	jobId := a.GetString(":job_id")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got job id:", jobId)
+	if jobId != "" {
+		oasJobs, err := models.GetOasJobs(&models.OasJobs{JobId: jobId}, 1, 0)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with job id:", jobId),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(oasJobs) == 0 {
+			beego.Debug("[C] Got nothing with job id:", jobId)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		err = policies.CancelOasJob(oasJobs[0], operator(&a.Controller))
+		switch err {
+		case nil:
+		case models.ErrorOasJobOver:
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Cannot cancel job:", jobId),
+				"error":   err.Error(),
+			}
+			a.Ctx.Output.SetStatus(http.StatusConflict)
+			return
+		default:
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to cancel job:", jobId),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		a.Data["json"] = oasJobs[0]
+		a.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
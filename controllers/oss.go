@@ -7,6 +7,7 @@ import (
 
 	"github.com/ModuleAB/ModuleAB/server/common"
 	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
 
 	"github.com/astaxie/beego"
 )
@@ -65,6 +66,14 @@ func (a *OssController) Post() {
 		return
 	}
 	beego.Debug("[C] Got data:", oss)
+	if !common.HasStorageDriver(oss.Driver) {
+		a.Data["json"] = map[string]string{
+			"message": "Bad request",
+			"error":   fmt.Sprint("Unknown storage driver: ", oss.Driver),
+		}
+		a.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
 	id, err := models.AddOss(oss)
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
@@ -208,6 +217,10 @@ func (a *OssController) Put() {
 
 		err = json.Unmarshal(a.Ctx.Input.RequestBody, oss)
 		oss.Id = osss[0].Id
+		if oss.SecretKey == "" {
+			// Secret key is never shown, so keep it if not given.
+			oss.SecretKey = osss[0].SecretKey
+		}
 		if err != nil {
 			beego.Warn("[C] Got error:", err)
 			a.Data["json"] = map[string]string{
@@ -218,6 +231,14 @@ func (a *OssController) Put() {
 			return
 		}
 		beego.Debug("[C] Got oss data:", oss)
+		if !common.HasStorageDriver(oss.Driver) {
+			a.Data["json"] = map[string]string{
+				"message": "Bad request",
+				"error":   fmt.Sprint("Unknown storage driver: ", oss.Driver),
+			}
+			a.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
 		err = models.UpdateOss(oss)
 		if err != nil {
 			a.Data["json"] = map[string]string{
@@ -231,3 +252,52 @@ func (a *OssController) Put() {
 		a.Ctx.Output.SetStatus(http.StatusAccepted)
 	}
 }
+
+// @Title reconcileOSS
+// @Description compare objects in bucket with records in background, and
+// @Description fix problems found if query fix is true.
+// @router /:name/reconcile [post]
+func (a *OssController) Reconcile() {
+	name := a.GetString(":name")
+	fix, _ := a.GetBool("fix", false)
+	defer a.ServeJSON()
+	beego.Debug("[C] Got name:", name)
+	osss, err := models.GetOss(&models.Oss{BucketName: name}, 1, 0)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get with name:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(osss) == 0 {
+		beego.Debug("[C] Got nothing with name:", name)
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	err = policies.StartReconcileBucket(osss[0], fix)
+	switch err {
+	case nil:
+	case common.ErrorLocked:
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Bucket is being reconciled:", name),
+			"error":   err.Error(),
+		}
+		a.Ctx.Output.SetStatus(http.StatusConflict)
+		return
+	default:
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to reconcile:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	a.Data["json"] = map[string]string{
+		"message": fmt.Sprint("Reconciling:", name),
+	}
+	a.Ctx.Output.SetStatus(http.StatusAccepted)
+}
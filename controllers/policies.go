@@ -7,18 +7,45 @@ import (
 
 	"github.com/ModuleAB/ModuleAB/server/common"
 	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
 
 	"github.com/astaxie/beego"
 )
 
 func init() {
 	AddPrivilege("GET", "^/api/v1/policies", models.RoleFlagUser)
+	AddPrivilege("POST", "^/api/v1/policies/[^/]+/preview$", models.RoleFlagUser)
 }
 
 type PolicyController struct {
 	beego.Controller
 }
 
+// previewPolicy groups decisions of policy p by action.
+func previewPolicy(p *models.Policies) (map[string][]*policies.Decision, error) {
+	decisions, err := policies.Plan(p)
+	if err != nil {
+		return nil, err
+	}
+	r := map[string][]*policies.Decision{
+		policies.DecisionArchive: make([]*policies.Decision, 0),
+		policies.DecisionDelete:  make([]*policies.Decision, 0),
+		policies.DecisionKeep:    make([]*policies.Decision, 0),
+	}
+	for _, d := range decisions {
+		r[d.Action] = append(r[d.Action], d)
+	}
+	return r, nil
+}
+
+// reloadSchedule makes changed policies run on their new schedule.
+func reloadSchedule() {
+	err := policies.ReloadAll()
+	if err != nil {
+		beego.Warn("[C] Got error on reloading schedule:", err)
+	}
+}
+
 func (h *PolicyController) Prepare() {
 	if h.Ctx.Input.Header("Signature") != "" {
 		err := common.AuthWithKey(h.Ctx)
@@ -53,7 +80,9 @@ func (h *PolicyController) Prepare() {
 // @router / [post]
 func (a *PolicyController) Post() {
 	defer a.ServeJSON()
-	policy := new(models.Policies)
+	policy := &models.Policies{
+		Enabled: true,
+	}
 	err := json.Unmarshal(a.Ctx.Input.RequestBody, policy)
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
@@ -78,6 +107,7 @@ func (a *PolicyController) Post() {
 	}
 
 	beego.Debug("[C] Got id:", id)
+	reloadSchedule()
 	a.Data["json"] = map[string]string{
 		"id": id,
 	}
@@ -175,6 +205,7 @@ func (a *PolicyController) Delete() {
 			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
 			return
 		}
+		reloadSchedule()
 		a.Ctx.Output.SetStatus(http.StatusNoContent)
 	}
 }
@@ -209,6 +240,7 @@ func (a *PolicyController) Put() {
 			return
 		}
 
+		policy.Enabled = policies[0].Enabled
 		err = json.Unmarshal(a.Ctx.Input.RequestBody, policy)
 		policy.Id = policies[0].Id
 		if err != nil {
@@ -231,6 +263,118 @@ func (a *PolicyController) Put() {
 			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
 			return
 		}
+		reloadSchedule()
+		a.Ctx.Output.SetStatus(http.StatusAccepted)
+	}
+}
+
+// @Title previewPolicy
+// @router /:name/preview [post]
+func (a *PolicyController) Preview() {
+	name := a.GetString(":name")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got policy name:", name)
+	if name != "" {
+		policy := &models.Policies{
+			Name: name,
+		}
+		policies, err := models.GetPolicies(policy, 0, 0)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(policies) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+
+		decisions, err := previewPolicy(policies[0])
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to preview with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		a.Data["json"] = decisions
+		a.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title listPolicyRuns
+// @router /:name/runs [get]
+func (a *PolicyController) Runs() {
+	name := a.GetString(":name")
+	limit, _ := a.GetInt("limit", 0)
+	index, _ := a.GetInt("index", 0)
+	defer a.ServeJSON()
+	beego.Debug("[C] Got policy name:", name)
+	if name != "" {
+		run := &models.PolicyRuns{
+			Policy: &models.Policies{
+				Name: name,
+			},
+		}
+		runs, err := models.GetPolicyRuns(run, limit, index)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get runs with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		a.Data["json"] = runs
+		if len(runs) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+		} else {
+			a.Ctx.Output.SetStatus(http.StatusOK)
+		}
+	}
+}
+
+// @Title cancelPolicy
+// @router /:name/cancel [post]
+func (a *PolicyController) Cancel() {
+	name := a.GetString(":name")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got policy name:", name)
+	if name != "" {
+		policy := &models.Policies{
+			Name: name,
+		}
+		found, err := models.GetPolicies(policy, 0, 0)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with name:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(found) == 0 {
+			beego.Debug("[C] Got nothing with name:", name)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		if !policies.Cancel(found[0].Id) {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Policy is not running on this server:", name),
+			}
+			a.Ctx.Output.SetStatus(http.StatusConflict)
+			return
+		}
 		a.Ctx.Output.SetStatus(http.StatusAccepted)
 	}
 }
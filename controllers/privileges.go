@@ -5,6 +5,7 @@ import (
 
 	"github.com/ModuleAB/ModuleAB/server/models"
 
+	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/context"
 )
 
@@ -59,3 +60,17 @@ func AddPrivilege(method, urlpattern string, roleflag int) {
 		},
 	)
 }
+
+// operator is who sends the request, login name of user, or "api" if
+// request is signed with key.
+func operator(h *beego.Controller) string {
+	id := h.GetSession("id")
+	if id == nil {
+		return "api"
+	}
+	users, err := models.GetUser(&models.Users{Id: id.(string)}, 1, 0)
+	if err != nil || len(users) == 0 {
+		return id.(string)
+	}
+	return users[0].Name
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+)
+
+func init() {
+	AddPrivilege("GET", "^/api/v1/reconciliations", models.RoleFlagUser)
+}
+
+type ReconciliationsController struct {
+	beego.Controller
+}
+
+func (h *ReconciliationsController) Prepare() {
+	if h.Ctx.Input.Header("Signature") != "" {
+		err := common.AuthWithKey(h.Ctx)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"error": err.Error(),
+			}
+			h.Ctx.Output.SetStatus(http.StatusForbidden)
+			h.ServeJSON()
+		}
+	} else {
+		id := h.GetSession("id")
+		if id == nil {
+			h.Data["json"] = map[string]string{
+				"error": "You need login first.",
+			}
+			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
+			h.ServeJSON()
+		} else {
+			if !CheckPrivileges(id.(string), h.Ctx) {
+				h.Data["json"] = map[string]string{
+					"error": "No privileges.",
+				}
+				h.Ctx.Output.SetStatus(http.StatusForbidden)
+				h.ServeJSON()
+			}
+		}
+	}
+}
+
+// @Title listReconciliations
+// @Description newest first, of vault (name) or oss (bucket) if given,
+// @Description without items.
+// @Success 200 {object} []models.Reconciliations
+// @router / [get]
+func (a *ReconciliationsController) GetAll() {
+	limit, _ := a.GetInt("limit", 0)
+	index, _ := a.GetInt("index", 0)
+	vault := a.GetString("vault")
+
+	defer a.ServeJSON()
+
+	reconciliation := &models.Reconciliations{}
+	if vault != "" {
+		oass, err := models.GetOas(&models.Oas{VaultName: vault}, 1, 0)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get vault:", vault),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(oass) == 0 {
+			beego.Debug("[C] Got no vault:", vault)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		reconciliation.Vault = oass[0]
+	}
+	if bucket := a.GetString("oss"); bucket != "" {
+		osss, err := models.GetOss(&models.Oss{BucketName: bucket}, 1, 0)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get oss:", bucket),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(osss) == 0 {
+			beego.Debug("[C] Got no oss:", bucket)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		reconciliation.Oss = osss[0]
+	}
+	reconciliations, err := models.GetReconciliations(
+		reconciliation, limit, index,
+	)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	a.Data["json"] = reconciliations
+	if len(reconciliations) == 0 {
+		beego.Debug("[C] Got nothing")
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		a.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title getReconciliation
+// @Description with orphan and missing archives or objects found.
+// @Success 200 {object} models.Reconciliations
+// @router /:id [get]
+func (a *ReconciliationsController) Get() {
+	id := a.GetString(":id")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	if id != "" {
+		reconciliations, err := models.GetReconciliations(
+			&models.Reconciliations{Id: id}, 1, 0,
+		)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with id:", id),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(reconciliations) == 0 {
+			beego.Debug("[C] Got nothing with id:", id)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		a.Data["json"] = reconciliations[0]
+		a.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
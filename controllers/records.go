@@ -8,6 +8,7 @@ import (
 
 	"github.com/ModuleAB/ModuleAB/server/common"
 	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
 
 	"github.com/astaxie/beego"
 )
@@ -71,6 +72,25 @@ func (h *RecordsController) Post() {
 		return
 	}
 	beego.Debug("[C] Got data:", record)
+	if record.SignalId != "" {
+		var hostId string
+		if record.Host != nil {
+			hostId = record.Host.Id
+		}
+		s, err := models.GetSignalRecord(hostId, record.SignalId)
+		if err == nil && s.Type != models.SignalTypeBackup {
+			err = fmt.Errorf("Not a backup signal: %s", record.SignalId)
+		}
+		if err != nil {
+			beego.Warn("[C] Got error:", err)
+			h.Data["json"] = map[string]string{
+				"message": "Bad signal id",
+				"error":   err.Error(),
+			}
+			h.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
+	}
 	id, err := models.AddRecord(record)
 	if err != nil {
 		beego.Warn("[C] Got error:", err)
@@ -102,6 +122,7 @@ func (h *RecordsController) GetAll() {
 	appSet := h.GetString("appSet")
 	backupSet := h.GetString("backupSet")
 	host := h.GetString("host")
+	verify, _ := h.GetInt("verify", models.RecordVerifyAll)
 	// Format: RFC3339
 	btStart := h.GetString("btStart")
 	btEnd := h.GetString("btEnd")
@@ -123,6 +144,7 @@ func (h *RecordsController) GetAll() {
 		BackupSet: &models.BackupSets{
 			Name: backupSet,
 		},
+		VerifyState: verify,
 	}
 
 	tBtStart, _ := time.Parse(time.RFC3339, btStart)
@@ -180,6 +202,15 @@ func (h *RecordsController) Delete() {
 			h.Ctx.Output.SetStatus(http.StatusNotFound)
 			return
 		}
+		if records[0].IsHeld() {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Cannot delete held record:", id),
+				"error":   records[0].HoldInfo(),
+			}
+			beego.Debug("[C] Record is held:", id)
+			h.Ctx.Output.SetStatus(http.StatusConflict)
+			return
+		}
 		err = models.DeleteRecord(records[0])
 		if err != nil {
 			h.Data["json"] = map[string]string{
@@ -195,6 +226,9 @@ func (h *RecordsController) Delete() {
 	}
 }
 
+// @Title recover record
+// @Description restore record onto host named by query host, into directory
+// @Description by query target, both default to where record is from.
 // @router /:id/recover [get]
 func (h *RecordsController) Recover() {
 	id := h.GetString(":id")
@@ -221,93 +255,204 @@ func (h *RecordsController) Recover() {
 			return
 		}
 
-		switch records[0].Type {
-		case models.RecordTypeArchive:
-			oasJob := &models.OasJobs{
-				JobType: models.OasJobTypePushToOSS,
-				Vault:   records[0].BackupSet.Oas,
-				Records: records[0],
+		host := &models.Hosts{
+			Id:   records[0].Host.Id,
+			Name: h.GetString("host"),
+		}
+		if host.Name != "" {
+			host.Id = ""
+		}
+		hosts, err := models.GetHosts(host, 1, 0)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get host:", host.Name),
+				"error":   err.Error(),
 			}
-
-			oasClient, err := common.NewOasClient(
-				records[0].BackupSet.Oas.Endpoint,
-			)
-			if err != nil {
-				h.Data["json"] = map[string]string{
-					"message": fmt.Sprint("Failed to connect to OAS"),
-					"error":   err.Error(),
-				}
-				beego.Warn("[C] Got error:", err)
-				h.Ctx.Output.SetStatus(http.StatusInternalServerError)
-				return
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(hosts) == 0 {
+			beego.Debug("[C] Got nothing with host:", host.Name)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		target, err := records[0].RestoreTarget(hosts[0], h.GetString("target"))
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Cannot restore to:", hosts[0].Name),
+				"error":   err.Error(),
 			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
 
-			oasJob.RequestId, oasJob.JobId, err = oasClient.RecoverToOss(
-				records[0].BackupSet.Oas.VaultId,
-				records[0].ArchiveId,
-				common.ConvertOssAddrToInternal(
-					records[0].BackupSet.Oss.Endpoint,
-				),
-				records[0].BackupSet.Oss.BucketName,
-				records[0].GetFullPath(),
-				records[0].GetFullPath(),
-			)
-			if err != nil {
-				h.Data["json"] = map[string]string{
-					"message": fmt.Sprint("Failed commit job:", id),
-					"error":   err.Error(),
-				}
-				beego.Warn("[C] Got error:", err)
-				h.Ctx.Output.SetStatus(http.StatusInternalServerError)
-				return
+		job := &models.RestoreJobs{
+			Record: records[0],
+			Host:   hosts[0],
+			Target: target,
+		}
+		_, err = models.AddRestoreJob(job)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to add restore job of:", id),
+				"error":   err.Error(),
 			}
-			id, err := models.AddOasJobs(oasJob)
-			if err != nil {
-				h.Data["json"] = map[string]string{
-					"message": fmt.Sprint("Failed to record added oas job"),
-					"error":   err.Error(),
-				}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		err = policies.StartRestoreJob(job)
+		if err != nil {
+			job.SetStage(models.RestoreStageFailed, err.Error())
+			if err := models.UpdateRestoreJob(job); err != nil {
 				beego.Warn("[C] Got error:", err)
-				h.Ctx.Output.SetStatus(http.StatusInternalServerError)
-				return
 			}
 			h.Data["json"] = map[string]string{
-				"job_id":  id,
-				"message": "This is archive, so some waiting is necessary.",
+				"message": fmt.Sprint("Failed to recover with record:", id),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+
+		if job.OasJob != nil {
+			h.Data["json"] = map[string]string{
+				"job_id":         job.OasJob.Id,
+				"restore_job_id": job.Id,
+				"message":        "This is archive, so some waiting is necessary.",
 			}
 			h.Ctx.Output.SetStatus(http.StatusAccepted)
-		case models.RecordTypeBackup:
-			signal := models.MakeDownloadSignal(
-				records[0].GetFullPath(),
-				records[0].BackupSet.Oss.Endpoint,
-				records[0].BackupSet.Oss.BucketName,
-			)
-			id, err := models.AddSignal(
-				records[0].Host.Id,
-				signal,
-			)
-			if err != nil {
-				beego.Warn("[C] Got error:", err)
+			return
+		}
+		h.Data["json"] = map[string]string{
+			"job_id":         job.SignalId,
+			"restore_job_id": job.Id,
+			"message":        "This is backup, so agent should be downloading now.",
+		}
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title holdRecord
+// @Description keep record from being deleted, body is like
+// {"reason": "...", "expire": "2017-01-01T00:00:00+08:00"}, expire is
+// optional.
+// @Success 200 {object} models.Records
+// @Failure 404
+// @router /:id/hold [put]
+func (h *RecordsController) Hold() {
+	id := h.GetString(":id")
+	beego.Debug("[C] Got id:", id)
+	defer h.ServeJSON()
+	if id != "" {
+		var req struct {
+			Reason string `json:"reason"`
+			Expire string `json:"expire"` // Format: RFC3339
+		}
+		err := json.Unmarshal(h.Ctx.Input.RequestBody, &req)
+		if err == nil && req.Reason == "" {
+			err = fmt.Errorf("Reason is required")
+		}
+		var expire time.Time
+		if err == nil && req.Expire != "" {
+			expire, err = time.Parse(time.RFC3339, req.Expire)
+		}
+		if err != nil {
+			beego.Warn("[C] Got error:", err)
+			h.Data["json"] = map[string]string{
+				"message": "Bad request",
+				"error":   err.Error(),
 			}
+			h.Ctx.Output.SetStatus(http.StatusBadRequest)
+			return
+		}
 
-			err = models.NotifySignal(
-				records[0].Host.Id,
-				id,
-			)
-			if err != nil {
-				h.Data["json"] = map[string]string{
-					"message": fmt.Sprint("Failed to recover file with record:", id),
-					"error":   err.Error(),
-				}
-				beego.Warn("[C] Got error:", err)
-				h.Ctx.Output.SetStatus(http.StatusInternalServerError)
-				return
+		records, err := models.GetRecords(&models.Records{Id: id}, 0, 0,
+			models.OrderAsc, models.OrderAsc)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with id:", id),
+				"error":   err.Error(),
 			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(records) == 0 {
+			beego.Debug("[C] Got nothing with id:", id)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+
+		record := records[0]
+		record.Hold = true
+		record.HoldReason = req.Reason
+		record.HoldBy = operator(&h.Controller)
+		record.HoldTime = time.Now()
+		record.HoldExpire = expire
+		err = models.UpdateRecord(record, models.RecordHoldColumns...)
+		if err != nil {
 			h.Data["json"] = map[string]string{
-				"job_id":  id,
-				"message": "This is backup, so agent should be downloading now.",
+				"message": fmt.Sprint("Failed to hold with id:", id),
+				"error":   err.Error(),
 			}
-			h.Ctx.Output.SetStatus(http.StatusOK)
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
 		}
+		beego.Info("[C] Record", id, "held by", record.HoldBy)
+		h.Data["json"] = record
+		h.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title releaseRecord
+// @Description release hold of record
+// @Success 204
+// @Failure 404
+// @router /:id/hold [delete]
+func (h *RecordsController) Release() {
+	id := h.GetString(":id")
+	beego.Debug("[C] Got id:", id)
+	defer h.ServeJSON()
+	if id != "" {
+		records, err := models.GetRecords(&models.Records{Id: id}, 0, 0,
+			models.OrderAsc, models.OrderAsc)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with id:", id),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(records) == 0 {
+			beego.Debug("[C] Got nothing with id:", id)
+			h.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+
+		record := records[0]
+		record.Hold = false
+		record.HoldReason = ""
+		record.HoldBy = ""
+		record.HoldTime = time.Time{}
+		record.HoldExpire = time.Time{}
+		err = models.UpdateRecord(record, models.RecordHoldColumns...)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to release with id:", id),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			h.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		beego.Info("[C] Record", id, "released by", operator(&h.Controller))
+		h.Ctx.Output.SetStatus(http.StatusNoContent)
 	}
 }
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+)
+
+func init() {
+	AddPrivilege("GET", "^/api/v1/reports", models.RoleFlagUser)
+}
+
+type ReportsController struct {
+	beego.Controller
+}
+
+func (h *ReportsController) Prepare() {
+	if h.Ctx.Input.Header("Signature") != "" {
+		err := common.AuthWithKey(h.Ctx)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"error": err.Error(),
+			}
+			h.Ctx.Output.SetStatus(http.StatusForbidden)
+			h.ServeJSON()
+		}
+	} else {
+		id := h.GetSession("id")
+		if id == nil {
+			h.Data["json"] = map[string]string{
+				"error": "You need login first.",
+			}
+			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
+			h.ServeJSON()
+		} else {
+			if !CheckPrivileges(id.(string), h.Ctx) {
+				h.Data["json"] = map[string]string{
+					"error": "No privileges.",
+				}
+				h.Ctx.Output.SetStatus(http.StatusForbidden)
+				h.ServeJSON()
+			}
+		}
+	}
+}
+
+// @Title getUsage
+// @Description objects, bytes and monthly cost now in each tier, by query
+// @Description by: total, appSet (default), host or backupSet.
+// @Success 200 {object} []models.UsageSnapshots
+// @router /usage [get]
+func (a *ReportsController) Usage() {
+	by := a.GetString("by", models.UsageByAppSet)
+	defer a.ServeJSON()
+	usage, err := models.GetUsage(by)
+	if err == models.ErrorUsageDimension {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Bad dimension:", by),
+			"error":   err.Error(),
+		}
+		a.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	a.Data["json"] = usage
+	a.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// @Title getUsageHistory
+// @Description daily snapshots of usage by query by as getUsage, of name,
+// @Description tier, and from start to end (RFC3339) if given.
+// @Success 200 {object} []models.UsageSnapshots
+// @router /usage/history [get]
+func (a *ReportsController) UsageHistory() {
+	by := a.GetString("by", models.UsageByAppSet)
+	name := a.GetString("name")
+	tier, _ := a.GetInt("tier", models.UsageTierAll)
+	// Format: RFC3339
+	start, _ := time.Parse(time.RFC3339, a.GetString("start"))
+	end, _ := time.Parse(time.RFC3339, a.GetString("end"))
+	defer a.ServeJSON()
+	snapshots, err := models.GetUsageSnapshots(by, name, tier, start, end)
+	if err == models.ErrorUsageDimension {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Bad dimension:", by),
+			"error":   err.Error(),
+		}
+		a.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	a.Data["json"] = snapshots
+	if len(snapshots) == 0 {
+		beego.Debug("[C] Got nothing")
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		a.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
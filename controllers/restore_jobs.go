@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+	"github.com/gorilla/websocket"
+)
+
+func init() {
+	AddPrivilege("GET", "^/api/v1/restoreJobs", models.RoleFlagUser)
+}
+
+type RestoreJobsController struct {
+	beego.Controller
+}
+
+func (h *RestoreJobsController) Prepare() {
+	if h.Ctx.Input.Header("Signature") != "" {
+		err := common.AuthWithKey(h.Ctx)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"error": err.Error(),
+			}
+			h.Ctx.Output.SetStatus(http.StatusForbidden)
+			h.ServeJSON()
+		}
+	} else {
+		id := h.GetSession("id")
+		if id == nil {
+			h.Data["json"] = map[string]string{
+				"error": "You need login first.",
+			}
+			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
+			h.ServeJSON()
+		} else {
+			if !CheckPrivileges(id.(string), h.Ctx) {
+				h.Data["json"] = map[string]string{
+					"error": "No privileges.",
+				}
+				h.Ctx.Output.SetStatus(http.StatusForbidden)
+				h.ServeJSON()
+			}
+		}
+	}
+}
+
+// @Title listRestoreJobs
+// @Description filtered by query stage and restore (id) if given.
+// @Success 200 {object} []models.RestoreJobs
+// @router / [get]
+func (a *RestoreJobsController) GetAll() {
+	limit, _ := a.GetInt("limit", 0)
+	index, _ := a.GetInt("index", 0)
+	stage, _ := a.GetInt("stage", models.RestoreStageAll)
+
+	defer a.ServeJSON()
+
+	restoreJob := &models.RestoreJobs{
+		Stage: stage,
+	}
+	if restore := a.GetString("restore"); restore != "" {
+		restoreJob.Restore = &models.Restores{Id: restore}
+	}
+	restoreJobs, err := models.GetRestoreJobs(restoreJob, limit, index)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	a.Data["json"] = restoreJobs
+	if len(restoreJobs) == 0 {
+		beego.Debug("[C] Got nothing")
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		a.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title getRestoreJob
+// @Success 200 {object} models.RestoreJobs
+// @router /:id [get]
+func (a *RestoreJobsController) Get() {
+	id := a.GetString(":id")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	if id != "" {
+		restoreJobs, err := models.GetRestoreJobs(
+			&models.RestoreJobs{Id: id}, 1, 0,
+		)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with id:", id),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(restoreJobs) == 0 {
+			beego.Debug("[C] Got nothing with id:", id)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		a.Data["json"] = restoreJobs[0]
+		a.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title watchRestoreJobs
+// @Description restore jobs are written as they change, only of restore
+// @Description (id) if given, which are all written first as well.
+// @router /ws [get]
+func (a *RestoreJobsController) WebSocket() {
+	restore := a.GetString("restore")
+	// Subscribe before upgrading and writing jobs there are, so no change
+	// is missed once client is connected.
+	ids, stop := models.SubscribeRestoreJobs()
+	defer stop()
+
+	ws, err := websocket.Upgrade(
+		a.Ctx.ResponseWriter, a.Ctx.Request,
+		nil, 1024, 1024)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": "Failed on upgrading to websocket",
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		a.ServeJSON()
+		return
+	}
+	defer ws.Close()
+
+	tick := beego.AppConfig.DefaultInt64("websocket::pingperiod", 5)
+	ticker := time.NewTicker(time.Duration(tick) * time.Second)
+	defer ticker.Stop()
+	timeout := time.Duration(
+		beego.AppConfig.DefaultInt64("websocket::timeout", 10),
+	) * time.Second
+	ws.SetReadDeadline(time.Now().Add(timeout))
+	ws.SetPongHandler(func(string) error {
+		ws.SetReadDeadline(time.Now().Add(timeout))
+		return nil
+	})
+
+	// Nothing is read but pongs and close.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, _, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(j *models.RestoreJobs) error {
+		ws.SetWriteDeadline(time.Now().Add(timeout))
+		return ws.WriteJSON(j)
+	}
+	if restore != "" {
+		restoreJobs, err := models.GetRestoreJobs(&models.RestoreJobs{
+			Restore: &models.Restores{Id: restore},
+		}, 0, 0)
+		if err != nil {
+			beego.Warn("[C] Got error:", err)
+			return
+		}
+		for _, v := range restoreJobs {
+			err = write(v)
+			if err != nil {
+				beego.Warn("[C] Got error:", err)
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case id := <-ids:
+			restoreJobs, err := models.GetRestoreJobs(
+				&models.RestoreJobs{Id: id}, 1, 0,
+			)
+			if err != nil {
+				beego.Warn("Cannot get restore job:", id, "error:", err)
+				continue
+			}
+			if len(restoreJobs) == 0 {
+				continue
+			}
+			j := restoreJobs[0]
+			if restore != "" && (j.Restore == nil || j.Restore.Id != restore) {
+				continue
+			}
+			err = write(j)
+			if err != nil {
+				beego.Warn("Cannot write restore job:", id, "error:", err)
+				return
+			}
+		case <-closed:
+			return
+		case <-ticker.C:
+			ws.SetWriteDeadline(time.Now().Add(timeout))
+			err := ws.WriteMessage(websocket.PingMessage, []byte{})
+			if err != nil {
+				beego.Warn("Got error on ping", err.Error())
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
+
+	"github.com/astaxie/beego"
+)
+
+func init() {
+	AddPrivilege("GET", "^/api/v1/restoreTests", models.RoleFlagUser)
+}
+
+type RestoreTestsController struct {
+	beego.Controller
+}
+
+func (h *RestoreTestsController) Prepare() {
+	if h.Ctx.Input.Header("Signature") != "" {
+		err := common.AuthWithKey(h.Ctx)
+		if err != nil {
+			h.Data["json"] = map[string]string{
+				"error": err.Error(),
+			}
+			h.Ctx.Output.SetStatus(http.StatusForbidden)
+			h.ServeJSON()
+		}
+	} else {
+		id := h.GetSession("id")
+		if id == nil {
+			h.Data["json"] = map[string]string{
+				"error": "You need login first.",
+			}
+			h.Ctx.Output.SetStatus(http.StatusUnauthorized)
+			h.ServeJSON()
+		} else {
+			if !CheckPrivileges(id.(string), h.Ctx) {
+				h.Data["json"] = map[string]string{
+					"error": "No privileges.",
+				}
+				h.Ctx.Output.SetStatus(http.StatusForbidden)
+				h.ServeJSON()
+			}
+		}
+	}
+}
+
+// @Title runRestoreTest
+// @Description tests records of backupSet (name) on host (name), or on
+// @Description misc::restoretesthost if not given.
+// @Success 201 {object} []models.RestoreTests
+// @router / [post]
+func (a *RestoreTestsController) Post() {
+	name := a.GetString("backupSet")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got backup set:", name)
+	backupSets, err := models.GetBackupSets(
+		&models.BackupSets{Name: name}, 1, 0,
+	)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get backup set:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	if name == "" || len(backupSets) == 0 {
+		beego.Debug("[C] Got no backup set:", name)
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	host, err := policies.RestoreTestHost(a.GetString("host"))
+	if err == policies.ErrorNoTestHost {
+		a.Data["json"] = map[string]string{
+			"message": "Failed to get test host",
+			"error":   err.Error(),
+		}
+		a.Ctx.Output.SetStatus(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": "Failed to get test host",
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	tests, err := policies.RunRestoreTest(backupSets[0], host)
+	if err == policies.ErrorNothingToTest {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Nothing to test in:", name),
+			"error":   err.Error(),
+		}
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to test:", name),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	a.Data["json"] = tests
+	a.Ctx.Output.SetStatus(http.StatusCreated)
+}
+
+// @Title listRestoreTests
+// @Description newest first, filtered by query backupSet (name), record
+// @Description (id) and result if given.
+// @Success 200 {object} []models.RestoreTests
+// @router / [get]
+func (a *RestoreTestsController) GetAll() {
+	limit, _ := a.GetInt("limit", 0)
+	index, _ := a.GetInt("index", 0)
+	result, _ := a.GetInt("result", models.RestoreTestAll)
+
+	defer a.ServeJSON()
+
+	restoreTest := &models.RestoreTests{
+		Result: result,
+	}
+	if name := a.GetString("backupSet"); name != "" {
+		backupSets, err := models.GetBackupSets(
+			&models.BackupSets{Name: name}, 1, 0,
+		)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get backup set:", name),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(backupSets) == 0 {
+			beego.Debug("[C] Got no backup set:", name)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		restoreTest.BackupSet = backupSets[0]
+	}
+	if record := a.GetString("record"); record != "" {
+		restoreTest.Record = &models.Records{Id: record}
+	}
+	restoreTests, err := models.GetRestoreTests(restoreTest, limit, index)
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	a.Data["json"] = restoreTests
+	if len(restoreTests) == 0 {
+		beego.Debug("[C] Got nothing")
+		a.Ctx.Output.SetStatus(http.StatusNotFound)
+	} else {
+		a.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
+
+// @Title summarizeRestoreTests
+// @Description counts of tests passed and failed, and result of latest,
+// @Description of each backup set.
+// @Success 200 {object} []models.RestoreTestSummaries
+// @router /summary [get]
+func (a *RestoreTestsController) Summary() {
+	defer a.ServeJSON()
+	summaries, err := models.GetRestoreTestSummaries()
+	if err != nil {
+		a.Data["json"] = map[string]string{
+			"message": fmt.Sprint("Failed to get"),
+			"error":   err.Error(),
+		}
+		beego.Warn("[C] Got error:", err)
+		a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+		return
+	}
+	a.Data["json"] = summaries
+	a.Ctx.Output.SetStatus(http.StatusOK)
+}
+
+// @Title getRestoreTest
+// @Success 200 {object} models.RestoreTests
+// @router /:id [get]
+func (a *RestoreTestsController) Get() {
+	id := a.GetString(":id")
+	defer a.ServeJSON()
+	beego.Debug("[C] Got id:", id)
+	if id != "" {
+		restoreTests, err := models.GetRestoreTests(
+			&models.RestoreTests{Id: id}, 1, 0,
+		)
+		if err != nil {
+			a.Data["json"] = map[string]string{
+				"message": fmt.Sprint("Failed to get with id:", id),
+				"error":   err.Error(),
+			}
+			beego.Warn("[C] Got error:", err)
+			a.Ctx.Output.SetStatus(http.StatusInternalServerError)
+			return
+		}
+		if len(restoreTests) == 0 {
+			beego.Debug("[C] Got nothing with id:", id)
+			a.Ctx.Output.SetStatus(http.StatusNotFound)
+			return
+		}
+		a.Data["json"] = restoreTests[0]
+		a.Ctx.Output.SetStatus(http.StatusOK)
+	}
+}
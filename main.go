@@ -14,6 +14,7 @@ import (
 	"os"
 
 	_ "github.com/ModuleAB/ModuleAB/server/docs"
+	"github.com/ModuleAB/ModuleAB/server/models"
 	"github.com/ModuleAB/ModuleAB/server/policies"
 	_ "github.com/ModuleAB/ModuleAB/server/routers"
 	"github.com/ModuleAB/ModuleAB/server/version"
@@ -117,6 +118,9 @@ func main() {
 		err = orm.RunSyncdb("default", false, false)
 	}
 
+	if err == nil {
+		err = models.MigrateOasJobs()
+	}
 	if err != nil {
 		beego.Alert("Database error:", err, ". go exit.")
 		os.Exit(1)
@@ -129,6 +133,20 @@ func main() {
 	)
 	beego.Info("Run check oas job...")
 	go policies.CheckOasJob()
+	beego.Info("Run check signals...")
+	go policies.CheckSignals()
+	beego.Info("Run check inventory...")
+	go policies.CheckInventory()
+	beego.Info("Run check buckets...")
+	go policies.CheckBuckets()
+	beego.Info("Run check records...")
+	go policies.CheckRecords()
+	beego.Info("Run check restore tests...")
+	go policies.CheckRestoreTests()
+	beego.Info("Run check usage...")
+	go policies.CheckUsage()
+	beego.Info("Schedule policies...")
+	policies.StartScheduler()
 	beego.Info("All is ready, go running...")
 	beego.BConfig.WebConfig.Session.SessionOn = true
 	beego.BConfig.WebConfig.Session.SessionName = "Session_MobuleAB"
@@ -13,13 +13,23 @@ import (
 
 type Oas struct {
 	Id         string        `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
-	Endpoint   string        `json:"endpoint" valid:"Required"`
+	Driver     string        `orm:"size(16);default(oas)" json:"driver"` // oas, local
+	Endpoint   string        `json:"endpoint" valid:"Required"`          // For local, this is a directory
 	VaultName  string        `orm:"size(32) json:"vaultName" valid:"Required"`
 	VaultId    string        `orm:"size(32) json:"vaultId" valid:"Required"`
 	BackupSets []*BackupSets `orm:"reverse(many)"`
 	Jobs       []*OasJobs    `orm:"reverse(many)"`
 }
 
+// Archive connects to the vault with the driver chosen by this row.
+func (a *Oas) Archive() (common.Archive, error) {
+	return common.NewArchive(&common.ArchiveConfig{
+		Driver:   a.Driver,
+		Endpoint: a.Endpoint,
+		VaultId:  a.VaultId,
+	})
+}
+
 func init() {
 	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
 		orm.RegisterModelWithPrefix(prefix, new(Oas))
@@ -39,6 +49,9 @@ func AddOas(a *Oas) (string, error) {
 
 	a.Id = uuid.New()
 	beego.Debug("[M] Got new id:", a.Id)
+	if a.Driver == "" {
+		a.Driver = common.DefaultArchiveDriver
+	}
 	validator := new(validation.Validation)
 	valid, err := validator.Valid(a)
 	if err != nil {
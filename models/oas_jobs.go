@@ -1,6 +1,7 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -21,19 +22,34 @@ const (
 	OasJobTypeDeleteArchive
 )
 
+// States of oas job. A failed job is pending till it is submitted again,
+// after a while longer each time, and fails when out of attempts.
 const (
-	OasJobStatusComplete   = true
-	OasJobStatusIncomplete = false
+	OasJobStateAll = iota
+	OasJobStatePending
+	OasJobStateRunning
+	OasJobStateSucceeded
+	OasJobStateFailed
+	OasJobStateCancelled
 )
 
+// ErrorOasJobOver is returned when cancelling job which is over.
+var ErrorOasJobOver = errors.New("Oas job is succeeded, failed or cancelled")
+
 type OasJobs struct {
 	Id          string    `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
 	Vault       *Oas      `orm:"rel(fk)" json:"vault" valid:"Required"`
 	RequestId   string    `json:"request_id valid:"Required"`
 	JobId       string    `json:"job_id" valid:"Required"`
 	JobType     int       `json:"job_type" valid:"Required"`
-	Status      bool      `orm:"default(0)"`
-	Records     *Records  `orm:"rel(fk);null" valid:"Required"`
+	State       int       `orm:"default(2);index" json:"state"`
+	Message     string    `orm:"size(255);null" json:"message"` // Why it failed last
+	Attempts    int       `orm:"default(1)" json:"attempts"`    // Times submitted
+	NextRetry   time.Time `orm:"type(datetime);null" json:"nextretry"`
+	Records     *Records  `orm:"rel(fk);null;on_delete(set_null)"`                   // Nil for inventory, or when deleted
+	ArchiveId   string    `orm:"size(128);null;index" json:"archive_id"`             // Archive deleted
+	TargetHost  *Hosts    `orm:"rel(fk);null;on_delete(set_null)" json:"targethost"` // Host to restore to, nil means host of record
+	Target      string    `orm:"null" json:"target"`                                 // Directory to restore into
 	CreatedTime time.Time `orm:"type(datetime)"`
 }
 
@@ -45,6 +61,68 @@ func init() {
 	}
 }
 
+// MigrateOasJobs gives jobs saved before states their state. They had
+// status 1 if complete, which are succeeded now, others are running as
+// state defaults to. Jobs made since never have status 1, so it may run
+// again. Database made since has no status column, nothing is done.
+func MigrateOasJobs() error {
+	table := beego.AppConfig.String("database::mysqlprefex") + "oas_jobs"
+	o := orm.NewOrm()
+	var n int64
+	err := o.Raw("SELECT COUNT(*) FROM " + table + " WHERE status = 1").
+		QueryRow(&n)
+	if err != nil {
+		beego.Debug("[M] No status of oas jobs to migrate:", err)
+		return nil
+	}
+	if n == 0 {
+		return nil
+	}
+	r, err := o.Raw(
+		"UPDATE "+table+" SET state = ? WHERE status = 1 AND state = ?",
+		OasJobStateSucceeded, OasJobStateRunning,
+	).Exec()
+	if err != nil {
+		return err
+	}
+	n, err = r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	beego.Info("[M] Oas jobs migrated to succeeded:", n)
+	return nil
+}
+
+// IsOver tells if job will not be checked or submitted again.
+func (a *OasJobs) IsOver() bool {
+	return a.State == OasJobStateSucceeded ||
+		a.State == OasJobStateFailed ||
+		a.State == OasJobStateCancelled
+}
+
+// Retry notes job failed for reason. It is pending again if attempts
+// (misc::oasjobretries) are left, after misc::oasjobbackoff seconds
+// doubled each attempt, and true is returned. Otherwise it is failed.
+func (a *OasJobs) Retry(reason string) bool {
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	a.Message = reason
+	if a.Attempts >= beego.AppConfig.DefaultInt("misc::oasjobretries", 3) {
+		a.State = OasJobStateFailed
+		return false
+	}
+	backoff := time.Duration(
+		beego.AppConfig.DefaultInt64("misc::oasjobbackoff", 300),
+	) * time.Second
+	for i := 1; i < a.Attempts && backoff < 24*time.Hour; i++ {
+		backoff *= 2
+	}
+	a.State = OasJobStatePending
+	a.NextRetry = time.Now().Add(backoff)
+	return true
+}
+
 func AddOasJobs(a *OasJobs) (string, error) {
 	beego.Debug("[M] Got data:", a)
 	o := orm.NewOrm()
@@ -56,6 +134,8 @@ func AddOasJobs(a *OasJobs) (string, error) {
 	a.Id = uuid.New()
 	beego.Debug("[M] Got new id:", a.Id)
 	a.CreatedTime = time.Now()
+	a.State = OasJobStateRunning
+	a.Attempts = 1
 
 	validator := new(validation.Validation)
 	valid, err := validator.Valid(a)
@@ -162,6 +242,12 @@ func GetOasJobs(cond *OasJobs, limit, index int) ([]*OasJobs, error) {
 	if cond.JobType != OasJobTypeAll {
 		q = q.Filter("job_type", cond.JobType)
 	}
+	if cond.State != OasJobStateAll {
+		q = q.Filter("state", cond.State)
+	}
+	if cond.ArchiveId != "" {
+		q = q.Filter("archive_id", cond.ArchiveId)
+	}
 	if limit > 0 {
 		q = q.Limit(limit)
 	}
@@ -175,3 +261,15 @@ func GetOasJobs(cond *OasJobs, limit, index int) ([]*OasJobs, error) {
 	}
 	return r, nil
 }
+
+// LastOasJobTime is when latest job of jobType is created, zero if none is.
+func LastOasJobTime(jobType int) (time.Time, error) {
+	r := make([]*OasJobs, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("oas_jobs").Filter("job_type", jobType).
+		OrderBy("-created_time").Limit(1).All(&r, "CreatedTime")
+	if err != nil || len(r) == 0 {
+		return time.Time{}, err
+	}
+	return r[0].CreatedTime, nil
+}
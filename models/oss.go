@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/ModuleAB/ModuleAB/server/common"
@@ -13,11 +14,49 @@ import (
 
 type Oss struct {
 	Id         string        `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
-	Endpoint   string        `json:"endpoint" valid:"Required"`
+	Driver     string        `orm:"size(16);default(aliyun)" json:"driver"` // aliyun, local, s3
+	Endpoint   string        `json:"endpoint" valid:"Required"`             // For local, this is a directory
 	BucketName string        `orm:"size(32);index;unique" json:"bucket" valid:"Required"`
+	Region     string        `orm:"size(32);null" json:"region"`
+	AccessKey  string        `orm:"size(128);null" json:"accesskey"` // Empty means use aliapi
+	SecretKey  string        `orm:"size(128);null" json:"secretkey,omitempty"`
+	PathStyle  bool          `orm:"default(0)" json:"pathstyle"`
+	UseSSL     bool          `orm:"default(0)" json:"usessl"`
 	BackupSets []*BackupSets `orm:"reverse(many)"`
 }
 
+// MarshalJSON never shows secret key to API users.
+func (a Oss) MarshalJSON() ([]byte, error) {
+	type oss Oss
+	v := oss(a)
+	v.SecretKey = ""
+	return json.Marshal(v)
+}
+
+// String never shows secret key in logs.
+func (a Oss) String() string {
+	type oss Oss
+	v := oss(a)
+	if v.SecretKey != "" {
+		v.SecretKey = "******"
+	}
+	return fmt.Sprint(v)
+}
+
+// Storage connects to the bucket with the driver chosen by this row.
+func (a *Oss) Storage() (common.Storage, error) {
+	return common.NewStorage(&common.StorageConfig{
+		Driver:    a.Driver,
+		Endpoint:  a.Endpoint,
+		Bucket:    a.BucketName,
+		Region:    a.Region,
+		AccessKey: a.AccessKey,
+		SecretKey: a.SecretKey,
+		PathStyle: a.PathStyle,
+		UseSSL:    a.UseSSL,
+	})
+}
+
 func init() {
 	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
 		orm.RegisterModelWithPrefix(prefix, new(Oss))
@@ -36,6 +75,9 @@ func AddOss(a *Oss) (string, error) {
 
 	a.Id = uuid.New()
 	beego.Debug("[M] Got new id:", a.Id)
+	if a.Driver == "" {
+		a.Driver = common.DefaultStorageDriver
+	}
 	validator := new(validation.Validation)
 	valid, err := validator.Valid(a)
 	if err != nil {
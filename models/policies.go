@@ -1,8 +1,8 @@
 package models
 
 import (
-	//"time"
 	"fmt"
+	"time"
 
 	"github.com/ModuleAB/ModuleAB/server/common"
 
@@ -10,6 +10,7 @@ import (
 	"github.com/astaxie/beego/orm"
 	"github.com/astaxie/beego/validation"
 	"github.com/pborman/uuid"
+	"github.com/robfig/cron"
 )
 
 const (
@@ -34,20 +35,83 @@ const (
 	PolicyTargetTimeNow         = 0
 )
 
+const (
+	PolicyRetentionStep  = iota // Thin records by Step
+	PolicyRetentionGFS          // Keep daily, weekly, monthly and yearly ones
+	PolicyRetentionCount        // Keep latest KeepCount ones
+)
+
 //策略
 type Policies struct {
-	Id          string      `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
-	Name        string      `orm:"size(32);uniq" json:"name" valid:"Required"`
-	Desc        string      `orm:"size(128);null" json:"description"`
-	BackupSet   *BackupSets `orm:"rel(fk)" json:"backupset"`
-	AppSets     []*AppSets  `orm:"rel(m2m);null" json:"appsets"` // null means all
-	Hosts       []*Hosts    `orm:"rel(m2m);null" json:"hosts"`
-	Paths       []*Paths    `orm:"rel(m2m);null" json:"paths"`
-	Target      int         `json:"target"`
-	Action      int         `json:"action"`
-	TargetStart int         `orm:"default(0)" json:"starttime"` // Seconds, 0 means now
-	TargetEnd   int         `orm:"default(-1)" json:"endtime"`  // Seconds, -1 means long long ago
-	Step        int         `orm:"default(-1)" json:"step"`     // Seconds, 0 means reserve none, -1 means all
+	Id          string        `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Name        string        `orm:"size(32);uniq" json:"name" valid:"Required"`
+	Desc        string        `orm:"size(128);null" json:"description"`
+	BackupSet   *BackupSets   `orm:"rel(fk)" json:"backupset"`
+	AppSets     []*AppSets    `orm:"rel(m2m);null" json:"appsets"` // null means all
+	Hosts       []*Hosts      `orm:"rel(m2m);null" json:"hosts"`
+	Paths       []*Paths      `orm:"rel(m2m);null" json:"paths"`
+	Target      int           `json:"target"`
+	Action      int           `json:"action"`
+	TargetStart int           `orm:"default(0)" json:"starttime"`   // Seconds, 0 means now
+	TargetEnd   int           `orm:"default(-1)" json:"endtime"`    // Seconds, -1 means long long ago
+	Step        int           `orm:"default(-1)" json:"step"`       // Seconds, 0 means reserve none, -1 means all
+	Schedule    string        `orm:"size(64);null" json:"schedule"` // "s m h dom mon dow", empty means misc::policyrun
+	Enabled     bool          `orm:"default(1)" json:"enabled"`
+	TimeZone    string        `orm:"size(64);null" json:"timezone"` // Like "Asia/Shanghai", empty means local
+	Retention   int           `orm:"default(0)" json:"retention"`   // 0 - Step, 1 - GFS, 2 - Count
+	KeepDaily   int           `orm:"default(0)" json:"keepdaily"`
+	KeepWeekly  int           `orm:"default(0)" json:"keepweekly"`
+	KeepMonthly int           `orm:"default(0)" json:"keepmonthly"`
+	KeepYearly  int           `orm:"default(0)" json:"keepyearly"`
+	KeepCount   int           `orm:"default(0)" json:"keepcount"`
+	Runs        []*PolicyRuns `orm:"reverse(many)" json:"-"`
+}
+
+// GetSchedule returns cron spec of the policy, misc::policyrun if not set.
+func (a *Policies) GetSchedule() string {
+	if a.Schedule != "" {
+		return a.Schedule
+	}
+	return beego.AppConfig.String("misc::policyrun")
+}
+
+// GetLocation returns time zone which schedule runs in.
+func (a *Policies) GetLocation() (*time.Location, error) {
+	if a.TimeZone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(a.TimeZone)
+}
+
+func (a *Policies) check() error {
+	switch a.Retention {
+	case PolicyRetentionStep:
+	case PolicyRetentionGFS:
+		if a.KeepDaily < 0 || a.KeepWeekly < 0 ||
+			a.KeepMonthly < 0 || a.KeepYearly < 0 {
+			return fmt.Errorf("Keep count cannot be negative")
+		}
+		if a.KeepDaily+a.KeepWeekly+a.KeepMonthly+a.KeepYearly == 0 {
+			return fmt.Errorf("GFS retention keeps nothing")
+		}
+	case PolicyRetentionCount:
+		if a.KeepCount <= 0 {
+			return fmt.Errorf("Keep count should be more than 0")
+		}
+	default:
+		return fmt.Errorf("Unknown retention: %d", a.Retention)
+	}
+	if a.Schedule != "" {
+		_, err := cron.Parse(a.Schedule)
+		if err != nil {
+			return fmt.Errorf("Invalid schedule: %s", err)
+		}
+	}
+	_, err := a.GetLocation()
+	if err != nil {
+		return fmt.Errorf("Invalid time zone: %s", err)
+	}
+	return nil
 }
 
 func init() {
@@ -82,6 +146,11 @@ func AddPolicy(a *Policies) (string, error) {
 		}
 		return "", fmt.Errorf("Bad info: %s", errS)
 	}
+	err = a.check()
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
 	beego.Debug("[M] Got new data:", a)
 	_, err = o.Insert(a)
 	if err != nil {
@@ -180,6 +249,11 @@ func UpdatePolicy(a *Policies) error {
 		}
 		return fmt.Errorf("Bad info: %s", errS)
 	}
+	err = a.check()
+	if err != nil {
+		o.Rollback()
+		return err
+	}
 	_, err = o.Update(a)
 	if err != nil {
 		o.Rollback()
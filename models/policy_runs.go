@@ -0,0 +1,147 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+// PolicyRuns is what a run of policy did.
+type PolicyRuns struct {
+	Id        string    `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Policy    *Policies `orm:"rel(fk)" json:"policy" valid:"Required"`
+	StartTime time.Time `orm:"type(datetime);index" json:"starttime"`
+	EndTime   time.Time `orm:"type(datetime);null" json:"endtime"` // Zero means still running
+	Archived  int       `orm:"default(0)" json:"archived"`
+	Deleted   int       `orm:"default(0)" json:"deleted"`
+	Skipped   int       `orm:"default(0)" json:"skipped"`
+	Failed    int       `orm:"default(0)" json:"failed"`
+	Error     string    `orm:"size(255);null" json:"error"` // Why the run failed as a whole
+	Log       string    `orm:"type(text);null" json:"log"`  // One line per failed record
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(PolicyRuns))
+	} else {
+		orm.RegisterModel(new(PolicyRuns))
+	}
+}
+
+// AddLog notes a record failed.
+func (a *PolicyRuns) AddLog(recordId, action string, err error) {
+	a.Failed++
+	a.Log = fmt.Sprintf("%s%s %s: %s\n", a.Log, recordId, action, err)
+}
+
+func AddPolicyRun(a *PolicyRuns) (string, error) {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	a.Id = uuid.New()
+	beego.Debug("[M] Got new id:", a.Id)
+	if a.StartTime.IsZero() {
+		a.StartTime = time.Now()
+	}
+
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return "", fmt.Errorf("Bad info: %s", errS)
+	}
+	_, err = o.Insert(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	beego.Debug("[M] PolicyRuns info saved")
+	o.Commit()
+	return a.Id, nil
+}
+
+func UpdatePolicyRun(a *PolicyRuns) error {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return fmt.Errorf("Bad info: %s", errS)
+	}
+	_, err = o.Update(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	o.Commit()
+	return nil
+}
+
+// If get all, just use &PolicyRuns{}. Latest run comes first.
+func GetPolicyRuns(cond *PolicyRuns, limit, index int) ([]*PolicyRuns, error) {
+	r := make([]*PolicyRuns, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("policy_runs")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Policy != nil {
+		if cond.Policy.Id != "" {
+			q = q.Filter("policy_id", cond.Policy.Id)
+		} else if cond.Policy.Name != "" {
+			policy := &Policies{
+				Name: cond.Policy.Name,
+			}
+			policies, err := GetPolicies(policy, 1, 0)
+			if err != nil {
+				return nil, err
+			}
+			if len(policies) == 0 {
+				return r, nil
+			}
+			q = q.Filter("policy_id", policies[0].Id)
+		}
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("-start_time").RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+// Problems found by reconciliation.
+const (
+	ReconcileProblemAll     = iota
+	ReconcileProblemOrphan  // Stored, but no record has it
+	ReconcileProblemMissing // Record has it, but not stored
+)
+
+// Reconciliations compares archives in a vault, or objects in a bucket,
+// with records.
+type Reconciliations struct {
+	Id          string            `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Vault       *Oas              `orm:"rel(fk);null" json:"vault"`
+	Oss         *Oss              `orm:"rel(fk);null" json:"oss"`
+	JobId       string            `orm:"null" json:"jobid"` // Inventory retrieval job of vault
+	ListedTime  time.Time         `orm:"type(datetime)" json:"listedtime"`
+	Stored      int               `json:"stored"`  // Archives or objects listed
+	Records     int               `json:"records"` // Compared with them
+	Orphans     int               `json:"orphans"`
+	Missing     int               `json:"missing"`
+	Fix         bool              `orm:"default(0)" json:"fix"` // Fixing was asked for
+	Fixed       int               `json:"fixed"`
+	CreatedTime time.Time         `orm:"type(datetime)" json:"createdtime"`
+	Items       []*ReconcileItems `orm:"reverse(many)" json:"items"`
+}
+
+// ReconcileItems is an orphan or missing archive or object.
+type ReconcileItems struct {
+	Id             string           `orm:"pk;size(36)" json:"id"`
+	Reconciliation *Reconciliations `orm:"rel(fk)" json:"-"`
+	Problem        int              `json:"problem" valid:"Range(1,2)"`
+	Key            string           `orm:"size(1024)" json:"key" valid:"Required"` // Archive id or object key
+	Description    string           `orm:"size(1024);null" json:"description"`
+	Size           int64            `json:"size"`
+	Record         *Records         `orm:"rel(fk);null;on_delete(set_null)" json:"record"` // Of missing one
+	Fixed          bool             `orm:"default(0)" json:"fixed"`
+	Error          string           `orm:"size(255);null" json:"error"` // Why it was not fixed
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(Reconciliations), new(ReconcileItems))
+	} else {
+		orm.RegisterModel(new(Reconciliations), new(ReconcileItems))
+	}
+}
+
+// AddReconciliation saves reconciliation with its items, counting them.
+func AddReconciliation(a *Reconciliations) (string, error) {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	a.Id = uuid.New()
+	beego.Debug("[M] Got new id:", a.Id)
+	a.CreatedTime = time.Now()
+	a.Orphans, a.Missing, a.Fixed = 0, 0, 0
+	check := []interface{}{a}
+	if (a.Vault == nil) == (a.Oss == nil) {
+		o.Rollback()
+		return "", errors.New("Bad info: Either vault or oss is required")
+	}
+	for _, v := range a.Items {
+		v.Id = uuid.New()
+		v.Reconciliation = &Reconciliations{Id: a.Id}
+		switch v.Problem {
+		case ReconcileProblemOrphan:
+			a.Orphans++
+		case ReconcileProblemMissing:
+			a.Missing++
+		}
+		if v.Fixed {
+			a.Fixed++
+		}
+		check = append(check, v)
+	}
+
+	validator := new(validation.Validation)
+	for _, v := range check {
+		valid, err := validator.Valid(v)
+		if err != nil {
+			o.Rollback()
+			return "", err
+		}
+		if !valid {
+			o.Rollback()
+			var errS string
+			for _, err := range validator.Errors {
+				errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+			}
+			return "", fmt.Errorf("Bad info: %s", errS)
+		}
+	}
+	_, err = o.Insert(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	for _, v := range a.Items {
+		_, err = o.Insert(v)
+		if err != nil {
+			o.Rollback()
+			return "", err
+		}
+	}
+	beego.Debug("[M] Reconciliations info saved")
+	o.Commit()
+	return a.Id, nil
+}
+
+// GetReconciliations gets newest first. Items are loaded only when getting
+// by id, there may be many of them.
+func GetReconciliations(cond *Reconciliations, limit, index int) ([]*Reconciliations, error) {
+	r := make([]*Reconciliations, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("reconciliations")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Vault != nil && cond.Vault.Id != "" {
+		q = q.Filter("vault_id", cond.Vault.Id)
+	}
+	if cond.Oss != nil && cond.Oss.Id != "" {
+		q = q.Filter("oss_id", cond.Oss.Id)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("-created_time").RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	if cond.Id != "" {
+		for _, v := range r {
+			o.LoadRelated(v, "Items", common.RelDepth)
+		}
+	}
+	return r, nil
+}
+
+// LastBucketReconciliation is when latest reconciliation of a bucket is
+// made, zero if none is.
+func LastBucketReconciliation() (time.Time, error) {
+	r := make([]*Reconciliations, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("reconciliations").Filter("oss_id__isnull", false).
+		OrderBy("-created_time").Limit(1).All(&r, "CreatedTime")
+	if err != nil || len(r) == 0 {
+		return time.Time{}, err
+	}
+	return r[0].CreatedTime, nil
+}
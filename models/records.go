@@ -2,7 +2,9 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"path"
 	"strings"
 	"time"
 
@@ -25,6 +27,23 @@ const (
 	OrderDesc = true
 )
 
+var (
+	// ErrorRecordHeld is returned when deleting a record on hold.
+	ErrorRecordHeld = errors.New("Record is held")
+	// ErrorRestoreTarget is returned when restoring to a directory which
+	// is not a path of host.
+	ErrorRestoreTarget = errors.New("Target is not under any path of host")
+)
+
+// Results of verifying backup in storage.
+const (
+	RecordVerifyAll     = iota
+	RecordVerifyPending // Not verified yet
+	RecordVerifyOk
+	RecordVerifyMismatch // Size or checksum is not as recorded
+	RecordVerifyMissing  // Object is gone
+)
+
 const (
 	backupTimeStart = iota
 	backupTimeEnd
@@ -43,20 +62,100 @@ type Records struct {
 	ArchiveId    string      `orm:"null" json:"archiveid"`  // 如果Type是1（归档）时，这里应该有数据
 	BackupTime   time.Time   `orm:"type(datetime)" json:"backuptime"`
 	ArchivedTime time.Time   `orm:"type(datatime);null" json:"archivedtime"`
+	RetrieveTime time.Time   `orm:"type(datetime);null" json:"retrievetime"` // Pushed back to OSS from archive
 	Jobs         []*OasJobs  `orm:"reverse(many);null" json:"jobs"`
+	Hold         bool        `orm:"default(0)" json:"hold"`
+	HoldReason   string      `orm:"size(255);null" json:"holdreason"`
+	HoldBy       string      `orm:"size(64);null" json:"holdby"`
+	HoldTime     time.Time   `orm:"type(datetime);null" json:"holdtime"`
+	HoldExpire   time.Time   `orm:"type(datetime);null" json:"holdexpire"` // Zero means never
+	SignalId     string      `orm:"size(36);null;index" json:"signalid"`   // Backup signal made it
+	// Checksums are from storage when verified first, unless agent gave
+	// them, except SHA-256 which only agent gives.
+	Size          int64     `orm:"default(0)" json:"size"`
+	Md5           string    `orm:"size(32);null" json:"md5"`   // Hex
+	Crc64         string    `orm:"size(20);null" json:"crc64"` // CRC-64/ECMA in decimal
+	Sha256        string    `orm:"size(64);null" json:"sha256"`
+	StorageClass  string    `orm:"size(32);null" json:"storageclass"`
+	ETag          string    `orm:"size(64);null" json:"etag"`
+	VerifyState   int       `orm:"default(1);index" json:"verifystate"`
+	VerifyMessage string    `orm:"size(255);null" json:"verifymessage"` // What is not as recorded
+	VerifiedTime  time.Time `orm:"type(datetime);null" json:"verifiedtime"`
+}
+
+// IsHeld tells if r is on hold now, which keeps it from being deleted.
+func (r *Records) IsHeld() bool {
+	return r.Hold && (r.HoldExpire.IsZero() || time.Now().Before(r.HoldExpire))
+}
+
+// RecordHoldColumns are fields of hold, saved alone by holding and
+// releasing so that what others changed meanwhile is kept.
+var RecordHoldColumns = []string{
+	"Hold", "HoldReason", "HoldBy", "HoldTime", "HoldExpire",
+}
+
+// ReloadHold reads hold of r from database again, r may have been held
+// since it was loaded. Record deleted since is not held.
+func ReloadHold(r *Records) error {
+	fresh := &Records{Id: r.Id}
+	err := orm.NewOrm().Read(fresh)
+	if err == orm.ErrNoRows {
+		r.Hold = false
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.Hold, r.HoldReason, r.HoldBy = fresh.Hold, fresh.HoldReason, fresh.HoldBy
+	r.HoldTime, r.HoldExpire = fresh.HoldTime, fresh.HoldExpire
+	return nil
+}
+
+// HoldInfo says who held r and why.
+func (r *Records) HoldInfo() string {
+	s := fmt.Sprintf("Held by %s: %s", r.HoldBy, r.HoldReason)
+	if !r.HoldExpire.IsZero() {
+		s = fmt.Sprintf("%s, until %s", s, r.HoldExpire.Format(time.RFC3339))
+	}
+	return s
+}
+
+// RestoreTarget checks r may be restored onto host into dir, which must be
+// one of host's paths or under one. Paths of host must be loaded. Empty
+// dir means path of r, the directory cleaned is returned.
+func (r *Records) RestoreTarget(host *Hosts, dir string) (string, error) {
+	if dir == "" {
+		dir = r.Path.Path
+	}
+	if !strings.HasPrefix(dir, "/") {
+		return "", ErrorRestoreTarget
+	}
+	dir = path.Clean(dir)
+	// Where it is from is always fine.
+	if host.Id == r.Host.Id && dir == path.Clean(r.Path.Path) {
+		return dir, nil
+	}
+	for _, v := range host.Paths {
+		p := path.Clean(v.Path)
+		if p == "/" || dir == p || strings.HasPrefix(dir, p+"/") {
+			return dir, nil
+		}
+	}
+	return "", ErrorRestoreTarget
 }
 
 func (r *Records) GetFullPath() string {
 	return strings.TrimSpace(
-		fmt.Sprintf("%s/%s%s/%s",
-			r.AppSet.Name,
-			r.Host.Name,
-			r.Path.Path,
-			r.Filename,
-		),
+		StoragePrefix(r.AppSet.Name, r.Host.Name, r.Path.Path) + r.Filename,
 	)
 }
 
+// StoragePrefix is where backups of path on host in app set are kept in
+// bucket, keys are it followed by file name.
+func StoragePrefix(appSet, host, path string) string {
+	return fmt.Sprintf("%s/%s%s/", appSet, host, path)
+}
+
 func init() {
 	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
 		orm.RegisterModelWithPrefix(prefix, new(Records))
@@ -85,6 +184,10 @@ func AddRecord(record *Records) (string, error) {
 		record.Id = uuid.New()
 		beego.Debug("[M] Got id:", record.Id)
 	}
+	// Backup uploaded again is verified again.
+	record.VerifyState = RecordVerifyPending
+	record.Md5 = strings.ToLower(record.Md5)
+	record.Sha256 = strings.ToLower(record.Sha256)
 
 	validator := new(validation.Validation)
 	valid, err := validator.Valid(record)
@@ -122,8 +225,15 @@ func AddRecord(record *Records) (string, error) {
 
 func DeleteRecord(h *Records) error {
 	beego.Debug("[M] Got data:", h)
+	err := ReloadHold(h)
+	if err != nil {
+		return err
+	}
+	if h.IsHeld() {
+		return ErrorRecordHeld
+	}
 	o := orm.NewOrm()
-	err := o.Begin()
+	err = o.Begin()
 	if err != nil {
 		return err
 	}
@@ -150,7 +260,9 @@ func DeleteRecord(h *Records) error {
 	return nil
 }
 
-func UpdateRecord(h *Records) error {
+// UpdateRecord saves record, only fields named by cols if given, so that
+// what others changed meanwhile is kept.
+func UpdateRecord(h *Records, cols ...string) error {
 	beego.Debug("[M] Got data:", h)
 	o := orm.NewOrm()
 	err := o.Begin()
@@ -171,7 +283,7 @@ func UpdateRecord(h *Records) error {
 		}
 		return fmt.Errorf("Bad info: %s", errS)
 	}
-	_, err = o.Update(h)
+	_, err = o.Update(h, cols...)
 	if err != nil {
 		o.Rollback()
 		return err
@@ -195,6 +307,12 @@ func GetRecords(cond *Records, limit, index int, orderB, orderA bool,
 	if cond.ArchiveId != "" {
 		q = q.Filter("archive_id", cond.ArchiveId)
 	}
+	if cond.SignalId != "" {
+		q = q.Filter("signal_id", cond.SignalId)
+	}
+	if cond.VerifyState != RecordVerifyAll {
+		q = q.Filter("verify_state", cond.VerifyState)
+	}
 	if cond.Path != nil {
 		if cond.Path.Path != "" {
 			path := &Paths{
@@ -287,3 +405,122 @@ func GetRecords(cond *Records, limit, index int, orderB, orderA bool,
 	}
 	return r, nil
 }
+
+// LatestRecords gets latest record at or before at of each host and path
+// of app set, to restore app set as it was then. Latest ones are picked in
+// database, history of app set may be long.
+func LatestRecords(appSetId string, at time.Time) ([]*Records, error) {
+	r := make([]*Records, 0)
+	table := beego.AppConfig.String("database::mysqlprefex") + "records"
+	o := orm.NewOrm()
+	var ids orm.ParamsList
+	_, err := o.Raw(
+		"SELECT r.id FROM "+table+" r JOIN ("+
+			"SELECT host_id, path_id, MAX(backup_time) AS t FROM "+table+
+			" WHERE app_set_id = ? AND type IN (?, ?) AND backup_time <= ?"+
+			" GROUP BY host_id, path_id"+
+			") l ON r.host_id = l.host_id AND r.path_id = l.path_id"+
+			" AND r.backup_time = l.t"+
+			" WHERE r.app_set_id = ? AND r.type IN (?, ?)",
+		appSetId, RecordTypeBackup, RecordTypeArchive, at,
+		appSetId, RecordTypeBackup, RecordTypeArchive,
+	).ValuesFlat(&ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return r, nil
+	}
+	_, err = o.QueryTable("records").
+		Filter("id__in", ids...).
+		OrderBy("-backup_time").
+		RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	// Records backed up at the same time are both latest, one is enough.
+	latest := make([]*Records, 0, len(r))
+	seen := make(map[string]bool)
+	for _, v := range r {
+		key := v.Host.Id + ":" + v.Path.Id
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		latest = append(latest, v)
+	}
+	return latest, nil
+}
+
+// ArchivedRecords gets records with an archive in vault of oasId.
+func ArchivedRecords(oasId string) ([]*Records, error) {
+	r := make([]*Records, 0)
+	o := orm.NewOrm()
+	backupSets, err := backupSetsOn(o, "oas_id", oasId)
+	if err != nil || len(backupSets) == 0 {
+		return r, err
+	}
+	_, err = o.QueryTable("records").
+		Filter("backup_set_id__in", backupSets...).
+		Filter("archive_id__isnull", false).
+		Exclude("archive_id", "").
+		RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// StoredRecords gets backup records in bucket of ossId.
+func StoredRecords(ossId string) ([]*Records, error) {
+	r := make([]*Records, 0)
+	o := orm.NewOrm()
+	backupSets, err := backupSetsOn(o, "oss_id", ossId)
+	if err != nil || len(backupSets) == 0 {
+		return r, err
+	}
+	_, err = o.QueryTable("records").
+		Filter("backup_set_id__in", backupSets...).
+		Filter("type", RecordTypeBackup).
+		OrderBy("backup_time").
+		RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// BackupSetRecords gets records of backupSetId in OSS.
+func BackupSetRecords(backupSetId string) ([]*Records, error) {
+	r := make([]*Records, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("records").
+		Filter("backup_set_id", backupSetId).
+		Filter("type", RecordTypeBackup).
+		OrderBy("backup_time").
+		RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// backupSetsOn gets ids of backup sets whose column is id.
+func backupSetsOn(o orm.Ormer, column, id string) (orm.ParamsList, error) {
+	var r orm.ParamsList
+	_, err := o.QueryTable("backup_sets").
+		Filter(column, id).ValuesFlat(&r, "id")
+	return r, err
+}
+
+// LastVerifiedTime is when latest record is verified, zero if none is.
+func LastVerifiedTime() (time.Time, error) {
+	r := make([]*Records, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("records").Filter("verified_time__isnull", false).
+		OrderBy("-verified_time").Limit(1).All(&r, "VerifiedTime")
+	if err != nil || len(r) == 0 {
+		return time.Time{}, err
+	}
+	return r[0].VerifiedTime, nil
+}
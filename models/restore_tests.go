@@ -0,0 +1,266 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+const (
+	RestoreTestAll = iota
+	RestoreTestPending
+	RestoreTestPassed
+	RestoreTestFailed
+)
+
+// RestoreTests has test host download a record of backup set and check
+// its checksums, by a verify signal.
+type RestoreTests struct {
+	Id          string      `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	BackupSet   *BackupSets `orm:"rel(fk)" json:"backupset" valid:"Required"`
+	Record      *Records    `orm:"rel(fk);null;on_delete(set_null)" json:"record"` // Nil if deleted since
+	Filename    string      `orm:"size(255)" json:"filename"`                      // Of record, kept if it is deleted
+	Host        *Hosts      `orm:"rel(fk)" json:"host" valid:"Required"`
+	SignalId    string      `orm:"size(36);null;index" json:"signalid"`
+	Result      int         `orm:"default(1);index" json:"result"`
+	Error       string      `orm:"size(255);null" json:"error"`
+	CreatedTime time.Time   `orm:"type(datetime);index" json:"createdtime"`
+	DoneTime    time.Time   `orm:"type(datetime);null" json:"donetime"`
+}
+
+// RestoreTestSummaries sums up restore tests of a backup set.
+type RestoreTestSummaries struct {
+	BackupSet  *BackupSets `json:"backupset"`
+	Tests      int64       `json:"tests"`
+	Passed     int64       `json:"passed"`
+	Failed     int64       `json:"failed"`
+	LastResult int         `json:"lastresult"` // Of latest test over, 0 if none
+	LastTime   time.Time   `json:"lasttime"`
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(RestoreTests))
+	} else {
+		orm.RegisterModel(new(RestoreTests))
+	}
+}
+
+// IsOver tells if test has passed or failed.
+func (a *RestoreTests) IsOver() bool {
+	return a.Result == RestoreTestPassed || a.Result == RestoreTestFailed
+}
+
+// SetResult marks test passed, or failed for reason.
+func (a *RestoreTests) SetResult(result int, reason string) {
+	a.Result = result
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	a.Error = reason
+	a.DoneTime = time.Now()
+}
+
+// AddRestoreTest saves test as pending.
+func AddRestoreTest(a *RestoreTests) (string, error) {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	a.Id = uuid.New()
+	beego.Debug("[M] Got new id:", a.Id)
+	a.Result = RestoreTestPending
+	a.CreatedTime = time.Now()
+	if a.Record != nil {
+		a.Filename = a.Record.Filename
+	}
+
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return "", fmt.Errorf("Bad info: %s", errS)
+	}
+	_, err = o.Insert(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	beego.Debug("[M] RestoreTests info saved")
+	o.Commit()
+	return a.Id, nil
+}
+
+func UpdateRestoreTest(a *RestoreTests) error {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return fmt.Errorf("Bad info: %s", errS)
+	}
+	_, err = o.Update(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	o.Commit()
+	return nil
+}
+
+// If get all, just use &RestoreTests{}
+func GetRestoreTests(cond *RestoreTests, limit, index int) ([]*RestoreTests, error) {
+	r := make([]*RestoreTests, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("restore_tests")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.BackupSet != nil && cond.BackupSet.Id != "" {
+		q = q.Filter("backup_set_id", cond.BackupSet.Id)
+	}
+	if cond.Record != nil && cond.Record.Id != "" {
+		q = q.Filter("record_id", cond.Record.Id)
+	}
+	if cond.SignalId != "" {
+		q = q.Filter("signal_id", cond.SignalId)
+	}
+	if cond.Result != RestoreTestAll {
+		q = q.Filter("result", cond.Result)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("-created_time").RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetRestoreTestSummaries sums up restore tests of every backup set.
+func GetRestoreTestSummaries() ([]*RestoreTestSummaries, error) {
+	backupSets, err := GetBackupSets(&BackupSets{}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]*RestoreTestSummaries, 0, len(backupSets))
+	o := orm.NewOrm()
+	for _, v := range backupSets {
+		s := &RestoreTestSummaries{BackupSet: v}
+		q := o.QueryTable("restore_tests").Filter("backup_set_id", v.Id)
+		s.Tests, err = q.Count()
+		if err != nil {
+			return nil, err
+		}
+		s.Passed, err = q.Filter("result", RestoreTestPassed).Count()
+		if err != nil {
+			return nil, err
+		}
+		s.Failed, err = q.Filter("result", RestoreTestFailed).Count()
+		if err != nil {
+			return nil, err
+		}
+		last := make([]*RestoreTests, 0)
+		_, err = q.Filter("result__in", RestoreTestPassed, RestoreTestFailed).
+			OrderBy("-done_time").Limit(1).All(&last)
+		if err != nil {
+			return nil, err
+		}
+		if len(last) != 0 {
+			s.LastResult = last[0].Result
+			s.LastTime = last[0].DoneTime
+		}
+		r = append(r, s)
+	}
+	return r, nil
+}
+
+// restoreTestSignalDone marks restore tests waiting on signal passed or
+// failed, as signal is. Only agent of protocol version 1 and later verifies,
+// older ones ack what they do not know. Alarm is raised for failed ones.
+func restoreTestSignalDone(a *Signals) {
+	tests, err := GetRestoreTests(&RestoreTests{SignalId: a.Id}, 0, 0)
+	if err != nil {
+		beego.Warn("Cannot get restore tests of signal:", a.Id, "error:", err)
+		return
+	}
+	for _, v := range tests {
+		if v.IsOver() {
+			continue
+		}
+		switch a.Status {
+		case SignalStatusAcked:
+			if a.Version < 1 {
+				v.SetResult(RestoreTestFailed,
+					"Acked by agent of protocol version 0, not verified")
+			} else {
+				v.SetResult(RestoreTestPassed, "")
+			}
+		case SignalStatusExpired:
+			v.SetResult(RestoreTestFailed, "Signal expired")
+		default:
+			v.SetResult(RestoreTestFailed, a.Error)
+		}
+		err = UpdateRestoreTest(v)
+		if err != nil {
+			beego.Warn("Cannot update restore test:", v.Id, "error:", err)
+			continue
+		}
+		if v.Result != RestoreTestFailed {
+			continue
+		}
+		msg := fmt.Sprintf("Restore test of %s in backup set %s failed: %s",
+			v.Filename, v.BackupSet.Name, v.Error)
+		beego.Warn(msg)
+		err = common.Alarm(v.Host.Name, msg)
+		if err != nil {
+			beego.Warn("Cannot run alarm:", err)
+		}
+	}
+}
+
+// LastRestoreTestTime is when latest test is made, zero if none is.
+func LastRestoreTestTime() (time.Time, error) {
+	r := make([]*RestoreTests, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("restore_tests").
+		OrderBy("-created_time").Limit(1).All(&r, "CreatedTime")
+	if err != nil || len(r) == 0 {
+		return time.Time{}, err
+	}
+	return r[0].CreatedTime, nil
+}
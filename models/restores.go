@@ -0,0 +1,374 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
+	"github.com/pborman/uuid"
+)
+
+// Stages of restore job, in the order a job goes through them. Job of a
+// record in OSS is signalled right away, and agent not telling progress
+// goes from signalled to done.
+const (
+	RestoreStageAll = iota
+	RestoreStagePending
+	RestoreStageRetrieving // From archive to OSS
+	RestoreStageInOss
+	RestoreStageSignalled
+	RestoreStageDownloading
+	RestoreStageDone
+	RestoreStageFailed
+)
+
+// Restores restores an app set as it was at some time, by a restore job
+// for each host and path.
+type Restores struct {
+	Id          string         `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	AppSet      *AppSets       `orm:"rel(fk)" json:"appset" valid:"Required"`
+	At          time.Time      `orm:"type(datetime)" json:"at"`
+	Operator    string         `orm:"size(64);null" json:"operator"`
+	CreatedTime time.Time      `orm:"type(datetime)" json:"createdtime"`
+	Jobs        []*RestoreJobs `orm:"reverse(many)" json:"jobs"`
+	Stage       int            `orm:"-" json:"stage"` // Of job least far, or failed if any when all are over
+}
+
+// RestoreJobs restores a record onto host into target directory, alone or
+// as part of a restore.
+type RestoreJobs struct {
+	Id              string    `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Restore         *Restores `orm:"rel(fk);null" json:"restore"`
+	Record          *Records  `orm:"rel(fk)" json:"record" valid:"Required"`
+	Host            *Hosts    `orm:"rel(fk)" json:"host" valid:"Required"`
+	Target          string    `json:"target" valid:"Required"`
+	OasJob          *OasJobs  `orm:"rel(fk);null;on_delete(set_null)" json:"oasjob"`
+	SignalId        string    `orm:"size(36);null;index" json:"signalid"`
+	Stage           int       `orm:"default(1);index" json:"stage"`
+	Progress        int       `orm:"default(0)" json:"progress"` // Percent downloaded
+	Error           string    `orm:"size(255);null" json:"error"`
+	CreatedTime     time.Time `orm:"type(datetime)" json:"createdtime"`
+	RetrievingTime  time.Time `orm:"type(datetime);null" json:"retrievingtime"`
+	InOssTime       time.Time `orm:"type(datetime);null" json:"inosstime"`
+	SignalledTime   time.Time `orm:"type(datetime);null" json:"signalledtime"`
+	DownloadingTime time.Time `orm:"type(datetime);null" json:"downloadingtime"`
+	DoneTime        time.Time `orm:"type(datetime);null" json:"donetime"` // Done or failed
+}
+
+// restoreTopic is where ids of restore jobs changed are published.
+const restoreTopic = "restore"
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(Restores), new(RestoreJobs))
+	} else {
+		orm.RegisterModel(new(Restores), new(RestoreJobs))
+	}
+}
+
+// IsOver tells if job will not go any further.
+func (j *RestoreJobs) IsOver() bool {
+	return j.Stage == RestoreStageDone || j.Stage == RestoreStageFailed
+}
+
+// SetStage moves job to stage now, failed for reason if stage is failed.
+func (j *RestoreJobs) SetStage(stage int, reason string) {
+	now := time.Now()
+	j.Stage = stage
+	switch stage {
+	case RestoreStageRetrieving:
+		j.RetrievingTime = now
+	case RestoreStageInOss:
+		j.InOssTime = now
+	case RestoreStageSignalled:
+		j.SignalledTime = now
+	case RestoreStageDownloading:
+		j.DownloadingTime = now
+	case RestoreStageDone:
+		j.Progress = 100
+		j.DoneTime = now
+	case RestoreStageFailed:
+		if len(reason) > 255 {
+			reason = reason[:255]
+		}
+		j.Error = reason
+		j.DoneTime = now
+	}
+}
+
+// AddRestore saves restore with its jobs, all pending.
+func AddRestore(a *Restores) (string, error) {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	a.Id = uuid.New()
+	beego.Debug("[M] Got new id:", a.Id)
+	a.CreatedTime = time.Now()
+	for _, v := range a.Jobs {
+		v.Id = uuid.New()
+		v.Restore = &Restores{Id: a.Id}
+		v.Stage = RestoreStagePending
+		v.CreatedTime = a.CreatedTime
+	}
+
+	validator := new(validation.Validation)
+	for _, v := range append([]interface{}{a}, restoreJobs(a.Jobs)...) {
+		valid, err := validator.Valid(v)
+		if err != nil {
+			o.Rollback()
+			return "", err
+		}
+		if !valid {
+			o.Rollback()
+			var errS string
+			for _, err := range validator.Errors {
+				errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+			}
+			return "", fmt.Errorf("Bad info: %s", errS)
+		}
+	}
+	_, err = o.Insert(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	for _, v := range a.Jobs {
+		_, err = o.Insert(v)
+		if err != nil {
+			o.Rollback()
+			return "", err
+		}
+	}
+	beego.Debug("[M] Restores info saved")
+	o.Commit()
+	for _, v := range a.Jobs {
+		publishRestoreJob(v.Id)
+	}
+	return a.Id, nil
+}
+
+// AddRestoreJob saves job of a single record, pending.
+func AddRestoreJob(a *RestoreJobs) (string, error) {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	a.Id = uuid.New()
+	beego.Debug("[M] Got new id:", a.Id)
+	a.Stage = RestoreStagePending
+	a.CreatedTime = time.Now()
+
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return "", fmt.Errorf("Bad info: %s", errS)
+	}
+	_, err = o.Insert(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	beego.Debug("[M] RestoreJobs info saved")
+	o.Commit()
+	publishRestoreJob(a.Id)
+	return a.Id, nil
+}
+
+func restoreJobs(jobs []*RestoreJobs) []interface{} {
+	r := make([]interface{}, 0, len(jobs))
+	for _, v := range jobs {
+		r = append(r, v)
+	}
+	return r
+}
+
+func UpdateRestoreJob(a *RestoreJobs) error {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return fmt.Errorf("Bad info: %s", errS)
+	}
+	_, err = o.Update(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	o.Commit()
+	publishRestoreJob(a.Id)
+	return nil
+}
+
+// SubscribeRestoreJobs gets ids of restore jobs as they change, till stop.
+func SubscribeRestoreJobs() (ids <-chan string, stop func()) {
+	return common.DefaultHub.Subscribe(restoreTopic)
+}
+
+func publishRestoreJob(id string) {
+	err := common.DefaultHub.Publish(restoreTopic, id)
+	if err != nil {
+		beego.Warn("Cannot publish restore job:", id, "error:", err)
+	}
+}
+
+// If get all, just use &Restores{}
+func GetRestores(cond *Restores, limit, index int) ([]*Restores, error) {
+	r := make([]*Restores, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("restores")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.AppSet != nil && cond.AppSet.Id != "" {
+		q = q.Filter("app_set_id", cond.AppSet.Id)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("-created_time").RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range r {
+		o.LoadRelated(v, "Jobs", common.RelDepth)
+		v.Stage = restoreStage(v.Jobs)
+	}
+	return r, nil
+}
+
+// If get all, just use &RestoreJobs{}
+func GetRestoreJobs(cond *RestoreJobs, limit, index int) ([]*RestoreJobs, error) {
+	r := make([]*RestoreJobs, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("restore_jobs")
+	if cond.Id != "" {
+		q = q.Filter("id", cond.Id)
+	}
+	if cond.Restore != nil && cond.Restore.Id != "" {
+		q = q.Filter("restore_id", cond.Restore.Id)
+	}
+	if cond.OasJob != nil && cond.OasJob.Id != "" {
+		q = q.Filter("oas_job_id", cond.OasJob.Id)
+	}
+	if cond.SignalId != "" {
+		q = q.Filter("signal_id", cond.SignalId)
+	}
+	if cond.Stage != RestoreStageAll {
+		q = q.Filter("stage", cond.Stage)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("created_time").RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// restoreStage is stage of job least far, or failed if any job failed and
+// all are over.
+func restoreStage(jobs []*RestoreJobs) int {
+	stage := RestoreStageAll
+	for _, v := range jobs {
+		switch {
+		case stage == RestoreStageAll:
+			stage = v.Stage
+		case !v.IsOver() && (v.Stage < stage || stage >= RestoreStageDone):
+			stage = v.Stage
+		case v.Stage == RestoreStageFailed && stage == RestoreStageDone:
+			stage = v.Stage
+		}
+	}
+	return stage
+}
+
+// restoreSignalProgress marks restore jobs waiting on signal downloading,
+// as far as agent tells.
+func restoreSignalProgress(a *Signals) {
+	jobs, err := GetRestoreJobs(&RestoreJobs{SignalId: a.Id}, 0, 0)
+	if err != nil {
+		beego.Warn("Cannot get restore jobs of signal:", a.Id, "error:", err)
+		return
+	}
+	for _, v := range jobs {
+		if v.IsOver() {
+			continue
+		}
+		if v.Stage != RestoreStageDownloading {
+			v.SetStage(RestoreStageDownloading, "")
+		}
+		v.Progress = a.Progress
+		err = UpdateRestoreJob(v)
+		if err != nil {
+			beego.Warn("Cannot update restore job:", v.Id, "error:", err)
+		}
+	}
+}
+
+// restoreSignalDone marks restore jobs waiting on signal done or failed,
+// as signal is.
+func restoreSignalDone(a *Signals) {
+	jobs, err := GetRestoreJobs(&RestoreJobs{SignalId: a.Id}, 0, 0)
+	if err != nil {
+		beego.Warn("Cannot get restore jobs of signal:", a.Id, "error:", err)
+		return
+	}
+	for _, v := range jobs {
+		if v.IsOver() {
+			continue
+		}
+		switch a.Status {
+		case SignalStatusAcked:
+			v.SetStage(RestoreStageDone, "")
+		case SignalStatusExpired:
+			v.SetStage(RestoreStageFailed, "Signal expired")
+		default:
+			v.SetStage(RestoreStageFailed, a.Error)
+		}
+		err = UpdateRestoreJob(v)
+		if err != nil {
+			beego.Warn("Cannot update restore job:", v.Id, "error:", err)
+		}
+	}
+}
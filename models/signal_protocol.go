@@ -0,0 +1,294 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/astaxie/beego/validation"
+)
+
+// SignalProtocolVersion is the newest version of signal messages server
+// speaks. Agent says hello first to use it, agent saying nothing is
+// spoken to in version 0: signal as plain JSON, replies like "DONE <id>".
+const SignalProtocolVersion = 1
+
+// Kinds of envelope.
+const (
+	EnvelopeKindHello    = "hello"    // Agent says first, with HelloBody
+	EnvelopeKindWelcome  = "welcome"  // Answers hello, with WelcomeBody
+	EnvelopeKindCommand  = "command"  // A signal to agent
+	EnvelopeKindProgress = "progress" // Agent is working on signal
+	EnvelopeKindDone     = "done"     // Agent finished signal
+	EnvelopeKindError    = "error"    // Either side, with ErrorBody
+)
+
+// Codes of ErrorBody.
+const (
+	SignalErrorUnknown     = "unknown"
+	SignalErrorBadMessage  = "bad_message"
+	SignalErrorUnsupported = "unsupported" // Version or type of signal
+	SignalErrorFailed      = "failed"      // Agent tried and failed
+)
+
+// SignalTypeNames names signal types in envelopes and capabilities.
+var SignalTypeNames = map[int]string{
+	SignalTypeNothing:  "nothing",
+	SignalTypeDownload: "download",
+	SignalTypeBackup:   "backup",
+	SignalTypeVerify:   "verify",
+}
+
+// Commands of each signal type, to validate signals with.
+var signalCommands = map[int]func() interface{}{
+	SignalTypeNothing: func() interface{} {
+		return new(NothingCommand)
+	},
+	SignalTypeDownload: func() interface{} {
+		return new(DownloadCommand)
+	},
+	SignalTypeBackup: func() interface{} {
+		return new(BackupCommand)
+	},
+	SignalTypeVerify: func() interface{} {
+		return new(VerifyCommand)
+	},
+}
+
+var (
+	ErrorEnvelopeVersion = errors.New("Unsupported protocol version")
+	ErrorEnvelopeKind    = errors.New("Unknown envelope kind")
+	ErrorEnvelopeNoId    = errors.New("Envelope has no signal id")
+)
+
+// Envelope wraps every message of protocol version 1 and later, both ways.
+type Envelope struct {
+	Version int             `json:"version"`
+	Kind    string          `json:"kind"`
+	Id      string          `json:"id,omitempty"`   // Signal id
+	Type    string          `json:"type,omitempty"` // Signal type name of command
+	Body    json.RawMessage `json:"body,omitempty"`
+}
+
+type HelloBody struct {
+	Versions     []int    `json:"versions" valid:"Required"`
+	Capabilities []string `json:"capabilities"` // Signal types agent can do
+}
+
+type WelcomeBody struct {
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities"` // Signal types to be sent
+}
+
+type ProgressBody struct {
+	Percent int    `json:"percent" valid:"Range(0,100)"`
+	Message string `json:"message"`
+}
+
+type DoneBody struct {
+	Message string `json:"message"`
+}
+
+type ErrorBody struct {
+	Code    string `json:"code" valid:"Required"`
+	Message string `json:"message"`
+	Retry   bool   `json:"retry"` // Send signal again if attempts left
+}
+
+// NothingCommand does nothing, agent just replies.
+type NothingCommand struct{}
+
+// DownloadCommand has agent download path from storage, by url if given,
+// into target directory, or where it was backed up from if empty.
+type DownloadCommand struct {
+	Path     string `json:"path" valid:"Required"`
+	Driver   string `json:"driver"`
+	Endpoint string `json:"endpoint" valid:"Required"`
+	Bucket   string `json:"bucket" valid:"Required"`
+	Region   string `json:"region"`
+	URL      string `json:"url"`
+	Target   string `json:"target"`
+}
+
+// BackupCommand has agent back path up now and upload it to storage.
+type BackupCommand struct {
+	Path     string `json:"path" valid:"Required"`
+	Driver   string `json:"driver"`
+	Endpoint string `json:"endpoint" valid:"Required"`
+	Bucket   string `json:"bucket" valid:"Required"`
+	Region   string `json:"region"`
+}
+
+// VerifyCommand has agent download path as DownloadCommand, without keeping
+// it, and check size and checksums given. Size 0 is not checked.
+type VerifyCommand struct {
+	Path     string `json:"path" valid:"Required"`
+	Driver   string `json:"driver"`
+	Endpoint string `json:"endpoint" valid:"Required"`
+	Bucket   string `json:"bucket" valid:"Required"`
+	Region   string `json:"region"`
+	URL      string `json:"url"`
+	Size     int64  `json:"size"`
+	Md5      string `json:"md5"`
+	Sha256   string `json:"sha256"`
+}
+
+// NewEnvelope makes envelope of current version with body.
+func NewEnvelope(kind, id string, body interface{}) (*Envelope, error) {
+	e := &Envelope{
+		Version: SignalProtocolVersion,
+		Kind:    kind,
+		Id:      id,
+	}
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		e.Body = b
+	}
+	return e, nil
+}
+
+// ParseEnvelope reads envelope from agent.
+func ParseEnvelope(b []byte) (*Envelope, error) {
+	e := new(Envelope)
+	err := json.Unmarshal(b, e)
+	if err != nil {
+		return nil, err
+	}
+	if e.Version < 1 || e.Version > SignalProtocolVersion {
+		return nil, ErrorEnvelopeVersion
+	}
+	switch e.Kind {
+	case EnvelopeKindHello, EnvelopeKindProgress,
+		EnvelopeKindDone, EnvelopeKindError:
+	default:
+		return nil, ErrorEnvelopeKind
+	}
+	if e.Kind != EnvelopeKindHello && e.Id == "" {
+		return nil, ErrorEnvelopeNoId
+	}
+	return e, nil
+}
+
+// Decode reads body of envelope into v and validates it.
+func (e *Envelope) Decode(v interface{}) error {
+	if len(e.Body) != 0 {
+		err := json.Unmarshal(e.Body, v)
+		if err != nil {
+			return err
+		}
+	}
+	return validate(v)
+}
+
+// Negotiate picks newest version both sides speak, and signal types both
+// know. Error is returned if there is no such version.
+func Negotiate(h *HelloBody) (*WelcomeBody, error) {
+	w := &WelcomeBody{
+		Capabilities: make([]string, 0),
+	}
+	for _, v := range h.Versions {
+		if v <= SignalProtocolVersion && v > w.Version {
+			w.Version = v
+		}
+	}
+	if w.Version == 0 {
+		return nil, ErrorEnvelopeVersion
+	}
+	for _, c := range h.Capabilities {
+		for _, name := range SignalTypeNames {
+			if c == name {
+				w.Capabilities = append(w.Capabilities, c)
+				break
+			}
+		}
+	}
+	sort.Strings(w.Capabilities)
+	return w, nil
+}
+
+// Can tells if signal type t is among agreed capabilities.
+func (w *WelcomeBody) Can(t int) bool {
+	for _, c := range w.Capabilities {
+		if c == SignalTypeNames[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// LegacyCan tells if agent of protocol version 0 can do signal type t. It
+// knows download only, and acks whatever else it is sent.
+func LegacyCan(t int) bool {
+	return t == SignalTypeNothing || t == SignalTypeDownload
+}
+
+// ValidateSignal checks signal has known type and what its command needs.
+func ValidateSignal(signal Signal) error {
+	t, err := signalType(signal)
+	if err != nil {
+		return err
+	}
+	command, ok := signalCommands[t]
+	if !ok {
+		return fmt.Errorf("Unknown signal type: %d", t)
+	}
+	b, err := json.Marshal(signal)
+	if err != nil {
+		return err
+	}
+	c := command()
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		return err
+	}
+	return validate(c)
+}
+
+// Command makes envelope sending signal to agent.
+func (a *Signals) Command() (*Envelope, error) {
+	s := a.Signal()
+	delete(s, "id")
+	delete(s, "type")
+	e, err := NewEnvelope(EnvelopeKindCommand, a.Id, s)
+	if err != nil {
+		return nil, err
+	}
+	e.Type = SignalTypeNames[a.Type]
+	return e, nil
+}
+
+// signalType gets type of signal, which is a number, or nothing if missing.
+func signalType(signal Signal) (int, error) {
+	switch t := signal["type"].(type) {
+	case nil:
+		return SignalTypeNothing, nil
+	case int:
+		return t, nil
+	case float64:
+		if t != float64(int(t)) {
+			return 0, fmt.Errorf("Bad signal type: %v", t)
+		}
+		return int(t), nil
+	}
+	return 0, fmt.Errorf("Bad signal type: %v", signal["type"])
+}
+
+func validate(v interface{}) error {
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(v)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return fmt.Errorf("Bad info: %s", errS)
+	}
+	return nil
+}
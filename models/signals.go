@@ -1,146 +1,533 @@
 package models
 
 import (
-	"bytes"
-	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ModuleAB/ModuleAB/server/common"
 
-	"time"
-
 	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	"github.com/astaxie/beego/validation"
 	"github.com/pborman/uuid"
 )
 
-const (
-	CacheSignalPrefix = "Signal_"
-)
+// SignalDownloadExpire is as long as a signed download url lives.
+const SignalDownloadExpire = 30 * time.Minute
 
 const (
 	SignalTypeNothing = iota
 	SignalTypeDownload
+	SignalTypeBackup
+	SignalTypeVerify
 )
 
-var (
-	ErrorSignalNotFound    = errors.New("Signal Not Found")
-	ErrorSignalBadDataType = errors.New("Bad data type")
+// A signal is pending till written to agent, delivered till agent acks or
+// nacks it. Nacked or undelivered signal is sent again, till it runs out
+// of attempts (failed) or time (expired).
+const (
+	SignalStatusAll = iota
+	SignalStatusPending
+	SignalStatusDelivered
+	SignalStatusAcked
+	SignalStatusFailed
+	SignalStatusExpired
 )
 
 var (
-	SignalChannels map[string]chan Signal
+	ErrorSignalNotFound    = errors.New("Signal Not Found")
+	ErrorSignalBadDataType = errors.New("Bad data type")
+	ErrorSignalDone        = errors.New("Signal is acked, failed or expired")
 )
 
 func init() {
-	SignalChannels = make(map[string]chan Signal)
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(Signals))
+	} else {
+		orm.RegisterModel(new(Signals))
+	}
 }
 
+// Signal is what is sent to agent, "id" is set to id of Signals.
 type Signal map[string]interface{}
 
+// Signals keeps a signal to a host till it is done with.
+type Signals struct {
+	Id            string    `orm:"pk;size(36)" json:"id" valid:"Match(/^[A-Fa-f0-9]{8}-([A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}$/)"`
+	Host          *Hosts    `orm:"rel(fk)" json:"host" valid:"Required"`
+	Type          int       `orm:"default(0)" json:"type"`
+	Data          string    `orm:"type(text)" json:"data"` // Signal in JSON
+	Status        int       `orm:"default(1);index" json:"status"`
+	Attempts      int       `orm:"default(0)" json:"attempts"` // Times delivered
+	Version       int       `orm:"default(0)" json:"version"`  // Of protocol last delivered in
+	MaxAttempts   int       `orm:"default(3)" json:"maxattempts"`
+	Error         string    `orm:"size(255);null" json:"error"` // Why agent nacked
+	Progress      int       `orm:"default(0)" json:"progress"`  // Percent
+	Message       string    `orm:"size(255);null" json:"message"`
+	CreatedTime   time.Time `orm:"type(datetime);index" json:"createdtime"`
+	DeliveredTime time.Time `orm:"type(datetime);null" json:"deliveredtime"`
+	DoneTime      time.Time `orm:"type(datetime);null" json:"donetime"`
+	ExpireTime    time.Time `orm:"type(datetime)" json:"expiretime"`
+}
+
+// Signal decodes Data, with id set. Download url is signed now, as signal
+// may be sent long after it was made.
+func (a *Signals) Signal() Signal {
+	s := make(Signal)
+	err := json.Unmarshal([]byte(a.Data), &s)
+	if err != nil {
+		beego.Warn("Bad data of signal:", a.Id, "error:", err)
+	}
+	s["id"] = a.Id
+	if a.Type == SignalTypeDownload || a.Type == SignalTypeVerify {
+		signDownload(s)
+	}
+	return s
+}
+
+// IsDone tells if signal will never be sent again.
+func (a *Signals) IsDone() bool {
+	return a.Status == SignalStatusAcked ||
+		a.Status == SignalStatusFailed ||
+		a.Status == SignalStatusExpired
+}
+
+// AddSignal saves signal to host as pending, after validating it. It is
+// tried misc::signalretries times in misc::signalexpire seconds.
 func AddSignal(hostId string, signal Signal) (string, error) {
-	keyName := fmt.Sprintf("%s%s", common.DefaultRedisKey, hostId)
-	newId := uuid.New()
-	signal["id"] = newId
-	var (
-		buf []byte
-		err error
-	)
-	var v = make([]Signal, 0)
-	if common.DefaultRedisClient.IsExist(keyName) {
-		b := common.DefaultRedisClient.Get(keyName)
-		v, err = fromGob(b.([]byte))
-		if err != nil {
-			return "", err
+	err := ValidateSignal(signal)
+	if err != nil {
+		return "", err
+	}
+	a := &Signals{
+		Id:   uuid.New(),
+		Host: &Hosts{Id: hostId},
+		MaxAttempts: beego.AppConfig.DefaultInt(
+			"misc::signalretries", 3,
+		),
+		Status:      SignalStatusPending,
+		CreatedTime: time.Now(),
+	}
+	a.ExpireTime = a.CreatedTime.Add(time.Duration(
+		beego.AppConfig.DefaultInt64("misc::signalexpire", 86400),
+	) * time.Second)
+	a.Type, _ = signalType(signal)
+	signal["id"] = a.Id
+	b, err := json.Marshal(signal)
+	if err != nil {
+		return "", err
+	}
+	a.Data = string(b)
+	beego.Debug("[M] Got data:", a)
+
+	o := orm.NewOrm()
+	err = o.Begin()
+	if err != nil {
+		return "", err
+	}
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return "", err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
 		}
-		beego.Debug("Got from redis:", v)
+		return "", fmt.Errorf("Bad info: %s", errS)
 	}
-	v = append(v, signal)
-	buf, err = toGob(v)
+	_, err = o.Insert(a)
 	if err != nil {
+		o.Rollback()
 		return "", err
 	}
-	return newId, common.DefaultRedisClient.Put(keyName, buf, 30*time.Minute)
+	beego.Debug("[M] Signals info saved")
+	o.Commit()
+	return a.Id, nil
 }
 
+func UpdateSignal(a *Signals) error {
+	beego.Debug("[M] Got data:", a)
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return err
+	}
+	validator := new(validation.Validation)
+	valid, err := validator.Valid(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	if !valid {
+		o.Rollback()
+		var errS string
+		for _, err := range validator.Errors {
+			errS = fmt.Sprintf("%s, %s:%s", errS, err.Key, err.Message)
+		}
+		return fmt.Errorf("Bad info: %s", errS)
+	}
+	_, err = o.Update(a)
+	if err != nil {
+		o.Rollback()
+		return err
+	}
+	o.Commit()
+	if a.IsDone() {
+		signalDone(a)
+	}
+	return nil
+}
+
+// signalDone lets restore jobs and restore tests waiting on a know it is
+// done, however it is done.
+func signalDone(a *Signals) {
+	restoreSignalDone(a)
+	restoreTestSignalDone(a)
+}
+
+// GetSignalRecord gets signal of host by id, ErrorSignalNotFound if none.
+func GetSignalRecord(hostId, id string) (*Signals, error) {
+	r := make([]*Signals, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("signals").
+		Filter("id", id).Filter("host_id", hostId).
+		RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	if len(r) == 0 {
+		return nil, ErrorSignalNotFound
+	}
+	return r[0], nil
+}
+
+// GetSignalHistory gets signals of host in any status, latest first.
+func GetSignalHistory(hostId string, status, limit, index int) ([]*Signals, error) {
+	ExpireSignals()
+	r := make([]*Signals, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("signals").Filter("host_id", hostId)
+	if status != SignalStatusAll {
+		q = q.Filter("status", status)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if index > 0 {
+		q = q.Offset(index)
+	}
+	_, err := q.OrderBy("-created_time").RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// PendingSignals gets signals of host not done with yet, oldest first.
+func PendingSignals(hostId string) ([]*Signals, error) {
+	ExpireSignals()
+	r := make([]*Signals, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("signals").Filter("host_id", hostId).
+		Filter("status__in", SignalStatusPending, SignalStatusDelivered).
+		OrderBy("created_time").RelatedSel(common.RelDepth).All(&r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ExpireSignals marks signals out of time as expired.
+func ExpireSignals() {
+	o := orm.NewOrm()
+	now := time.Now()
+	expired := make([]*Signals, 0)
+	_, err := o.QueryTable("signals").
+		Filter("status__in", SignalStatusPending, SignalStatusDelivered).
+		Filter("expire_time__lt", now).
+		All(&expired, "id")
+	if err != nil {
+		beego.Warn("Cannot expire signals:", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+	ids := make([]interface{}, 0, len(expired))
+	for _, v := range expired {
+		ids = append(ids, v.Id)
+	}
+	_, err = o.QueryTable("signals").
+		Filter("id__in", ids...).
+		Filter("status__in", SignalStatusPending, SignalStatusDelivered).
+		Update(orm.Params{
+			"status":    SignalStatusExpired,
+			"done_time": now,
+		})
+	if err != nil {
+		beego.Warn("Cannot expire signals:", err)
+		return
+	}
+	for _, v := range expired {
+		v.Status = SignalStatusExpired
+		signalDone(v)
+	}
+}
+
+// DeliverSignal counts an attempt of sending signal to agent speaking
+// protocol version. Signal out of attempts is failed and must not be sent,
+// ErrorSignalDone is returned.
+func DeliverSignal(a *Signals, version int) error {
+	if a.IsDone() {
+		return ErrorSignalDone
+	}
+	if time.Now().After(a.ExpireTime) {
+		a.Status = SignalStatusExpired
+		a.DoneTime = time.Now()
+		err := UpdateSignal(a)
+		if err != nil {
+			return err
+		}
+		return ErrorSignalDone
+	}
+	if a.Attempts >= a.MaxAttempts {
+		a.Status = SignalStatusFailed
+		a.DoneTime = time.Now()
+		if a.Error == "" {
+			a.Error = "Not acked"
+		}
+		err := UpdateSignal(a)
+		if err != nil {
+			return err
+		}
+		return ErrorSignalDone
+	}
+	a.Attempts++
+	a.Version = version
+	a.Status = SignalStatusDelivered
+	a.DeliveredTime = time.Now()
+	return UpdateSignal(a)
+}
+
+// AckSignal marks signal done by agent.
+func AckSignal(hostId, id string) error {
+	a, err := GetSignalRecord(hostId, id)
+	if err != nil {
+		return err
+	}
+	if a.IsDone() {
+		return ErrorSignalDone
+	}
+	a.Status = SignalStatusAcked
+	a.DoneTime = time.Now()
+	return UpdateSignal(a)
+}
+
+// NackSignal marks signal refused by agent for reason. It is pending
+// again if attempts are left, and notified, otherwise failed.
+func NackSignal(hostId, id, reason string) error {
+	a, err := GetSignalRecord(hostId, id)
+	if err != nil {
+		return err
+	}
+	if a.IsDone() {
+		return ErrorSignalDone
+	}
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	a.Error = reason
+	if a.Attempts >= a.MaxAttempts {
+		a.Status = SignalStatusFailed
+		a.DoneTime = time.Now()
+		return UpdateSignal(a)
+	}
+	a.Status = SignalStatusPending
+	err = UpdateSignal(a)
+	if err != nil {
+		return err
+	}
+	return NotifySignal(hostId, id)
+}
+
+// ProgressSignal notes how far agent is with signal.
+func ProgressSignal(hostId, id string, percent int, message string) error {
+	a, err := GetSignalRecord(hostId, id)
+	if err != nil {
+		return err
+	}
+	if a.IsDone() {
+		return ErrorSignalDone
+	}
+	if len(message) > 255 {
+		message = message[:255]
+	}
+	a.Progress = percent
+	a.Message = message
+	err = UpdateSignal(a)
+	if err != nil {
+		return err
+	}
+	restoreSignalProgress(a)
+	return nil
+}
+
+// FailSignal marks signal failed by agent for reason, it is not sent again.
+func FailSignal(hostId, id, reason string) error {
+	a, err := GetSignalRecord(hostId, id)
+	if err != nil {
+		return err
+	}
+	if a.IsDone() {
+		return ErrorSignalDone
+	}
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	a.Error = reason
+	a.Status = SignalStatusFailed
+	a.DoneTime = time.Now()
+	return UpdateSignal(a)
+}
+
+// GetSignals gets signals of host not done with yet.
 func GetSignals(hostId string) []Signal {
-	keyName := fmt.Sprintf("%s%s", common.DefaultRedisKey, hostId)
-	b := common.DefaultRedisClient.Get(keyName)
-	beego.Debug("Got from redis:", b)
-	v, err := fromGob(b.([]byte))
+	signals, err := PendingSignals(hostId)
 	if err != nil {
 		beego.Warn(err)
 		return nil
 	}
-	return v
+	r := make([]Signal, 0, len(signals))
+	for _, v := range signals {
+		r = append(r, v.Signal())
+	}
+	return r
 }
 
 func GetSignal(hostId, id string) (Signal, error) {
-	signals := GetSignals(hostId)
-	beego.Debug("Signals", signals)
-	for _, v := range signals {
-		if v["id"] == id {
-			return v, nil
-		}
+	a, err := GetSignalRecord(hostId, id)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("Got nothing")
+	return a.Signal(), nil
 }
 
 func TruncateSignals(hostId string) {
-	keyName := fmt.Sprintf("%s%s", common.DefaultRedisKey, hostId)
-	common.DefaultRedisClient.Delete(keyName)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("signals").Filter("host_id", hostId).Delete()
+	if err != nil {
+		beego.Warn("Cannot truncate signals:", err)
+	}
 }
 
 func DeleteSignal(hostId string, signalId string) error {
-	keyName := fmt.Sprintf("%s%s", common.DefaultRedisKey, hostId)
-	if common.DefaultRedisClient.IsExist(keyName) {
-		v := common.DefaultRedisClient.Get(keyName)
-		n, ok := v.([]Signal)
-		if !ok {
-			return ErrorSignalBadDataType
-		}
-		var a = make([]Signal, 0)
-		for _, v := range n {
-			if v["id"] != signalId {
-				a = append(a, v)
-			}
-		}
-		return common.DefaultRedisClient.Put(keyName, a, 30*time.Minute)
+	a, err := GetSignalRecord(hostId, signalId)
+	if err != nil {
+		return err
 	}
-	return ErrorSignalNotFound
+	o := orm.NewOrm()
+	_, err = o.Delete(a)
+	return err
 }
 
+// NotifySignal tells agent of host about signal if it is online, on
+// whichever server it is connected to. Signal is saved already, so it is
+// sent when agent connects if not now.
 func NotifySignal(hostId, signalId string) error {
-	_, ok := SignalChannels[hostId]
-	if !ok {
-		SignalChannels[hostId] = make(chan Signal, 1024)
-	}
-	signal, err := GetSignal(hostId, signalId)
+	_, err := GetSignalRecord(hostId, signalId)
 	if err != nil {
 		return err
 	}
-	SignalChannels[hostId] <- signal
-	return nil
+	return common.DefaultHub.Publish(signalTopic(hostId), signalId)
 }
 
-func MakeDownloadSignal(path, endpoint, bucket string) Signal {
+// SubscribeSignals gets ids of signals notified to host, till stop.
+func SubscribeSignals(hostId string) (ids <-chan string, stop func()) {
+	return common.DefaultHub.Subscribe(signalTopic(hostId))
+}
+
+func signalTopic(hostId string) string {
+	return "signal:" + hostId
+}
+
+// MakeDownloadSignal tells agent where to download path from, and to put
+// it into target directory if not empty. A signed url is given as well when
+// it is sent, so the agent needs no keys for the storage driver.
+func MakeDownloadSignal(path string, oss *Oss, target string) Signal {
 	s := make(Signal)
 	s["type"] = SignalTypeDownload
 	s["path"] = path
-	s["endpoint"] = endpoint
-	s["bucket"] = bucket
+	if target != "" {
+		s["target"] = target
+	}
+	s["driver"] = oss.Driver
+	s["endpoint"] = oss.Endpoint
+	s["bucket"] = oss.BucketName
+	s["region"] = oss.Region
 	return s
 }
 
-func toGob(v interface{}) ([]byte, error) {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(v)
-	return buf.Bytes(), err
+// signDownload sets url of download signal, signed for
+// SignalDownloadExpire from now by storage of its bucket. No url is set if
+// storage cannot sign one.
+func signDownload(s Signal) {
+	delete(s, "url")
+	path, _ := s["path"].(string)
+	bucket, _ := s["bucket"].(string)
+	oss, err := GetOss(&Oss{BucketName: bucket}, 1, 0)
+	if err != nil || len(oss) == 0 {
+		beego.Warn("Cannot get OSS of bucket:", bucket, "error:", err)
+		return
+	}
+	storage, err := oss[0].Storage()
+	if err != nil {
+		beego.Warn("Cannot connect to storage:", err)
+		return
+	}
+	url, err := storage.SignURL(path, SignalDownloadExpire)
+	if err == common.ErrorSignURL {
+		return
+	}
+	if err != nil {
+		beego.Warn("Cannot sign url for:", path, "error:", err)
+		return
+	}
+	s["url"] = url
 }
 
-func fromGob(b []byte) ([]Signal, error) {
-	var v = make([]Signal, 0)
-	var buf = bytes.NewBuffer(b)
-	err := gob.NewDecoder(buf).Decode(&v)
-	return v, err
+// MakeBackupSignal tells agent to back path up now. Agent posts the record
+// of it with "signalid" set to id of the signal.
+func MakeBackupSignal(path *Paths) Signal {
+	s := make(Signal)
+	s["type"] = SignalTypeBackup
+	s["path"] = path.Path
+	if path.BackupSet != nil && path.BackupSet.Oss != nil {
+		s["driver"] = path.BackupSet.Oss.Driver
+		s["endpoint"] = path.BackupSet.Oss.Endpoint
+		s["bucket"] = path.BackupSet.Oss.BucketName
+		s["region"] = path.BackupSet.Oss.Region
+	}
+	return s
+}
+
+// MakeVerifySignal tells agent to download backup of record, as
+// MakeDownloadSignal, and check it against size and checksums recorded.
+// Agent acks it if they match, and fails it with what differs if not.
+func MakeVerifySignal(record *Records) Signal {
+	s := MakeDownloadSignal(record.GetFullPath(), record.BackupSet.Oss, "")
+	s["type"] = SignalTypeVerify
+	s["size"] = record.Size
+	if record.Md5 != "" {
+		s["md5"] = record.Md5
+	}
+	if record.Sha256 != "" {
+		s["sha256"] = record.Sha256
+	}
+	return s
 }
@@ -0,0 +1,253 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+)
+
+// Dimensions usage is summed up by, total sums up everything.
+const (
+	UsageByTotal     = "total"
+	UsageByAppSet    = "appSet"
+	UsageByHost      = "host"
+	UsageByBackupSet = "backupSet"
+)
+
+// Tiers of storage. Record in OSS with an archive is counted in both.
+const (
+	UsageTierAll = iota
+	UsageTierOss
+	UsageTierOas
+)
+
+// UsageTierNames names tiers, in reports and price settings.
+var UsageTierNames = map[int]string{
+	UsageTierOss: "oss",
+	UsageTierOas: "oas",
+}
+
+var ErrorUsageDimension = errors.New("Unknown usage dimension")
+
+const bytesPerGB = 1 << 30
+
+// UsageSnapshots is usage of something of a dimension in a tier on a day.
+// Usage now is of the same form, not saved.
+type UsageSnapshots struct {
+	Id        int       `orm:"pk;auto" json:"id"`
+	Date      time.Time `orm:"type(date);index" json:"date"`
+	Dimension string    `orm:"size(16);index" json:"dimension"`
+	Key       string    `orm:"size(36)" json:"key"` // Id of app set, host or backup set
+	Name      string    `orm:"size(255)" json:"name"`
+	Tier      int       `json:"tier"`
+	Objects   int64     `json:"objects"`
+	Bytes     int64     `json:"bytes"`        // Sum of record sizes known
+	Cost      float64   `orm:"-" json:"cost"` // Monthly, by price now
+}
+
+func init() {
+	if prefix := beego.AppConfig.String("database::mysqlprefex"); prefix != "" {
+		orm.RegisterModelWithPrefix(prefix, new(UsageSnapshots))
+	} else {
+		orm.RegisterModel(new(UsageSnapshots))
+	}
+}
+
+// UsagePrice is monthly price per GB of tier, misc::osspricepergb or
+// misc::oaspricepergb.
+func UsagePrice(tier int) float64 {
+	return beego.AppConfig.DefaultFloat(
+		"misc::"+UsageTierNames[tier]+"pricepergb", 0,
+	)
+}
+
+// usageDate is the day of t, snapshots are taken by day.
+func usageDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// usageTable is table of things dimension sums up by, and column of records
+// naming them, both empty for total.
+func usageTable(dimension string) (string, string, error) {
+	switch dimension {
+	case UsageByTotal:
+		return "", "", nil
+	case UsageByAppSet:
+		return "app_sets", "app_set_id", nil
+	case UsageByHost:
+		return "hosts", "host_id", nil
+	case UsageByBackupSet:
+		return "backup_sets", "backup_set_id", nil
+	}
+	return "", "", ErrorUsageDimension
+}
+
+// Price sets cost of usage by price now.
+func (a *UsageSnapshots) Price() {
+	a.Cost = float64(a.Bytes) / bytesPerGB * UsagePrice(a.Tier)
+}
+
+// GetUsage sums up records by dimension and tier now, ordered by name and
+// tier, and priced.
+func GetUsage(dimension string) ([]*UsageSnapshots, error) {
+	table, column, err := usageTable(dimension)
+	if err != nil {
+		return nil, err
+	}
+	o := orm.NewOrm()
+	names := make(map[string]string)
+	if table != "" {
+		var l []orm.ParamsList
+		_, err := o.QueryTable(table).ValuesList(&l, "id", "name")
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range l {
+			names[fmt.Sprint(v[0])] = fmt.Sprint(v[1])
+		}
+	}
+
+	records := beego.AppConfig.String("database::mysqlprefex") + "records"
+	key, group := "''", ""
+	if column != "" {
+		key, group = column, " GROUP BY "+column
+	}
+	today := usageDate(time.Now())
+	r := make([]*UsageSnapshots, 0)
+	for _, v := range []struct {
+		tier  int
+		where string
+		args  []interface{}
+	}{
+		{UsageTierOss, "type = ?", []interface{}{RecordTypeBackup}},
+		{UsageTierOas, "archive_id IS NOT NULL AND archive_id <> ''", nil},
+	} {
+		var l []orm.ParamsList
+		_, err = o.Raw(
+			"SELECT "+key+", COUNT(*), COALESCE(SUM(size), 0) FROM "+
+				records+" WHERE "+v.where+group,
+			v.args...,
+		).ValuesList(&l)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range l {
+			u := &UsageSnapshots{
+				Date:      today,
+				Dimension: dimension,
+				Tier:      v.tier,
+			}
+			if row[0] != nil {
+				u.Key = fmt.Sprint(row[0])
+				u.Name = names[u.Key]
+			}
+			u.Objects, err = strconv.ParseInt(fmt.Sprint(row[1]), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			if u.Objects == 0 {
+				continue
+			}
+			u.Bytes, err = strconv.ParseInt(fmt.Sprint(row[2]), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			u.Price()
+			r = append(r, u)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Name != r[j].Name {
+			return r[i].Name < r[j].Name
+		}
+		return r[i].Tier < r[j].Tier
+	})
+	return r, nil
+}
+
+// SnapshotUsage saves usage now of every dimension as snapshot of today,
+// in place of one taken earlier today.
+func SnapshotUsage() (int, error) {
+	r := make([]*UsageSnapshots, 0)
+	for _, v := range []string{
+		UsageByTotal, UsageByAppSet, UsageByHost, UsageByBackupSet,
+	} {
+		usage, err := GetUsage(v)
+		if err != nil {
+			return 0, err
+		}
+		r = append(r, usage...)
+	}
+
+	o := orm.NewOrm()
+	err := o.Begin()
+	if err != nil {
+		return 0, err
+	}
+	today := usageDate(time.Now())
+	_, err = o.QueryTable("usage_snapshots").Filter("date", today).Delete()
+	if err != nil {
+		o.Rollback()
+		return 0, err
+	}
+	for _, v := range r {
+		_, err = o.Insert(v)
+		if err != nil {
+			o.Rollback()
+			return 0, err
+		}
+	}
+	beego.Debug("[M] UsageSnapshots info saved")
+	o.Commit()
+	return len(r), nil
+}
+
+// GetUsageSnapshots gets snapshots of dimension, of name and tier if given,
+// from start to end if not zero, ordered by date, name and tier, and
+// priced.
+func GetUsageSnapshots(dimension, name string, tier int, start, end time.Time) ([]*UsageSnapshots, error) {
+	_, _, err := usageTable(dimension)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]*UsageSnapshots, 0)
+	o := orm.NewOrm()
+	q := o.QueryTable("usage_snapshots").Filter("dimension", dimension)
+	if name != "" {
+		q = q.Filter("name", name)
+	}
+	if tier != UsageTierAll {
+		q = q.Filter("tier", tier)
+	}
+	if !start.IsZero() {
+		q = q.Filter("date__gte", start)
+	}
+	if !end.IsZero() {
+		q = q.Filter("date__lte", end)
+	}
+	_, err = q.OrderBy("date", "name", "tier").All(&r)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range r {
+		v.Price()
+	}
+	return r, nil
+}
+
+// LastUsageDate is date of latest snapshot, zero if none is taken.
+func LastUsageDate() (time.Time, error) {
+	r := make([]*UsageSnapshots, 0)
+	o := orm.NewOrm()
+	_, err := o.QueryTable("usage_snapshots").
+		OrderBy("-date").Limit(1).All(&r, "Date")
+	if err != nil || len(r) == 0 {
+		return time.Time{}, err
+	}
+	return r[0].Date, nil
+}
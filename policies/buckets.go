@@ -0,0 +1,241 @@
+/*ModuleAB policies/buckets.go -- Reconciling buckets with records.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package policies
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+)
+
+// CheckBuckets reconciles every bucket each misc::ossreconcileperiod
+// hours, 0 means never. Problems are fixed if misc::ossreconcilefix is
+// set.
+func CheckBuckets() {
+	period := beego.AppConfig.DefaultInt64("misc::ossreconcileperiod", 24)
+	if period <= 0 {
+		beego.Info("Bucket reconciliation is disabled.")
+		return
+	}
+	beego.Debug("CheckBuckets() running...")
+	defer beego.Debug("CheckBuckets() STOPPED!")
+	runPeriodically("buckets", time.Duration(period)*time.Hour,
+		models.LastBucketReconciliation,
+		func() {
+			ReconcileBuckets(
+				beego.AppConfig.DefaultBool("misc::ossreconcilefix", false),
+			)
+		},
+	)
+}
+
+// ReconcileBuckets reconciles every bucket one by one.
+func ReconcileBuckets(fix bool) {
+	oss, err := models.GetOss(&models.Oss{}, 0, 0)
+	if err != nil {
+		beego.Warn("Got error on retrieving OSS records:", err)
+		return
+	}
+	for _, v := range oss {
+		_, err = ReconcileBucket(v, fix)
+		switch err {
+		case nil:
+		case common.ErrorLocked:
+			beego.Info("Bucket", v.BucketName, "is reconciled by other server, skip.")
+		default:
+			beego.Warn("Cannot reconcile bucket:", v.BucketName, "error:", err)
+		}
+	}
+}
+
+// ReconcileBucket compares objects in bucket with backup records in it,
+// see reconcileBucket. common.ErrorLocked is returned if bucket is being
+// reconciled.
+func ReconcileBucket(oss *models.Oss, fix bool) (*models.Reconciliations, error) {
+	ctx, release, err := common.Acquire(context.Background(), "reconcile:oss:"+oss.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return reconcileBucket(ctx, oss, fix)
+}
+
+// StartReconcileBucket is ReconcileBucket in background, lock is taken
+// before it returns.
+func StartReconcileBucket(oss *models.Oss, fix bool) error {
+	ctx, release, err := common.Acquire(context.Background(), "reconcile:oss:"+oss.Id)
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer release()
+		_, err := reconcileBucket(ctx, oss, fix)
+		if err != nil {
+			beego.Warn("Cannot reconcile bucket:", oss.BucketName, "error:", err)
+		}
+	}()
+	return nil
+}
+
+// reconcileBucket lists objects under prefix of each host and path kept in
+// bucket, deeper keys belong to other paths. Objects nobody records are
+// orphans, records without object are missing. Objects newer than
+// misc::ossreconcilegrace seconds may not be recorded yet, and are left
+// out. If fix is set, orphans are deleted, and missing records are turned
+// to archive records if archived, or deleted if not held. Alarm is raised
+// if any is found.
+func reconcileBucket(ctx context.Context, oss *models.Oss, fix bool) (*models.Reconciliations, error) {
+	s, err := oss.Storage()
+	if err != nil {
+		return nil, err
+	}
+	records, err := models.StoredRecords(oss.Id)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := models.GetHosts(&models.Hosts{}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	for _, v := range records {
+		recorded[v.GetFullPath()] = true
+		prefixes[models.StoragePrefix(v.AppSet.Name, v.Host.Name, v.Path.Path)] = true
+	}
+	for _, h := range hosts {
+		if h.AppSet == nil {
+			continue
+		}
+		for _, p := range h.Paths {
+			if p.BackupSet == nil || p.BackupSet.Oss == nil ||
+				p.BackupSet.Oss.Id != oss.Id {
+				continue
+			}
+			prefixes[models.StoragePrefix(h.AppSet.Name, h.Name, p.Path)] = true
+		}
+	}
+	sorted := make([]string, 0, len(prefixes))
+	for k := range prefixes {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	grace := time.Duration(
+		beego.AppConfig.DefaultInt64("misc::ossreconcilegrace", 3600),
+	) * time.Second
+	r := &models.Reconciliations{
+		Oss:        oss,
+		ListedTime: time.Now(),
+		Fix:        fix,
+		Items:      make([]*models.ReconcileItems, 0),
+	}
+	stored := make(map[string]bool)
+	for _, prefix := range sorted {
+		err = common.EndpointLimiter(oss.Endpoint).Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		objects, err := s.ListObjects(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range objects {
+			if strings.Contains(strings.TrimPrefix(v.Key, prefix), "/") {
+				continue
+			}
+			stored[v.Key] = true
+			r.Stored++
+			if recorded[v.Key] || v.LastModified.After(r.ListedTime.Add(-grace)) {
+				continue
+			}
+			item := &models.ReconcileItems{
+				Problem: models.ReconcileProblemOrphan,
+				Key:     v.Key,
+				Size:    v.Size,
+			}
+			if fix {
+				err = common.EndpointLimiter(oss.Endpoint).Wait(ctx)
+				if err == nil {
+					err = s.DeleteObject(v.Key)
+				}
+				fixed(item, err)
+			}
+			r.Items = append(r.Items, item)
+		}
+	}
+	for _, v := range records {
+		r.Records++
+		if stored[v.GetFullPath()] {
+			continue
+		}
+		item := &models.ReconcileItems{
+			Problem: models.ReconcileProblemMissing,
+			Key:     v.GetFullPath(),
+			Record:  v,
+		}
+		if fix {
+			fixed(item, fixMissingObject(v))
+			if item.Fixed && v.Type != models.RecordTypeArchive {
+				item.Record = nil
+				item.Description = "Deleted record " + v.Id
+			}
+		}
+		r.Items = append(r.Items, item)
+	}
+
+	_, err = models.AddReconciliation(r)
+	if err != nil {
+		return nil, err
+	}
+	beego.Info("Bucket", oss.BucketName, "reconciled:", r.Orphans, "orphans,",
+		r.Missing, "missing,", r.Fixed, "fixed.")
+	if r.Orphans+r.Missing != 0 {
+		msg := fmt.Sprintf(
+			"Bucket %s has %d orphan and %d missing objects, %d fixed, see reconciliation %s",
+			oss.BucketName, r.Orphans, r.Missing, r.Fixed, r.Id,
+		)
+		beego.Warn(msg)
+		err = common.Alarm("", msg)
+		if err != nil {
+			beego.Warn("Cannot run alarm:", err)
+		}
+	}
+	return r, nil
+}
+
+// fixMissingObject turns record whose object is gone to archive record if
+// it is archived, or deletes it.
+func fixMissingObject(rec *models.Records) error {
+	if rec.ArchiveId != "" {
+		rec.Type = models.RecordTypeArchive
+		return models.UpdateRecord(rec, "Type")
+	}
+	if rec.IsHeld() {
+		return models.ErrorRecordHeld
+	}
+	return models.DeleteRecord(rec)
+}
+
+// fixed notes item is fixed, or why not.
+func fixed(item *models.ReconcileItems, err error) {
+	if err != nil {
+		item.Error = err.Error()
+		if len(item.Error) > 255 {
+			item.Error = item.Error[:255]
+		}
+		return
+	}
+	item.Fixed = true
+}
@@ -0,0 +1,194 @@
+/*ModuleAB policies/inventory.go -- Reconciling vaults with records.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+)
+
+// ErrorInventoryRunning is returned when vault has inventory retrieval job
+// not over yet.
+var ErrorInventoryRunning = errors.New("Inventory retrieval is running")
+
+const inventoryDesc = "ModuleAB inventory"
+
+// CheckInventory retrieves inventory of every vault each
+// misc::inventoryperiod hours, 0 means never.
+func CheckInventory() {
+	period := beego.AppConfig.DefaultInt64("misc::inventoryperiod", 24)
+	if period <= 0 {
+		beego.Info("Inventory retrieval is disabled.")
+		return
+	}
+	beego.Debug("CheckInventory() running...")
+	defer beego.Debug("CheckInventory() STOPPED!")
+	runPeriodically("inventory", time.Duration(period)*time.Hour,
+		func() (time.Time, error) {
+			return models.LastOasJobTime(models.OasJobTypeInventoryRetrieval)
+		},
+		RetrieveInventories,
+	)
+}
+
+// RetrieveInventories submits inventory retrieval job of every vault. Jobs
+// are checked by SweepOasJobs, vault is reconciled when its job is done.
+func RetrieveInventories() {
+	_, release, err := common.Acquire(context.Background(), "inventory")
+	if err == common.ErrorLocked {
+		beego.Info("Inventory is retrieved by other server, skip.")
+		return
+	}
+	if err != nil {
+		beego.Warn("Cannot lock inventory:", err)
+		return
+	}
+	defer release()
+
+	oas, err := models.GetOas(&models.Oas{}, 0, 0)
+	if err != nil {
+		beego.Warn("Got error on retrieving OAS records:", err)
+		return
+	}
+	for _, v := range oas {
+		_, err = RetrieveInventory(v)
+		switch err {
+		case nil:
+		case ErrorInventoryRunning:
+			beego.Info("Inventory of vault", v.Id, "is running, skip.")
+		default:
+			beego.Warn("Cannot retrieve inventory of vault:", v.Id,
+				"error:", err)
+		}
+	}
+}
+
+// RetrieveInventory submits inventory retrieval job of vault v. If one is
+// not over yet, it is returned with ErrorInventoryRunning.
+func RetrieveInventory(v *models.Oas) (*models.OasJobs, error) {
+	jobs, err := models.GetOasJobs(&models.OasJobs{
+		Vault:   v,
+		JobType: models.OasJobTypeInventoryRetrieval,
+	}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		if !j.IsOver() {
+			return j, ErrorInventoryRunning
+		}
+	}
+	o, err := v.Archive()
+	if err != nil {
+		return nil, err
+	}
+	reqId, jobId, err := o.RetrieveInventory(inventoryDesc)
+	if err != nil {
+		return nil, err
+	}
+	job := &models.OasJobs{
+		Vault:     v,
+		RequestId: reqId,
+		JobId:     jobId,
+		JobType:   models.OasJobTypeInventoryRetrieval,
+	}
+	_, err = models.AddOasJobs(job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ReconcileVault compares inventory got by job with records archived in
+// vault. Archives nobody records are orphans, recorded ones not in
+// inventory are missing. Archives being deleted, and records archived
+// after inventory was taken, are left out. Alarm is raised if any is found.
+func ReconcileVault(o common.Archive, job *models.OasJobs) (*models.Reconciliations, error) {
+	inventory, err := o.GetInventory(job.JobId)
+	if err != nil {
+		return nil, err
+	}
+	records, err := models.ArchivedRecords(job.Vault.Id)
+	if err != nil {
+		return nil, err
+	}
+	deletes, err := models.GetOasJobs(&models.OasJobs{
+		Vault:   job.Vault,
+		JobType: models.OasJobTypeDeleteArchive,
+	}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[string]bool)
+	for _, v := range deletes {
+		deleted[v.ArchiveId] = true
+	}
+
+	r := &models.Reconciliations{
+		Vault:      job.Vault,
+		JobId:      job.JobId,
+		ListedTime: inventory.Date,
+		Stored:     len(inventory.Archives),
+		Items:      make([]*models.ReconcileItems, 0),
+	}
+	recorded := make(map[string]bool)
+	for _, v := range records {
+		recorded[v.ArchiveId] = true
+	}
+	inVault := make(map[string]bool)
+	for _, v := range inventory.Archives {
+		inVault[v.ArchiveId] = true
+		if recorded[v.ArchiveId] || deleted[v.ArchiveId] {
+			continue
+		}
+		r.Items = append(r.Items, &models.ReconcileItems{
+			Problem:     models.ReconcileProblemOrphan,
+			Key:         v.ArchiveId,
+			Description: v.Description,
+			Size:        v.Size,
+		})
+	}
+	for _, v := range records {
+		if v.ArchivedTime.After(inventory.Date) || deleted[v.ArchiveId] {
+			continue
+		}
+		r.Records++
+		if inVault[v.ArchiveId] {
+			continue
+		}
+		r.Items = append(r.Items, &models.ReconcileItems{
+			Problem:     models.ReconcileProblemMissing,
+			Key:         v.ArchiveId,
+			Description: v.GetFullPath(),
+			Record:      v,
+		})
+	}
+	_, err = models.AddReconciliation(r)
+	if err != nil {
+		return nil, err
+	}
+	beego.Info("Vault", job.Vault.Id, "reconciled:", r.Orphans, "orphans,",
+		r.Missing, "missing.")
+	if r.Orphans+r.Missing != 0 {
+		msg := fmt.Sprintf(
+			"Vault %s has %d orphan and %d missing archives, see reconciliation %s",
+			job.Vault.VaultName, r.Orphans, r.Missing, r.Id,
+		)
+		beego.Warn(msg)
+		err = common.Alarm("", msg)
+		if err != nil {
+			beego.Warn("Cannot run alarm:", err)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,46 @@
+/*ModuleAB policies/periodic.go -- Running jobs each some hours.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package policies
+
+import (
+	"time"
+
+	"github.com/astaxie/beego"
+)
+
+// runPeriodically runs run each period, as soon as period has passed since
+// last run, which last tells, zero if never. So job is not put off when
+// server restarts, nor run again if other server has run it meanwhile. If
+// run leaves nothing for last to tell, it waits a whole period.
+func runPeriodically(name string, period time.Duration, last func() (time.Time, error), run func()) {
+	timer := time.NewTimer(nextRun(name, period, last))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			run()
+			wait := nextRun(name, period, last)
+			if wait <= 0 {
+				wait = period
+			}
+			timer.Reset(wait)
+		}
+	}
+}
+
+func nextRun(name string, period time.Duration, last func() (time.Time, error)) time.Duration {
+	t, err := last()
+	if err != nil {
+		beego.Warn("Cannot get last run of", name, "error:", err)
+		return 0
+	}
+	if t.IsZero() {
+		return 0
+	}
+	wait := period - time.Since(t)
+	beego.Debug("Next run of", name, "in", wait)
+	return wait
+}
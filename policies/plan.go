@@ -0,0 +1,407 @@
+/*ModuleAB policies/plan.go -- Deciding what a policy does to records.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package policies
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+)
+
+const (
+	DecisionKeep    = "keep"
+	DecisionArchive = "archive"
+	DecisionDelete  = "delete"
+)
+
+// How many records are got from database at once.
+const planPageSize = 1000
+
+// Decision is what a policy will do to a record, and why.
+type Decision struct {
+	Record *models.Records `json:"record"`
+	Action string          `json:"action"`
+	Reason string          `json:"reason"`
+	Held   bool            `json:"held"`
+}
+
+// Plan decides what policy p does to every record it matches, nothing is
+// changed. RunPolicy carries decisions out, preview just shows them.
+func Plan(p *models.Policies) ([]*Decision, error) {
+	groups, err := planGroups(p)
+	if err != nil {
+		return nil, err
+	}
+	decisions := make([]*Decision, 0)
+	for _, g := range groups {
+		decisions = append(decisions, g...)
+	}
+	return decisions, nil
+}
+
+// planGroups decides by app set, host and path. Each group should be
+// carried out in order, different groups may go at the same time.
+func planGroups(p *models.Policies) ([][]*Decision, error) {
+	var backupStart, backupEnd, archiveStart, archiveEnd time.Time
+	now := time.Now()
+	switch p.Target {
+	case models.PolicyTargetBackup:
+		if p.TargetEnd != models.PolicyTargetTimeLongLongAgo {
+			backupStart = now.Add(
+				time.Duration(-p.TargetEnd) * time.Second,
+			)
+		}
+		backupEnd = now.Add(
+			time.Duration(-p.TargetStart) * time.Second,
+		)
+		if backupEnd.Before(backupStart) {
+			return nil, fmt.Errorf("End time is before than start time")
+		}
+	case models.PolicyTargetArchive:
+		if p.TargetEnd != models.PolicyTargetTimeLongLongAgo {
+			archiveStart = now.Add(
+				time.Duration(-p.TargetEnd) * time.Second,
+			)
+		}
+		archiveEnd = now.Add(
+			time.Duration(-p.TargetStart) * time.Second,
+		)
+		if archiveEnd.Before(archiveStart) {
+			return nil, fmt.Errorf("End time is before than start time")
+		}
+	}
+
+	groups := make([][]*Decision, 0)
+	for _, appSet := range p.AppSets {
+		for _, host := range p.Hosts {
+			for _, path := range p.Paths {
+				records := make([]*models.Records, 0)
+				for i := 0; ; i += planPageSize {
+					r, err := models.GetRecords(
+						&models.Records{
+							BackupSet: p.BackupSet,
+							AppSet:    appSet,
+							Host:      host,
+							Path:      path,
+							Type:      p.Target,
+						},
+						planPageSize, i, models.OrderAsc, models.OrderAsc,
+						backupStart, backupEnd,
+						archiveStart, archiveEnd,
+					)
+					if err != nil {
+						return nil, err
+					}
+					records = append(records, r...)
+					if len(r) < planPageSize {
+						break
+					}
+				}
+				beego.Debug("Got matched records length:", len(records))
+				if len(records) != 0 {
+					groups = append(groups, planRecords(p, records))
+				}
+			}
+		}
+	}
+	return groups, nil
+}
+
+// planRecords decides on records of one host and path, which are in time
+// order, by retention mode of policy p. Held records are always kept,
+// records being archived are not archived again, and records retrieved
+// from archive are not deleted before their download signal expires.
+func planRecords(p *models.Policies, records []*models.Records) []*Decision {
+	var decisions []*Decision
+	switch p.Retention {
+	case models.PolicyRetentionGFS:
+		decisions = planGFS(p, records)
+	case models.PolicyRetentionCount:
+		decisions = planCount(p, records)
+	default:
+		decisions = planStep(p, records)
+	}
+	for _, d := range decisions {
+		if d.Action == DecisionArchive && archiving(d.Record) {
+			d.Action = DecisionKeep
+			d.Reason = "Archive job is running"
+		}
+		if d.Action == DecisionDelete && retrieving(d.Record) {
+			d.Action = DecisionKeep
+			d.Reason = "Retrieved from archive to be downloaded"
+		}
+		if !d.Record.IsHeld() {
+			continue
+		}
+		d.Held = true
+		if d.Action == DecisionDelete {
+			d.Reason = fmt.Sprintf(
+				"%s, would be deleted: %s", d.Record.HoldInfo(), d.Reason,
+			)
+			d.Action = DecisionKeep
+		}
+	}
+	return decisions
+}
+
+// planStep walks records in time order. A kept or archived record becomes
+// the baseline, which following ones are measured from by policy step.
+func planStep(p *models.Policies, records []*models.Records) []*Decision {
+	decisions := make([]*Decision, 0, len(records))
+	if len(records) == 0 {
+		return decisions
+	}
+	stepS := time.Duration(p.Step) * time.Second
+	baseLine := records[0]
+	for _, r := range records {
+		d := &Decision{Record: r, Action: DecisionKeep}
+		decisions = append(decisions, d)
+
+		switch p.Action {
+		case models.PolicyActionArchive:
+			switch r.Type {
+			case models.RecordTypeBackup:
+				if r.ArchiveId != "" {
+					d.Reason = "Already archived"
+					continue
+				}
+				step := r.BackupTime.Sub(baseLine.BackupTime)
+				if p.Step == models.PolicyReserveNone {
+					d.Reason = "Policy step reserves none"
+					continue
+				}
+				if step < stepS {
+					d.Reason = fmt.Sprintf(
+						"%s from baseline %s, less than step %s",
+						step, baseLine.Id, stepS,
+					)
+					continue
+				}
+				baseLine = r
+				d.Action = DecisionArchive
+				d.Reason = fmt.Sprintf(
+					"%s from baseline, not less than step %s", step, stepS,
+				)
+			case models.RecordTypeArchive:
+				d.Reason = "Archive record cannot be archived again"
+			}
+
+		case models.PolicyActionDelete:
+			var step time.Duration
+			switch r.Type {
+			case models.RecordTypeBackup:
+				step = r.BackupTime.Sub(baseLine.BackupTime)
+			case models.RecordTypeArchive:
+				step = r.ArchivedTime.Sub(baseLine.ArchivedTime)
+			default:
+				d.Reason = "Unknown record type"
+				continue
+			}
+			if (step >= stepS || step < time.Duration(p.Step)*time.Hour*24) &&
+				p.Step != models.PolicyReserveNone {
+				baseLine = r
+				d.Reason = fmt.Sprintf(
+					"%s from baseline, kept by step %s", step, stepS,
+				)
+				continue
+			}
+			d.Action = DecisionDelete
+			if p.Step == models.PolicyReserveNone {
+				d.Reason = "Policy step reserves none"
+			} else {
+				d.Reason = fmt.Sprintf(
+					"%s from baseline %s, not kept by step %s",
+					step, baseLine.Id, stepS,
+				)
+			}
+			if r.Type == models.RecordTypeBackup && r.ArchiveId != "" {
+				d.Reason += ", archive is kept"
+			}
+
+		default:
+			d.Reason = "Policy has no action"
+		}
+	}
+	return decisions
+}
+
+// gfsRule keeps newest record of each of the latest count periods, key
+// names the period a time is in.
+type gfsRule struct {
+	name  string
+	count int
+	key   func(t time.Time) string
+}
+
+// planGFS keeps records by grandfather-father-son rotation: newest record
+// of each of the latest KeepDaily days, KeepWeekly weeks, KeepMonthly
+// months and KeepYearly years. Periods are in policy's time zone. Delete
+// action deletes records not kept, archive action archives kept ones.
+func planGFS(p *models.Policies, records []*models.Records) []*Decision {
+	loc, err := p.GetLocation()
+	if err != nil {
+		loc = time.Local
+	}
+	rules := []gfsRule{
+		{"daily", p.KeepDaily, func(t time.Time) string {
+			return t.Format("2006-01-02")
+		}},
+		{"weekly", p.KeepWeekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", y, w)
+		}},
+		{"monthly", p.KeepMonthly, func(t time.Time) string {
+			return t.Format("2006-01")
+		}},
+		{"yearly", p.KeepYearly, func(t time.Time) string {
+			return t.Format("2006")
+		}},
+	}
+
+	// Newest first, so first record seen in a period is the one kept.
+	sorted := make([]*models.Records, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return recordTime(sorted[i]).After(recordTime(sorted[j]))
+	})
+	keptBy := make(map[string][]string)
+	for _, rule := range rules {
+		periods := make(map[string]bool)
+		for _, r := range sorted {
+			if len(periods) >= rule.count {
+				break
+			}
+			key := rule.key(recordTime(r).In(loc))
+			if periods[key] {
+				continue
+			}
+			periods[key] = true
+			keptBy[r.Id] = append(keptBy[r.Id], rule.name+" "+key)
+		}
+	}
+
+	decisions := make([]*Decision, 0, len(records))
+	for _, r := range records {
+		d := &Decision{Record: r, Action: DecisionKeep}
+		decisions = append(decisions, d)
+		kept, ok := keptBy[r.Id]
+		switch p.Action {
+		case models.PolicyActionArchive:
+			switch {
+			case r.Type == models.RecordTypeArchive:
+				d.Reason = "Archive record cannot be archived again"
+			case r.ArchiveId != "":
+				d.Reason = "Already archived"
+			case !ok:
+				d.Reason = "Not kept by GFS retention"
+			default:
+				d.Action = DecisionArchive
+				d.Reason = fmt.Sprintf("Kept by GFS retention: %s", kept)
+			}
+		case models.PolicyActionDelete:
+			if ok {
+				d.Reason = fmt.Sprintf("Kept by GFS retention: %s", kept)
+				continue
+			}
+			d.Action = DecisionDelete
+			d.Reason = "Not kept by GFS retention"
+			if r.Type == models.RecordTypeBackup && r.ArchiveId != "" {
+				d.Reason += ", archive is kept"
+			}
+		default:
+			d.Reason = "Policy has no action"
+		}
+	}
+	return decisions
+}
+
+// planCount keeps latest KeepCount backup records and latest KeepCount
+// archive records, no matter how old they are. Delete action deletes older
+// ones, archive action archives older backups.
+func planCount(p *models.Policies, records []*models.Records) []*Decision {
+	// Newest first, counting each type on its own.
+	sorted := make([]*models.Records, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return recordTime(sorted[i]).After(recordTime(sorted[j]))
+	})
+	nth := make(map[string]int)
+	counts := make(map[int]int)
+	for _, r := range sorted {
+		counts[r.Type]++
+		nth[r.Id] = counts[r.Type]
+	}
+
+	decisions := make([]*Decision, 0, len(records))
+	for _, r := range records {
+		d := &Decision{Record: r, Action: DecisionKeep}
+		decisions = append(decisions, d)
+		n := nth[r.Id]
+		kept := n <= p.KeepCount
+		if kept {
+			d.Reason = fmt.Sprintf(
+				"Number %d, among latest %d", n, p.KeepCount,
+			)
+		} else {
+			d.Reason = fmt.Sprintf(
+				"Number %d, not among latest %d", n, p.KeepCount,
+			)
+		}
+		switch p.Action {
+		case models.PolicyActionArchive:
+			switch {
+			case r.Type == models.RecordTypeArchive:
+				d.Reason = "Archive record cannot be archived again"
+			case r.ArchiveId != "":
+				d.Reason = "Already archived"
+			case !kept:
+				d.Action = DecisionArchive
+			}
+		case models.PolicyActionDelete:
+			if kept {
+				continue
+			}
+			d.Action = DecisionDelete
+			if r.Type == models.RecordTypeBackup && r.ArchiveId != "" {
+				d.Reason += ", archive is kept"
+			}
+		default:
+			d.Reason = "Policy has no action"
+		}
+	}
+	return decisions
+}
+
+// archiving tells if an archive job of r is running or to be retried.
+func archiving(r *models.Records) bool {
+	for _, j := range r.Jobs {
+		if j.JobType == models.OasJobTypePullFromOSS && !j.IsOver() {
+			return true
+		}
+	}
+	return false
+}
+
+// retrieving tells if r is pushed back to OSS from archive lately, and may
+// not be downloaded yet. Signal to download it lasts misc::signalexpire.
+func retrieving(r *models.Records) bool {
+	expire := time.Duration(
+		beego.AppConfig.DefaultInt64("misc::signalexpire", 86400),
+	) * time.Second
+	return !r.RetrieveTime.IsZero() && time.Since(r.RetrieveTime) < expire
+}
+
+// recordTime is when backup of r is made, or archived for archive record.
+func recordTime(r *models.Records) time.Time {
+	if r.Type == models.RecordTypeArchive {
+		return r.ArchivedTime
+	}
+	return r.BackupTime
+}
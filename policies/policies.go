@@ -6,6 +6,8 @@
 package policies
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"time"
 
@@ -15,21 +17,9 @@ import (
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/orm"
 	"github.com/pborman/uuid"
-	"github.com/robfig/cron"
 )
 
-func init() {
-	cronSpec := beego.AppConfig.String("misc::policyrun")
-	cron := cron.New()
-	err := cron.AddFunc(cronSpec, RunPolicies)
-	if err != nil {
-		beego.Warn("Policy may not be executed for error:", err)
-		return
-
-	}
-	cron.Start()
-}
-
+// RunPolicies runs every enabled policy at once, one by one.
 func RunPolicies() {
 	beego.Debug("Policy running...")
 	policies, err := models.GetPolicies(&models.Policies{}, 0, 0)
@@ -38,228 +28,70 @@ func RunPolicies() {
 		return
 	}
 	for _, p := range policies {
-		beego.Info("Run policy id:", p.Id)
-		var backupStart, backupEnd, archiveStart, archiveEnd time.Time
-		now := time.Now()
-		switch p.Target {
-		case models.PolicyTargetBackup:
-			if p.TargetEnd != models.PolicyTargetTimeLongLongAgo {
-				backupStart = now.Add(
-					time.Duration(-p.TargetEnd) * time.Second,
-				)
-			}
-			backupEnd = now.Add(
-				time.Duration(-p.TargetStart) * time.Second,
-			)
-			if backupEnd.Before(backupStart) {
-				beego.Warn("End time is before than start time")
-				continue
-			}
-		case models.PolicyTargetArchive:
-			if p.TargetEnd != models.PolicyTargetTimeLongLongAgo {
-				archiveStart = now.Add(
-					time.Duration(-p.TargetEnd) * time.Second,
-				)
-			}
-			archiveEnd = now.Add(
-				time.Duration(-p.TargetStart) * time.Second,
-			)
-			if archiveEnd.Before(archiveStart) {
-				beego.Warn("End time is before than start time")
-				continue
-			}
+		if !p.Enabled {
+			beego.Debug("Policy", p.Id, "is disabled, skip.")
+			continue
 		}
+		RunPolicy(p)
+	}
+}
 
-		for _, appSet := range p.AppSets {
-			for _, host := range p.Hosts {
-				for _, path := range p.Paths {
-					var i = 0
-					for {
-						records, err := models.GetRecords(
-							&models.Records{
-								BackupSet: p.BackupSet,
-								AppSet:    appSet,
-								Host:      host,
-								Path:      path,
-								Type:      p.Target,
-							},
-							1000, i, models.OrderAsc, models.OrderAsc,
-							backupStart, backupEnd,
-							archiveStart, archiveEnd,
-						)
-						if err != nil {
-							beego.Warn("Get records failed", err)
-							continue
-						}
-
-						if len(records) == 0 {
-							beego.Debug("No records.")
-							break
-						}
-						beego.Debug("Got matched records length:", len(records))
-
-						baseLine := records[0]
-						for _, r := range records {
-							oas, err := common.NewOasClient(r.BackupSet.Oas.Endpoint)
-							if err != nil {
-								beego.Warn("Cannot connect to OAS Service:", err)
-								continue
-							}
-							oss, err := common.NewOssClient(r.BackupSet.Oss.Endpoint)
-							if err != nil {
-								beego.Warn("Cannot connect to OSS Service:", err)
-								continue
-							}
-							bucket, err := oss.Bucket(r.BackupSet.Oss.BucketName)
-							if err != nil {
-								beego.Warn(
-									"Cannot get bucket:", r.BackupSet.Oss.BucketName,
-									"error:", err,
-								)
-								continue
-							}
-
-							switch p.Action {
-							case models.PolicyActionArchive:
-								beego.Debug("Action type: Archive")
-								switch r.Type {
-								case models.RecordTypeBackup:
-									beego.Debug("Record type: Backup")
-									if r.ArchiveId != "" {
-										beego.Debug("Record", r.Id, "have archived, skip.")
-										continue
-									}
-									step := r.BackupTime.Sub(baseLine.BackupTime)
-									beego.Debug("Step=", step)
-									if step >= time.Duration(p.Step)*time.Second &&
-										p.Step != models.PolicyReserveNone {
-										beego.Debug("New baseline is:", r.Id)
-										baseLine = r
-
-										var reqId, jobId string
-										beego.Debug(
-											"ArchiveToOas:",
-											r.BackupSet.Oas.VaultId,
-											common.ConvertOssAddrToInternal(
-												r.BackupSet.Oss.Endpoint,
-											),
-											r.BackupSet.Oss.BucketName,
-											r.GetFullPath(),
-										)
-										reqId, jobId, err = oas.ArchiveToOas(
-											r.BackupSet.Oas.VaultId,
-											common.ConvertOssAddrToInternal(
-												r.BackupSet.Oss.Endpoint,
-											),
-											r.BackupSet.Oss.BucketName,
-											r.GetFullPath(),
-											r.GetFullPath(),
-										)
-										if err != nil {
-											beego.Warn("Cannot make job to archive:", err)
-											continue
-										}
-										_, err = models.AddOasJobs(
-											&models.OasJobs{
-												Vault:     r.BackupSet.Oas,
-												RequestId: reqId,
-												JobId:     jobId,
-												JobType:   models.OasJobTypePullFromOSS,
-												Status:    models.OasJobStatusIncomplete,
-												Records:   r,
-											},
-										)
-										if err != nil {
-											beego.Warn("Cannot make oas job:", err)
-										}
-									}
-
-								case models.RecordTypeArchive:
-									beego.Debug("Record type: Archive")
-									beego.Debug("Skip archived data:", r.Id)
-								}
-							case models.PolicyActionDelete:
-								beego.Debug("Action type: Delete")
-								switch r.Type {
-								case models.RecordTypeBackup:
-									beego.Debug("Record type: Backup")
-									step := r.BackupTime.Sub(baseLine.BackupTime)
-									beego.Debug("Step=", step)
-									if (step >= time.Duration(p.Step)*time.Second ||
-										step < time.Duration(p.Step)*time.Hour*24) &&
-										p.Step != models.PolicyReserveNone {
-										baseLine = r
-										continue
-									}
-									err = bucket.DeleteObject(r.GetFullPath())
-									if err != nil {
-										beego.Warn(
-											"Cannot delete backup:", r.GetFullPath(),
-											"error:", err,
-										)
-										continue
-									}
-
-									// Don't delete record with ArchiveId,
-									// Convert it to Archive.
-									if r.ArchiveId == "" {
-										err = models.DeleteRecord(r)
-										if err != nil {
-											beego.Warn(
-												"Cannot delete record:", r.Id,
-												"error:", err,
-											)
-										}
-									} else {
-										r.Type = models.RecordTypeArchive
-										err = models.UpdateRecord(r)
-										if err != nil {
-											beego.Warn(
-												"Cannot update archived record:", r.Id,
-												"error:", err,
-											)
-										}
-									}
-
-								case models.RecordTypeArchive:
-									beego.Debug("Record type: Archive")
-									step := r.ArchivedTime.Sub(baseLine.ArchivedTime)
-									beego.Debug("Step=", step)
-									if (step >= time.Duration(p.Step)*time.Second ||
-										step < time.Duration(p.Step)*time.Hour*24) &&
-										p.Step != models.PolicyReserveNone {
-										beego.Debug("New baseline is:", r.Id)
-										baseLine = r
-										continue
-									}
-
-									beego.Debug("Will delete archive:", r.Id)
-									_, err = oas.DeleteArchive(
-										r.BackupSet.Oas.VaultId,
-										r.ArchiveId,
-									)
-									if err != nil {
-										beego.Warn("Cannot make job to delete archive:", err)
-										continue
-									}
-
-									err = models.DeleteRecord(r)
-									if err != nil {
-										beego.Warn(
-											"Cannot delete record:", r.Id,
-											"error:", err,
-										)
-									}
-									i += 1000
-								}
-							}
-						}
-					}
-				}
-			}
+// RunPolicy archives or deletes records matched by policy p, what it did
+// is saved as a policy run.
+func RunPolicy(p *models.Policies) {
+	RunPolicyContext(context.Background(), p)
+}
+
+// RunPolicyContext is RunPolicy which stops when ctx is done, or when
+// Cancel is called with policy id.
+func RunPolicyContext(ctx context.Context, p *models.Policies) {
+	// Servers sharing database all have the policy scheduled, only the
+	// one got lock runs it.
+	ctx, release, err := common.Acquire(ctx, "policy:"+p.Id)
+	if err == common.ErrorLocked {
+		beego.Info("Policy id", p.Id, "is running on other server, skip.")
+		return
+	}
+	if err != nil {
+		beego.Warn("Cannot lock policy:", p.Id, "error:", err)
+		return
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	setRunning(p.Id, cancel)
+	defer setRunning(p.Id, nil)
+
+	beego.Info("Run policy id:", p.Id)
+	r := newRunner(ctx, p)
+	_, err = models.AddPolicyRun(r.run)
+	if err != nil {
+		beego.Warn("Cannot save policy run:", err)
+	}
+	defer func() {
+		r.run.EndTime = time.Now()
+		if r.run.Id == "" {
+			return
+		}
+		err := models.UpdatePolicyRun(r.run)
+		if err != nil {
+			beego.Warn("Cannot save policy run:", r.run.Id, "error:", err)
 		}
-		beego.Info("Policy id", p.Id, "Done.")
+	}()
+
+	groups, err := planGroups(p)
+	if err != nil {
+		beego.Warn("Cannot plan policy:", p.Id, "error:", err)
+		r.run.Error = err.Error()
+		return
 	}
+	r.Run(groups)
+	if ctx.Err() != nil {
+		beego.Warn("Policy id", p.Id, "cancelled.")
+		r.run.Error = "Cancelled"
+		return
+	}
+	beego.Info("Policy id", p.Id, "Done.")
 }
 
 func InitDb() {
@@ -333,118 +165,368 @@ func InitDb() {
 	}
 }
 
+// CheckOasJob sweeps archive jobs every misc::checkoasjobperiod minutes.
 func CheckOasJob() {
 	period := beego.AppConfig.DefaultInt64("misc::checkoasjobperiod", 5)
 	ticker := time.NewTicker(
 		time.Duration(period) * time.Minute,
 	)
-	reservedays := beego.AppConfig.DefaultInt64("misc::oasjobsreservedays", 7)
 	defer ticker.Stop()
 	beego.Debug("checkOasJob() running...")
 	defer beego.Debug("checkOasJob() STOPPED!")
 	for {
 		select {
 		case <-ticker.C:
-			beego.Info("checkOasJob() start.")
-			oas, err := models.GetOas(&models.Oas{}, 0, 0)
-			if err != nil {
-				beego.Warn("Got error on retrieving OAS records:", err)
-				continue
-			}
-			for _, v := range oas {
-				beego.Debug("Got oas:", v)
-				o, err := common.NewOasClient(v.Endpoint)
-				if err != nil {
-					beego.Warn("Got error on connecting to OAS:", err)
-					continue
-				}
+			SweepOasJobs()
+		}
+	}
+}
 
-				jobCond := &models.OasJobs{
-					Vault: v,
-				}
-				jobs, err := models.GetOasJobs(jobCond, 0, 0)
-				if err != nil {
-					beego.Warn("Got error on retrieving oas jobs:", err)
+// CheckSignals expires signals out of time every misc::checksignalperiod
+// minutes, so that what waits on signals of hosts offline is not stuck.
+func CheckSignals() {
+	period := beego.AppConfig.DefaultInt64("misc::checksignalperiod", 5)
+	ticker := time.NewTicker(
+		time.Duration(period) * time.Minute,
+	)
+	defer ticker.Stop()
+	beego.Debug("CheckSignals() running...")
+	defer beego.Debug("CheckSignals() STOPPED!")
+	for {
+		select {
+		case <-ticker.C:
+			models.ExpireSignals()
+		}
+	}
+}
+
+// SweepOasJobs checks every archive job once, and do what should be done
+// after the job completed.
+func SweepOasJobs() {
+	_, release, err := common.Acquire(context.Background(), "oasjobs")
+	if err == common.ErrorLocked {
+		beego.Info("checkOasJob() is running on other server, skip.")
+		return
+	}
+	if err != nil {
+		beego.Warn("Cannot lock oas jobs:", err)
+		return
+	}
+	defer release()
+
+	reservedays := beego.AppConfig.DefaultInt64("misc::oasjobsreservedays", 7)
+	beego.Info("checkOasJob() start.")
+	oas, err := models.GetOas(&models.Oas{}, 0, 0)
+	if err != nil {
+		beego.Warn("Got error on retrieving OAS records:", err)
+		return
+	}
+	for _, v := range oas {
+		beego.Debug("Got oas:", v)
+		o, err := v.Archive()
+		if err != nil {
+			beego.Warn("Got error on connecting to archive:", err)
+			continue
+		}
+
+		jobCond := &models.OasJobs{
+			Vault: v,
+		}
+		jobs, err := models.GetOasJobs(jobCond, 0, 0)
+		if err != nil {
+			beego.Warn("Got error on retrieving oas jobs:", err)
+			continue
+		}
+
+		for _, job := range jobs {
+			beego.Debug("Got job:", job)
+			switch job.State {
+			case models.OasJobStateRunning:
+				checkOasJob(o, job)
+			case models.OasJobStatePending:
+				if time.Now().Before(job.NextRetry) {
 					continue
 				}
+				resubmitOasJob(o, job)
+			default:
+				duration := time.Now().Sub(job.CreatedTime)
+				if duration > time.Duration(reservedays*24)*time.Hour {
+					models.DeleteOasJobs(job)
+					beego.Info("Oas job record", job.Id, "is out of date, delete.")
+				}
+			}
+		}
+		beego.Info("checkOasJob() completed.")
+	}
+}
 
-				for _, job := range jobs {
-					beego.Debug("Got job:", job)
-					_, jl, err := o.GetJobInfo(
-						job.Vault.VaultId,
-						job.JobId,
-					)
-					if err != nil {
-						beego.Warn("Got error on retrieving job info:", err)
-						continue
-					}
-					if jl.Completed && !job.Status {
-						if jl.StatusCode == "Failed" {
-							beego.Warn("Oas job failed:", jl.StatusMessage)
-							continue
-						}
-						job.Status = jl.Completed
-						err = models.UpdateOasJobs(job)
-						if err != nil {
-							beego.Warn("Got error on update oas jobs:", err)
-							continue
-						}
-						record := job.Records
-						switch job.JobType {
-						case models.OasJobTypePushToOSS:
-							beego.Debug("Job type: Push to OSS")
-							record.BackupTime = time.Now()
-							record.Type = models.RecordTypeBackup
-
-							signal := models.MakeDownloadSignal(
-								job.Records.GetFullPath(),
-								job.Records.BackupSet.Oss.Endpoint,
-								job.Records.BackupSet.Oss.BucketName,
-							)
-							id, err := models.AddSignal(
-								job.Records.Host.Id, signal)
-							if err != nil {
-								beego.Warn("Got error on add signal:", err)
-								continue
-							}
-							err = models.NotifySignal(
-								job.Records.Host.Id, id)
-							if err != nil {
-								beego.Warn("Got error on push signal:", err)
-							}
-							err = models.UpdateRecord(record)
-							if err != nil {
-								beego.Warn(
-									"Cannot update record:", record.Id,
-									"error:", err,
-								)
-							}
-
-						case models.OasJobTypePullFromOSS:
-							beego.Debug("Job type: Pull from OSS")
-
-							record.ArchiveId = jl.ArchiveId
-							record.ArchivedTime = time.Now()
-
-							err = models.UpdateRecord(record)
-							if err != nil {
-								beego.Warn(
-									"Cannot update record:", record.Id,
-									"error:", err,
-								)
-							}
-						}
-
-					} else if job.Status {
-						duration := time.Now().Sub(job.CreatedTime)
-						if duration > time.Duration(reservedays*24)*time.Hour {
-							models.DeleteOasJobs(job)
-							beego.Info("Oas job record", job.Id, "is out of date, delete.")
-						}
-					}
+// checkOasJob finishes job if archive is done with it.
+func checkOasJob(o common.Archive, job *models.OasJobs) {
+	if job.JobType == models.OasJobTypeDeleteArchive {
+		// Deleting is done when submitted, so running one long was cut
+		// short before it was saved as done.
+		if time.Since(job.CreatedTime) > time.Minute {
+			failOasJob(job, "Cut short before done")
+		}
+		return
+	}
+	jl, err := o.GetJob(job.JobId)
+	if err != nil {
+		beego.Warn("Got error on retrieving job info:", err)
+		return
+	}
+	if !jl.Completed {
+		return
+	}
+	if jl.Failed {
+		beego.Warn("Oas job failed:", jl.Message)
+		failOasJob(job, jl.Message)
+		return
+	}
+	job.State = models.OasJobStateSucceeded
+	err = models.UpdateOasJobs(job)
+	if err != nil {
+		beego.Warn("Got error on update oas jobs:", err)
+		return
+	}
+	record := job.Records
+	switch job.JobType {
+	case models.OasJobTypePushToOSS:
+		beego.Debug("Job type: Push to OSS")
+		record.RetrieveTime = time.Now()
+		record.Type = models.RecordTypeBackup
+		updateRestoreJobs(job, models.RestoreStageRetrieving,
+			func(j *models.RestoreJobs) {
+				j.SetStage(models.RestoreStageInOss, "")
+			})
+
+		host := job.Records.Host
+		if job.TargetHost != nil && job.TargetHost.Id != "" {
+			host = job.TargetHost
+		}
+		signal := models.MakeDownloadSignal(
+			job.Records.GetFullPath(),
+			job.Records.BackupSet.Oss,
+			job.Target,
+		)
+		id, err := models.AddSignal(host.Id, signal)
+		if err != nil {
+			beego.Warn("Got error on add signal:", err)
+			failRestoreJobs(job, models.RestoreStageInOss, err.Error())
+			return
+		}
+		updateRestoreJobs(job, models.RestoreStageInOss,
+			func(j *models.RestoreJobs) {
+				j.SignalId = id
+				j.SetStage(models.RestoreStageSignalled, "")
+			})
+		err = models.NotifySignal(host.Id, id)
+		if err != nil {
+			beego.Warn("Got error on push signal:", err)
+		}
+		err = models.UpdateRecord(record, "RetrieveTime", "Type")
+		if err != nil {
+			beego.Warn(
+				"Cannot update record:", record.Id,
+				"error:", err,
+			)
+		}
+
+	case models.OasJobTypePullFromOSS:
+		beego.Debug("Job type: Pull from OSS")
+
+		record.ArchiveId = jl.ArchiveId
+		record.ArchivedTime = time.Now()
+
+		err = models.UpdateRecord(record, "ArchiveId", "ArchivedTime")
+		if err != nil {
+			beego.Warn(
+				"Cannot update record:", record.Id,
+				"error:", err,
+			)
+		}
+
+	case models.OasJobTypeInventoryRetrieval:
+		beego.Debug("Job type: Inventory retrieval")
+		_, err = ReconcileVault(o, job)
+		if err != nil {
+			beego.Warn("Cannot reconcile vault:", job.Vault.Id, "error:", err)
+		}
+	}
+}
+
+// resubmitOasJob submits pending job to archive again.
+func resubmitOasJob(o common.Archive, job *models.OasJobs) {
+	switch job.JobType {
+	case models.OasJobTypeDeleteArchive:
+		job.Attempts++
+		deleteArchive(o, job)
+		return
+	case models.OasJobTypeInventoryRetrieval:
+		job.Attempts++
+		reqId, jobId, err := o.RetrieveInventory(inventoryDesc)
+		if err != nil {
+			failOasJob(job, err.Error())
+			return
+		}
+		job.RequestId, job.JobId = reqId, jobId
+		runOasJob(job)
+		return
+	}
+	record := job.Records
+	if record == nil ||
+		(job.JobType != models.OasJobTypePullFromOSS &&
+			job.JobType != models.OasJobTypePushToOSS) {
+		job.Message = "Cannot be submitted again"
+		giveUpOasJob(job)
+		return
+	}
+	job.Attempts++
+	storage, err := record.BackupSet.Oss.Storage()
+	if err != nil {
+		failOasJob(job, err.Error())
+		return
+	}
+	if job.JobType == models.OasJobTypePullFromOSS {
+		job.RequestId, job.JobId, err = o.ArchiveFrom(
+			storage,
+			record.GetFullPath(),
+			record.GetFullPath(),
+		)
+	} else {
+		job.RequestId, job.JobId, err = o.RecoverTo(
+			record.ArchiveId,
+			storage,
+			record.GetFullPath(),
+			record.GetFullPath(),
+		)
+	}
+	if err != nil {
+		failOasJob(job, err.Error())
+		return
+	}
+	runOasJob(job)
+}
+
+// runOasJob saves job submitted again as running.
+func runOasJob(job *models.OasJobs) {
+	beego.Info("Oas job", job.Id, "is submitted again, attempt", job.Attempts)
+	job.State = models.OasJobStateRunning
+	err := models.UpdateOasJobs(job)
+	if err != nil {
+		beego.Warn("Got error on update oas jobs:", err)
+	}
+}
+
+// deleteArchive deletes archive of job, saving job first if it is new.
+// Record of archive is deleted when it is done, and kept while job is
+// retried. If record is held meanwhile, nothing is deleted and job is
+// cancelled.
+func deleteArchive(o common.Archive, job *models.OasJobs) error {
+	if job.Records != nil {
+		err := models.ReloadHold(job.Records)
+		if err != nil {
+			if job.Id != "" {
+				failOasJob(job, err.Error())
+			}
+			return err
+		}
+		if job.Records.IsHeld() {
+			if job.Id != "" {
+				err = CancelOasJob(job, "hold of record "+job.Records.Id)
+				if err != nil {
+					beego.Warn("Got error on update oas jobs:", err)
 				}
-				beego.Info("checkOasJob() completed.")
 			}
+			return models.ErrorRecordHeld
+		}
+	}
+	if job.Id == "" {
+		job.JobId = job.ArchiveId
+		_, err := models.AddOasJobs(job)
+		if err != nil {
+			return err
+		}
+	}
+	reqId, err := o.DeleteArchive(job.ArchiveId)
+	if err != nil {
+		failOasJob(job, err.Error())
+		return err
+	}
+	job.RequestId = reqId
+	job.State = models.OasJobStateSucceeded
+	job.Message = ""
+	err = models.UpdateOasJobs(job)
+	if err != nil {
+		beego.Warn("Got error on update oas jobs:", err)
+	}
+	if job.Records == nil {
+		return nil
+	}
+	return models.DeleteRecord(job.Records)
+}
+
+// failOasJob has job submitted again later, or gives it up if it is out
+// of attempts.
+func failOasJob(job *models.OasJobs, reason string) {
+	if !job.Retry(reason) {
+		giveUpOasJob(job)
+		return
+	}
+	beego.Info("Oas job", job.Id, "is retried at", job.NextRetry)
+	err := models.UpdateOasJobs(job)
+	if err != nil {
+		beego.Warn("Got error on update oas jobs:", err)
+	}
+}
+
+// giveUpOasJob fails job for good, with restore jobs waiting on it, and
+// raises an alarm.
+func giveUpOasJob(job *models.OasJobs) {
+	job.State = models.OasJobStateFailed
+	err := models.UpdateOasJobs(job)
+	if err != nil {
+		beego.Warn("Got error on update oas jobs:", err)
+	}
+	msg := fmt.Sprintf("Oas job %s failed after %d attempts: %s",
+		job.Id, job.Attempts, job.Message)
+	beego.Error(msg)
+	if job.JobType == models.OasJobTypePushToOSS {
+		failRestoreJobs(job, models.RestoreStageRetrieving,
+			"Archive retrieval failed: "+job.Message)
+	}
+
+	host := ""
+	if job.Records != nil && job.Records.Host != nil {
+		host = job.Records.Host.Name
+		_, err = models.AddFailLog(&models.FailLog{
+			Host: job.Records.Host,
+			Log:  msg,
+		})
+		if err != nil {
+			beego.Warn("Cannot add fail log:", err)
 		}
 	}
+	err = common.Alarm(host, msg)
+	if err != nil {
+		beego.Warn("Cannot run alarm:", err)
+	}
+}
+
+// CancelOasJob stops checking and retrying job, failing restore jobs
+// waiting on it. by is who cancels it.
+func CancelOasJob(job *models.OasJobs, by string) error {
+	if job.IsOver() {
+		return models.ErrorOasJobOver
+	}
+	job.State = models.OasJobStateCancelled
+	job.Message = "Cancelled by " + by
+	err := models.UpdateOasJobs(job)
+	if err != nil {
+		return err
+	}
+	if job.JobType == models.OasJobTypePushToOSS {
+		failRestoreJobs(job, models.RestoreStageRetrieving, job.Message)
+	}
+	return nil
 }
@@ -0,0 +1,149 @@
+/*ModuleAB policies/restore.go -- Restoring app sets to a point in time.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package policies
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+)
+
+// ErrorNothingToRestore is returned when app set has no record old enough.
+var ErrorNothingToRestore = errors.New("No record to restore")
+
+// RestoreAppSet restores each host and path of appSet as it was at time at,
+// by the latest record at or before it. A job failing to start is marked
+// failed, others go on.
+func RestoreAppSet(appSet *models.AppSets, at time.Time, operator string) (*models.Restores, error) {
+	records, err := models.LatestRecords(appSet.Id, at)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrorNothingToRestore
+	}
+	restore := &models.Restores{
+		AppSet:   appSet,
+		At:       at,
+		Operator: operator,
+		Jobs:     make([]*models.RestoreJobs, 0, len(records)),
+	}
+	for _, v := range records {
+		restore.Jobs = append(restore.Jobs, &models.RestoreJobs{
+			Record: v,
+			Host:   v.Host,
+			Target: v.Path.Path,
+		})
+	}
+	_, err = models.AddRestore(restore)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range restore.Jobs {
+		err = StartRestoreJob(v)
+		if err == nil {
+			continue
+		}
+		beego.Warn("Cannot start restore job:", v.Id, "error:", err)
+		v.SetStage(models.RestoreStageFailed, err.Error())
+		err = models.UpdateRestoreJob(v)
+		if err != nil {
+			beego.Warn("Cannot update restore job:", v.Id, "error:", err)
+		}
+	}
+	restores, err := models.GetRestores(&models.Restores{Id: restore.Id}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	return restores[0], nil
+}
+
+// StartRestoreJob has record of job pushed back to OSS if it is archived,
+// SweepOasJobs signals agent when it is done. Otherwise agent is signalled
+// to download it now.
+func StartRestoreJob(j *models.RestoreJobs) error {
+	record := j.Record
+	if record.Type != models.RecordTypeArchive {
+		signal := models.MakeDownloadSignal(
+			record.GetFullPath(),
+			record.BackupSet.Oss,
+			j.Target,
+		)
+		id, err := models.AddSignal(j.Host.Id, signal)
+		if err != nil {
+			return err
+		}
+		j.SignalId = id
+		j.SetStage(models.RestoreStageSignalled, "")
+		err = models.UpdateRestoreJob(j)
+		if err != nil {
+			return err
+		}
+		return models.NotifySignal(j.Host.Id, id)
+	}
+
+	archive, err := record.BackupSet.Oas.Archive()
+	if err != nil {
+		return err
+	}
+	storage, err := record.BackupSet.Oss.Storage()
+	if err != nil {
+		return err
+	}
+	job := &models.OasJobs{
+		JobType:    models.OasJobTypePushToOSS,
+		Vault:      record.BackupSet.Oas,
+		Records:    record,
+		TargetHost: j.Host,
+		Target:     j.Target,
+	}
+	job.RequestId, job.JobId, err = archive.RecoverTo(
+		record.ArchiveId,
+		storage,
+		record.GetFullPath(),
+		record.GetFullPath(),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = models.AddOasJobs(job)
+	if err != nil {
+		return err
+	}
+	j.OasJob = job
+	j.SetStage(models.RestoreStageRetrieving, "")
+	return models.UpdateRestoreJob(j)
+}
+
+// updateRestoreJobs calls f on restore jobs of oas job at stage, and saves
+// them.
+func updateRestoreJobs(job *models.OasJobs, stage int, f func(*models.RestoreJobs)) {
+	jobs, err := models.GetRestoreJobs(&models.RestoreJobs{
+		OasJob: job,
+		Stage:  stage,
+	}, 0, 0)
+	if err != nil {
+		beego.Warn("Cannot get restore jobs of oas job:", job.Id, "error:", err)
+		return
+	}
+	for _, v := range jobs {
+		f(v)
+		err = models.UpdateRestoreJob(v)
+		if err != nil {
+			beego.Warn("Cannot update restore job:", v.Id, "error:", err)
+		}
+	}
+}
+
+// failRestoreJobs fails restore jobs of oas job at stage for reason.
+func failRestoreJobs(job *models.OasJobs, stage int, reason string) {
+	updateRestoreJobs(job, stage, func(j *models.RestoreJobs) {
+		j.SetStage(models.RestoreStageFailed, reason)
+	})
+}
@@ -0,0 +1,146 @@
+/*ModuleAB policies/restore_tests.go -- Testing backups can be restored.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package policies
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+)
+
+var (
+	ErrorNoTestHost    = errors.New("No restore test host")
+	ErrorNothingToTest = errors.New("No record to test")
+)
+
+// CheckRestoreTests tests backups of every backup set each
+// misc::restoretestperiod hours, 0 means never.
+func CheckRestoreTests() {
+	period := beego.AppConfig.DefaultInt64("misc::restoretestperiod", 24)
+	if period <= 0 {
+		beego.Info("Restore testing is disabled.")
+		return
+	}
+	beego.Debug("CheckRestoreTests() running...")
+	defer beego.Debug("CheckRestoreTests() STOPPED!")
+	runPeriodically("restore tests", time.Duration(period)*time.Hour,
+		models.LastRestoreTestTime, RunRestoreTests)
+}
+
+// RunRestoreTests tests backups of every backup set on host named by
+// misc::restoretesthost.
+func RunRestoreTests() {
+	_, release, err := common.Acquire(context.Background(), "restoretest")
+	if err == common.ErrorLocked {
+		beego.Info("Restore tests are run by other server, skip.")
+		return
+	}
+	if err != nil {
+		beego.Warn("Cannot lock restore tests:", err)
+		return
+	}
+	defer release()
+
+	host, err := RestoreTestHost("")
+	if err != nil {
+		beego.Warn("Cannot get restore test host:", err)
+		return
+	}
+	backupSets, err := models.GetBackupSets(&models.BackupSets{}, 0, 0)
+	if err != nil {
+		beego.Warn("Got error on retrieving backup sets:", err)
+		return
+	}
+	for _, v := range backupSets {
+		_, err = RunRestoreTest(v, host)
+		switch err {
+		case nil:
+		case ErrorNothingToTest:
+			beego.Debug("Backup set", v.Name, "has nothing to test, skip.")
+		default:
+			beego.Warn("Cannot test backup set:", v.Name, "error:", err)
+		}
+	}
+}
+
+// RestoreTestHost gets host by name, or by misc::restoretesthost if name
+// is empty. ErrorNoTestHost is returned if there is no such host.
+func RestoreTestHost(name string) (*models.Hosts, error) {
+	if name == "" {
+		name = beego.AppConfig.String("misc::restoretesthost")
+	}
+	if name == "" {
+		return nil, ErrorNoTestHost
+	}
+	hosts, err := models.GetHosts(&models.Hosts{Name: name}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, ErrorNoTestHost
+	}
+	return hosts[0], nil
+}
+
+// RunRestoreTest picks misc::restoretestcount records of backupSet in OSS
+// at random, and signals host to download and verify each. Tests are
+// passed or failed as the signals are acked or failed.
+func RunRestoreTest(backupSet *models.BackupSets, host *models.Hosts) ([]*models.RestoreTests, error) {
+	records, err := models.BackupSetRecords(backupSet.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrorNothingToTest
+	}
+	count := beego.AppConfig.DefaultInt("misc::restoretestcount", 1)
+	if count > len(records) {
+		count = len(records)
+	}
+	tests := make([]*models.RestoreTests, 0, count)
+	for _, i := range rand.Perm(len(records))[:count] {
+		test := &models.RestoreTests{
+			BackupSet: backupSet,
+			Record:    records[i],
+			Host:      host,
+		}
+		_, err = models.AddRestoreTest(test)
+		if err != nil {
+			return tests, err
+		}
+		tests = append(tests, test)
+		err = startRestoreTest(test)
+		if err == nil {
+			continue
+		}
+		beego.Warn("Cannot start restore test:", test.Id, "error:", err)
+		test.SetResult(models.RestoreTestFailed, err.Error())
+		err = models.UpdateRestoreTest(test)
+		if err != nil {
+			beego.Warn("Cannot update restore test:", test.Id, "error:", err)
+		}
+	}
+	return tests, nil
+}
+
+func startRestoreTest(t *models.RestoreTests) error {
+	id, err := models.AddSignal(t.Host.Id, models.MakeVerifySignal(t.Record))
+	if err != nil {
+		return err
+	}
+	t.SignalId = id
+	err = models.UpdateRestoreTest(t)
+	if err != nil {
+		return err
+	}
+	return models.NotifySignal(t.Host.Id, id)
+}
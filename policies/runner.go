@@ -0,0 +1,271 @@
+/*ModuleAB policies/runner.go -- Carrying out decisions of a policy.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package policies
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+)
+
+var (
+	running     = make(map[string]context.CancelFunc) // policy id -> cancel
+	runningLock = new(sync.Mutex)
+)
+
+func setRunning(id string, cancel context.CancelFunc) {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	if cancel == nil {
+		delete(running, id)
+		return
+	}
+	running[id] = cancel
+}
+
+// Cancel stops policy run of id on this server, records being worked on
+// are finished first. It returns false if the policy is not running here.
+func Cancel(id string) bool {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	cancel, ok := running[id]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// Workers is how many groups of records are worked on at once,
+// misc::policyworkers.
+func Workers() int {
+	n := beego.AppConfig.DefaultInt("misc::policyworkers", 4)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// runner carries out decisions of one policy run by a pool of workers.
+// Each group goes to one worker, so records of a host and path are still
+// done in order. Clients are made once per storage and archive, requests
+// to an endpoint are limited by common.EndpointLimiter.
+type runner struct {
+	ctx context.Context
+
+	lock sync.Mutex // Guards run and clients
+	run  *models.PolicyRuns
+
+	storages map[string]common.Storage // Oss id -> client
+	archives map[string]common.Archive // Oas id -> client
+}
+
+func newRunner(ctx context.Context, p *models.Policies) *runner {
+	return &runner{
+		ctx:      ctx,
+		run:      &models.PolicyRuns{Policy: p},
+		storages: make(map[string]common.Storage),
+		archives: make(map[string]common.Archive),
+	}
+}
+
+// Run works on groups till all done or ctx is done.
+func (r *runner) Run(groups [][]*Decision) {
+	ch := make(chan []*Decision)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < Workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for g := range ch {
+				r.runGroup(g)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(ch)
+	for _, g := range groups {
+		select {
+		case <-r.ctx.Done():
+			return
+		case ch <- g:
+		}
+	}
+}
+
+func (r *runner) runGroup(decisions []*Decision) {
+	for _, d := range decisions {
+		if r.ctx.Err() != nil {
+			return
+		}
+		beego.Debug("Record", d.Record.Id, d.Action+":", d.Reason)
+		var err error
+		switch d.Action {
+		case DecisionArchive:
+			err = r.archiveRecord(d.Record)
+		case DecisionDelete:
+			err = r.deleteRecord(d.Record)
+		}
+
+		r.lock.Lock()
+		switch {
+		case err != nil:
+			beego.Warn(
+				"Cannot", d.Action, "record:", d.Record.Id,
+				"error:", err,
+			)
+			r.run.AddLog(d.Record.Id, d.Action, err)
+		case d.Action == DecisionArchive:
+			r.run.Archived++
+		case d.Action == DecisionDelete:
+			r.run.Deleted++
+		default:
+			r.run.Skipped++
+		}
+		r.lock.Unlock()
+	}
+}
+
+func (r *runner) storage(oss *models.Oss) (common.Storage, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s, ok := r.storages[oss.Id]
+	if ok {
+		return s, nil
+	}
+	s, err := oss.Storage()
+	if err != nil {
+		return nil, err
+	}
+	r.storages[oss.Id] = s
+	return s, nil
+}
+
+func (r *runner) archive(oas *models.Oas) (common.Archive, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	a, ok := r.archives[oas.Id]
+	if ok {
+		return a, nil
+	}
+	a, err := oas.Archive()
+	if err != nil {
+		return nil, err
+	}
+	r.archives[oas.Id] = a
+	return a, nil
+}
+
+// archiveRecord makes a job to archive backup of rec.
+func (r *runner) archiveRecord(rec *models.Records) error {
+	archive, err := r.archive(rec.BackupSet.Oas)
+	if err != nil {
+		return err
+	}
+	storage, err := r.storage(rec.BackupSet.Oss)
+	if err != nil {
+		return err
+	}
+	err = common.EndpointLimiter(rec.BackupSet.Oas.Endpoint).Wait(r.ctx)
+	if err != nil {
+		return err
+	}
+	beego.Debug(
+		"Archive:",
+		rec.BackupSet.Oas.VaultId,
+		storage.BucketName(),
+		rec.GetFullPath(),
+	)
+	reqId, jobId, err := archive.ArchiveFrom(
+		storage,
+		rec.GetFullPath(),
+		rec.GetFullPath(),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = models.AddOasJobs(
+		&models.OasJobs{
+			Vault:     rec.BackupSet.Oas,
+			RequestId: reqId,
+			JobId:     jobId,
+			JobType:   models.OasJobTypePullFromOSS,
+			Records:   rec,
+		},
+	)
+	return err
+}
+
+// deleteRecord deletes backup or archive of rec. Backup with archive is
+// converted to archive record instead of deleted, held record is refused.
+func (r *runner) deleteRecord(rec *models.Records) error {
+	if rec.IsHeld() {
+		return models.ErrorRecordHeld
+	}
+	switch rec.Type {
+	case models.RecordTypeBackup:
+		storage, err := r.storage(rec.BackupSet.Oss)
+		if err != nil {
+			return err
+		}
+		err = common.EndpointLimiter(rec.BackupSet.Oss.Endpoint).Wait(r.ctx)
+		if err != nil {
+			return err
+		}
+		// Record may be held while it waits.
+		err = models.ReloadHold(rec)
+		if err != nil {
+			return err
+		}
+		if rec.IsHeld() {
+			return models.ErrorRecordHeld
+		}
+		err = storage.DeleteObject(rec.GetFullPath())
+		if err != nil {
+			return err
+		}
+		if rec.ArchiveId == "" {
+			return models.DeleteRecord(rec)
+		}
+		rec.Type = models.RecordTypeArchive
+		return models.UpdateRecord(rec, "Type")
+
+	case models.RecordTypeArchive:
+		archive, err := r.archive(rec.BackupSet.Oas)
+		if err != nil {
+			return err
+		}
+		err = common.EndpointLimiter(rec.BackupSet.Oas.Endpoint).Wait(r.ctx)
+		if err != nil {
+			return err
+		}
+		jobs, err := models.GetOasJobs(&models.OasJobs{
+			Vault:     rec.BackupSet.Oas,
+			JobType:   models.OasJobTypeDeleteArchive,
+			ArchiveId: rec.ArchiveId,
+		}, 0, 0)
+		if err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			if !j.IsOver() {
+				return fmt.Errorf("Archive deletion is pending: job %s", j.Id)
+			}
+		}
+		beego.Debug("Will delete archive:", rec.Id)
+		return deleteArchive(archive, &models.OasJobs{
+			Vault:     rec.BackupSet.Oas,
+			JobType:   models.OasJobTypeDeleteArchive,
+			ArchiveId: rec.ArchiveId,
+			Records:   rec,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+/*ModuleAB policies/scheduler.go -- Running policies on their own schedule.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package policies
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+	"github.com/robfig/cron"
+)
+
+// scheduled is a policy's running cron, with what it was made from, so
+// Reload knows whether it should be remade.
+type scheduled struct {
+	schedule string
+	timeZone string
+	cron     *cron.Cron
+}
+
+// Topic servers are told to reload schedule by.
+const scheduleTopic = "schedule"
+
+var (
+	schedules    = make(map[string]*scheduled) // policy id -> cron
+	scheduleLock = new(sync.Mutex)
+)
+
+// StartScheduler starts every enabled policy on its own schedule, and
+// reloads as ReloadAll tells.
+func StartScheduler() {
+	reloads, _ := common.DefaultHub.Subscribe(scheduleTopic)
+	err := Reload()
+	if err != nil {
+		beego.Warn("Policy may not be executed for error:", err)
+	}
+	go func() {
+		for range reloads {
+			err := Reload()
+			if err != nil {
+				beego.Warn("Cannot reload schedule:", err)
+			}
+		}
+	}()
+}
+
+// ReloadAll has every server Reload, it should be called after a policy
+// is added, updated or deleted. This server reloads at once if others
+// cannot be told.
+func ReloadAll() error {
+	err := common.DefaultHub.Publish(scheduleTopic, "reload")
+	if err == nil {
+		return nil
+	}
+	beego.Warn("Cannot tell servers to reload schedule:", err)
+	return Reload()
+}
+
+// Reload syncs crons of this server with policies in database. Crons of
+// policies not changed are kept, so their timers are not reset. Runs of
+// policies deleted or disabled are cancelled.
+func Reload() error {
+	policies, err := models.GetPolicies(&models.Policies{}, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+	seen := make(map[string]bool)
+	for _, p := range policies {
+		if !p.Enabled {
+			continue
+		}
+		seen[p.Id] = true
+		spec := p.GetSchedule()
+		s, ok := schedules[p.Id]
+		if ok && s.schedule == spec && s.timeZone == p.TimeZone {
+			continue
+		}
+		if ok {
+			s.cron.Stop()
+			delete(schedules, p.Id)
+		}
+
+		c, err := newPolicyCron(p, spec)
+		if err != nil {
+			beego.Warn("Policy", p.Name, "may not be executed for error:", err)
+			continue
+		}
+		c.Start()
+		schedules[p.Id] = &scheduled{
+			schedule: spec,
+			timeZone: p.TimeZone,
+			cron:     c,
+		}
+		beego.Info("Policy", p.Name, "scheduled:", spec, p.TimeZone)
+	}
+
+	for id, s := range schedules {
+		if !seen[id] {
+			s.cron.Stop()
+			delete(schedules, id)
+			Cancel(id)
+			beego.Info("Policy id", id, "unscheduled.")
+		}
+	}
+	return nil
+}
+
+func newPolicyCron(p *models.Policies, spec string) (*cron.Cron, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("Empty schedule")
+	}
+	loc, err := p.GetLocation()
+	if err != nil {
+		return nil, err
+	}
+	c := cron.NewWithLocation(loc)
+	id := p.Id
+	err = c.AddFunc(spec, func() {
+		// Cron has moved entry on before running it.
+		e := c.Entries()[0]
+		runScheduled(id, e.Prev, e.Next)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// runScheduled runs policy for slot, unless other server has run it for
+// the slot. Slot is claimed till next, so a server whose clock is behind
+// cannot run it again after the run is over. Policy is loaded again, it
+// may be changed since scheduled.
+func runScheduled(id string, slot, next time.Time) {
+	ttl := next.Sub(slot)
+	if ttl < common.LockTTL() {
+		ttl = common.LockTTL()
+	}
+	err := common.Claim(fmt.Sprintf("policy:%s:%d", id, slot.Unix()), ttl)
+	if err == common.ErrorLocked {
+		beego.Info("Policy id", id, "is run on other server at",
+			slot, "skip.")
+		return
+	}
+	if err != nil {
+		beego.Warn("Cannot claim policy:", id, "error:", err)
+		return
+	}
+
+	policies, err := models.GetPolicies(&models.Policies{Id: id}, 0, 0)
+	if err != nil {
+		beego.Warn("Run policy error:", err)
+		return
+	}
+	if len(policies) == 0 || !policies[0].Enabled {
+		beego.Debug("Policy id", id, "is gone or disabled, skip.")
+		return
+	}
+	RunPolicy(policies[0])
+}
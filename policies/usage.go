@@ -0,0 +1,45 @@
+/*ModuleAB policies/usage.go -- Daily snapshots of storage usage.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package policies
+
+import (
+	"context"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+)
+
+// CheckUsage takes snapshot of usage once a day, at start if there is none
+// of today yet, and as day begins since.
+func CheckUsage() {
+	beego.Debug("CheckUsage() running...")
+	defer beego.Debug("CheckUsage() STOPPED!")
+	runPeriodically("usage", 24*time.Hour, models.LastUsageDate, SnapshotUsage)
+}
+
+// SnapshotUsage saves usage of today by every dimension and tier.
+func SnapshotUsage() {
+	_, release, err := common.Acquire(context.Background(), "usage")
+	if err == common.ErrorLocked {
+		beego.Info("Usage is taken by other server, skip.")
+		return
+	}
+	if err != nil {
+		beego.Warn("Cannot lock usage:", err)
+		return
+	}
+	defer release()
+
+	n, err := models.SnapshotUsage()
+	if err != nil {
+		beego.Warn("Cannot take snapshot of usage:", err)
+		return
+	}
+	beego.Info("Usage snapshot taken:", n, "rows.")
+}
@@ -0,0 +1,171 @@
+/*ModuleAB policies/verify.go -- Verifying backups in storage.
+ * Copyright (C) 2016 TonyChyi <tonychee1989@gmail.com>
+ * License: GPL v3 or later.
+ */
+
+package policies
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+)
+
+// CheckRecords verifies backups in every bucket each misc::verifyperiod
+// hours, 0 means never.
+func CheckRecords() {
+	period := beego.AppConfig.DefaultInt64("misc::verifyperiod", 24)
+	if period <= 0 {
+		beego.Info("Backup verification is disabled.")
+		return
+	}
+	beego.Debug("CheckRecords() running...")
+	defer beego.Debug("CheckRecords() STOPPED!")
+	runPeriodically("verify", time.Duration(period)*time.Hour,
+		models.LastVerifiedTime, VerifyRecords)
+}
+
+// VerifyRecords verifies every backup record bucket by bucket, alarm is
+// raised for bucket having bad backups.
+func VerifyRecords() {
+	ctx, release, err := common.Acquire(context.Background(), "verify")
+	if err == common.ErrorLocked {
+		beego.Info("Backups are verified by other server, skip.")
+		return
+	}
+	if err != nil {
+		beego.Warn("Cannot lock verification:", err)
+		return
+	}
+	defer release()
+
+	oss, err := models.GetOss(&models.Oss{}, 0, 0)
+	if err != nil {
+		beego.Warn("Got error on retrieving OSS records:", err)
+		return
+	}
+	for _, v := range oss {
+		err = verifyBucket(ctx, v)
+		if err != nil {
+			beego.Warn("Cannot verify backups in bucket:", v.BucketName,
+				"error:", err)
+		}
+	}
+}
+
+func verifyBucket(ctx context.Context, oss *models.Oss) error {
+	s, err := oss.Storage()
+	if err != nil {
+		return err
+	}
+	records, err := models.StoredRecords(oss.Id)
+	if err != nil {
+		return err
+	}
+	var mismatch, missing int
+	for _, v := range records {
+		err = common.EndpointLimiter(oss.Endpoint).Wait(ctx)
+		if err != nil {
+			return err
+		}
+		err = VerifyRecord(s, v)
+		if err != nil {
+			beego.Warn("Cannot verify record:", v.Id, "error:", err)
+			continue
+		}
+		switch v.VerifyState {
+		case models.RecordVerifyMismatch:
+			mismatch++
+		case models.RecordVerifyMissing:
+			missing++
+		}
+	}
+	beego.Info("Bucket", oss.BucketName, "verified:", len(records), "backups,",
+		mismatch, "mismatch,", missing, "missing.")
+	if mismatch+missing != 0 {
+		msg := fmt.Sprintf(
+			"Bucket %s has %d backups not as recorded and %d missing",
+			oss.BucketName, mismatch, missing,
+		)
+		beego.Warn(msg)
+		err = common.Alarm("", msg)
+		if err != nil {
+			beego.Warn("Cannot run alarm:", err)
+		}
+	}
+	return nil
+}
+
+// Fields VerifyRecord saves, record may be held or changed meanwhile.
+var (
+	verifyColumns = []string{
+		"Size", "Md5", "Crc64", "ETag", "StorageClass",
+		"VerifyState", "VerifyMessage", "VerifiedTime",
+	}
+	verifyMissingColumns = []string{
+		"VerifyState", "VerifyMessage", "VerifiedTime",
+	}
+)
+
+// VerifyRecord compares object of rec in s with what is recorded, and saves
+// result in rec. What record does not know yet is taken from storage, what
+// storage cannot tell is not compared. SHA-256 is given only by agent, and
+// cannot be compared without downloading.
+func VerifyRecord(s common.Storage, rec *models.Records) error {
+	info, err := s.StatObject(rec.GetFullPath())
+	switch err {
+	case nil:
+	case common.ErrorObjectNotFound:
+		rec.VerifyState = models.RecordVerifyMissing
+		rec.VerifyMessage = "Object is not found"
+		rec.VerifiedTime = time.Now()
+		return models.UpdateRecord(rec, verifyMissingColumns...)
+	default:
+		return err
+	}
+
+	bad := make([]string, 0)
+	if rec.Size == 0 {
+		rec.Size = info.Size
+	} else if rec.Size != info.Size {
+		bad = append(bad, fmt.Sprintf("size %d, not %d", info.Size, rec.Size))
+	}
+	for _, v := range []struct {
+		name     string
+		recorded *string
+		stored   string
+	}{
+		{"md5", &rec.Md5, info.Md5},
+		{"crc64", &rec.Crc64, info.Crc64},
+		{"etag", &rec.ETag, info.ETag},
+	} {
+		switch {
+		case v.stored == "":
+		case *v.recorded == "":
+			*v.recorded = v.stored
+		case !strings.EqualFold(*v.recorded, v.stored):
+			bad = append(bad, fmt.Sprintf("%s %s, not %s",
+				v.name, v.stored, *v.recorded))
+		}
+	}
+	rec.StorageClass = info.StorageClass
+
+	if len(bad) != 0 {
+		rec.VerifyState = models.RecordVerifyMismatch
+		rec.VerifyMessage = strings.Join(bad, "; ")
+		if len(rec.VerifyMessage) > 255 {
+			rec.VerifyMessage = rec.VerifyMessage[:255]
+		}
+	} else {
+		rec.VerifyState = models.RecordVerifyOk
+		rec.VerifyMessage = ""
+	}
+	rec.VerifiedTime = time.Now()
+	return models.UpdateRecord(rec, verifyColumns...)
+}
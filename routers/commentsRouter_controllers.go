@@ -47,6 +47,30 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:AppSetsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:AppSetsController"],
+		beego.ControllerComments{
+			Method: "Restore",
+			Router: `/:name/restore`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:AppSetsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:AppSetsController"],
+		beego.ControllerComments{
+			Method: "GetRestores",
+			Router: `/:name/restore`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:AppSetsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:AppSetsController"],
+		beego.ControllerComments{
+			Method: "GetRestore",
+			Router: `/:name/restore/:id`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:BackupSetsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:BackupSetsController"],
 		beego.ControllerComments{
 			Method: "Post",
@@ -103,6 +127,14 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ClientController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ClientController"],
+		beego.ControllerComments{
+			Method: "GetSignalHistory",
+			Router: `/signal/:name/history`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ClientController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ClientController"],
 		beego.ControllerComments{
 			Method: "GetSignals",
@@ -247,6 +279,22 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:HostsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:HostsController"],
+		beego.ControllerComments{
+			Method: "Backup",
+			Router: `/:name/backup`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:HostsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:HostsController"],
+		beego.ControllerComments{
+			Method: "GetBackup",
+			Router: `/:name/backup/:id`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:LoginController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:LoginController"],
 		beego.ControllerComments{
 			Method: "Login",
@@ -311,6 +359,14 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:OasController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:OasController"],
+		beego.ControllerComments{
+			Method: "Inventory",
+			Router: `/:name/inventory`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:OasJobsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:OasJobsController"],
 		beego.ControllerComments{
 			Method: "Get",
@@ -327,6 +383,14 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:OasJobsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:OasJobsController"],
+		beego.ControllerComments{
+			Method: "Cancel",
+			Router: `/:job_id/cancel`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:OssController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:OssController"],
 		beego.ControllerComments{
 			Method: "Post",
@@ -367,6 +431,14 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:OssController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:OssController"],
+		beego.ControllerComments{
+			Method: "Reconcile",
+			Router: `/:name/reconcile`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:PathsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:PathsController"],
 		beego.ControllerComments{
 			Method: "Post",
@@ -447,6 +519,46 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:PolicyController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:PolicyController"],
+		beego.ControllerComments{
+			Method: "Preview",
+			Router: `/:name/preview`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:PolicyController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:PolicyController"],
+		beego.ControllerComments{
+			Method: "Runs",
+			Router: `/:name/runs`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:PolicyController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:PolicyController"],
+		beego.ControllerComments{
+			Method: "Cancel",
+			Router: `/:name/cancel`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ReconciliationsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ReconciliationsController"],
+		beego.ControllerComments{
+			Method: "GetAll",
+			Router: `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ReconciliationsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ReconciliationsController"],
+		beego.ControllerComments{
+			Method: "Get",
+			Router: `/:id`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RecordsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RecordsController"],
 		beego.ControllerComments{
 			Method: "Post",
@@ -479,6 +591,94 @@ func init() {
 			MethodParams: param.Make(),
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RecordsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RecordsController"],
+		beego.ControllerComments{
+			Method: "Hold",
+			Router: `/:id/hold`,
+			AllowHTTPMethods: []string{"put"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RecordsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RecordsController"],
+		beego.ControllerComments{
+			Method: "Release",
+			Router: `/:id/hold`,
+			AllowHTTPMethods: []string{"delete"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ReportsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ReportsController"],
+		beego.ControllerComments{
+			Method: "Usage",
+			Router: `/usage`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ReportsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:ReportsController"],
+		beego.ControllerComments{
+			Method: "UsageHistory",
+			Router: `/usage/history`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreJobsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreJobsController"],
+		beego.ControllerComments{
+			Method: "GetAll",
+			Router: `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreJobsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreJobsController"],
+		beego.ControllerComments{
+			Method: "Get",
+			Router: `/:id`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreJobsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreJobsController"],
+		beego.ControllerComments{
+			Method: "WebSocket",
+			Router: `/ws`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreTestsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreTestsController"],
+		beego.ControllerComments{
+			Method: "Post",
+			Router: `/`,
+			AllowHTTPMethods: []string{"post"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreTestsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreTestsController"],
+		beego.ControllerComments{
+			Method: "GetAll",
+			Router: `/`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreTestsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreTestsController"],
+		beego.ControllerComments{
+			Method: "Summary",
+			Router: `/summary`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreTestsController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RestoreTestsController"],
+		beego.ControllerComments{
+			Method: "Get",
+			Router: `/:id`,
+			AllowHTTPMethods: []string{"get"},
+			MethodParams: param.Make(),
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RolesController"] = append(beego.GlobalControllerRouter["github.com/ModuleAB/ModuleAB/server/controllers:RolesController"],
 		beego.ControllerComments{
 			Method: "GetAll",
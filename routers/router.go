@@ -91,11 +91,31 @@ func init() {
 				&controllers.PolicyController{},
 			),
 		),
+		beego.NSNamespace("/reconciliations",
+			beego.NSInclude(
+				&controllers.ReconciliationsController{},
+			),
+		),
 		beego.NSNamespace("/records",
 			beego.NSInclude(
 				&controllers.RecordsController{},
 			),
 		),
+		beego.NSNamespace("/reports",
+			beego.NSInclude(
+				&controllers.ReportsController{},
+			),
+		),
+		beego.NSNamespace("/restoreJobs",
+			beego.NSInclude(
+				&controllers.RestoreJobsController{},
+			),
+		),
+		beego.NSNamespace("/restoreTests",
+			beego.NSInclude(
+				&controllers.RestoreTestsController{},
+			),
+		),
 		beego.NSNamespace("/auth",
 			beego.NSInclude(
 				&controllers.LoginController{},
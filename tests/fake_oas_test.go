@@ -0,0 +1,299 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeOasJob is a job kept by fakeOas.
+type fakeOasJob struct {
+	Action        string `json:"Action"`
+	ArchiveId     string `json:"ArchiveId"`
+	Completed     bool   `json:"Completed"`
+	JobId         string `json:"JobId"`
+	StatusCode    string `json:"StatusCode"`
+	StatusMessage string `json:"StatusMessage"`
+
+	vaultId string
+	bucket  string
+	object  string
+	desc    string
+	output  []byte // Inventory, when completed
+}
+
+// fakeOas is an in-process OAS vault and job API. Jobs stay InProgress
+// until Complete or Fail is called, pull-from-oss and push-to-oss jobs
+// copy data from and to oss when completed, inventory-retrieval jobs list
+// archives then.
+type fakeOas struct {
+	*httptest.Server
+	oss *fakeOss
+
+	lock     sync.Mutex
+	seq      int
+	vaults   map[string]string            // name -> id
+	archives map[string]map[string][]byte // vault id -> archive id -> data
+	descs    map[string]string            // archive id -> description
+	jobs     map[string]*fakeOasJob
+	jobLimit int           // Jobs accepted before refusing, 0 means no limit
+	delay    time.Duration // Slept before every response
+}
+
+func newFakeOas(oss *fakeOss) *fakeOas {
+	f := &fakeOas{
+		oss:      oss,
+		vaults:   make(map[string]string),
+		archives: make(map[string]map[string][]byte),
+		descs:    make(map[string]string),
+		jobs:     make(map[string]*fakeOasJob),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// Addr returns host and port, OAS client takes them apart.
+func (f *fakeOas) Addr() (string, string) {
+	u, _ := url.Parse(f.URL)
+	s := strings.SplitN(u.Host, ":", 2)
+	return s[0], s[1]
+}
+
+func (f *fakeOas) nextId(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s%08d", prefix, f.seq)
+}
+
+// AddVault makes a vault, returns its id.
+func (f *fakeOas) AddVault(name string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id := f.nextId("vault")
+	f.vaults[name] = id
+	f.archives[id] = make(map[string][]byte)
+	return id
+}
+
+// Jobs returns a copy of every job, in no order.
+func (f *fakeOas) Jobs() []*fakeOasJob {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	r := make([]*fakeOasJob, 0)
+	for _, v := range f.jobs {
+		j := *v
+		r = append(r, &j)
+	}
+	return r
+}
+
+// Archive returns data of an archive, or nil.
+func (f *fakeOas) Archive(vaultId, archiveId string) []byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.archives[vaultId][archiveId]
+}
+
+// PutArchive puts data into vault as if archived, returns archive id.
+func (f *fakeOas) PutArchive(vaultId, desc string, data []byte) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id := f.nextId("archive")
+	f.archives[vaultId][id] = data
+	f.descs[id] = desc
+	return id
+}
+
+// FailJobsAfter makes new jobs refused after n (n > 0) more are accepted.
+func (f *fakeOas) FailJobsAfter(n int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.jobLimit = len(f.jobs) + n
+}
+
+// SetDelay makes every request take d longer.
+func (f *fakeOas) SetDelay(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.delay = d
+}
+
+// Complete finishes a job successfully.
+func (f *fakeOas) Complete(jobId string) error {
+	f.lock.Lock()
+	j, ok := f.jobs[jobId]
+	f.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("No such job: %s", jobId)
+	}
+
+	switch j.Action {
+	case "PullFromOSS":
+		data := f.oss.Get(j.bucket, j.object)
+		if data == nil {
+			return f.Fail(jobId, "Object not found in OSS")
+		}
+		f.lock.Lock()
+		j.ArchiveId = f.nextId("archive")
+		f.archives[j.vaultId][j.ArchiveId] = data
+		f.descs[j.ArchiveId] = j.desc
+		f.lock.Unlock()
+	case "PushToOSS":
+		data := f.Archive(j.vaultId, j.ArchiveId)
+		if data == nil {
+			return f.Fail(jobId, "Archive not found")
+		}
+		f.oss.Put(j.bucket, j.object, data)
+	case "InventoryRetrieval":
+		f.lock.Lock()
+		l := make([]map[string]interface{}, 0)
+		for id, data := range f.archives[j.vaultId] {
+			l = append(l, map[string]interface{}{
+				"ArchiveId":          id,
+				"ArchiveDescription": f.descs[id],
+				"CreationDate":       time.Now().UTC().Format(time.RFC3339),
+				"Size":               len(data),
+			})
+		}
+		j.output, _ = json.Marshal(map[string]interface{}{
+			"VaultId":       j.vaultId,
+			"InventoryDate": time.Now().UTC().Format(time.RFC3339),
+			"ArchiveList":   l,
+		})
+		f.lock.Unlock()
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	j.Completed = true
+	j.StatusCode = "Succeeded"
+	return nil
+}
+
+// Fail finishes a job with failure.
+func (f *fakeOas) Fail(jobId, message string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	j, ok := f.jobs[jobId]
+	if !ok {
+		return fmt.Errorf("No such job: %s", jobId)
+	}
+	j.Completed = true
+	j.StatusCode = "Failed"
+	j.StatusMessage = message
+	return nil
+}
+
+func (f *fakeOas) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"code":    code,
+		"message": code,
+	})
+}
+
+func (f *fakeOas) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	delay := f.delay
+	f.lock.Unlock()
+	time.Sleep(delay)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	w.Header().Set("x-oas-request-id", f.nextId("request"))
+
+	// /vaults, /vaults/:id/jobs, /vaults/:id/jobs/:job,
+	// /vaults/:id/jobs/:job/output, /vaults/:id/archives/:archive
+	s := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(s) == 1 && s[0] == "vaults" && r.Method == "GET":
+		l := make([]map[string]string, 0)
+		for name, id := range f.vaults {
+			l = append(l, map[string]string{
+				"VaultName": name,
+				"VaultId":   id,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Marker":    "",
+			"VaultList": l,
+		})
+
+	case len(s) == 3 && s[2] == "jobs" && r.Method == "POST":
+		if _, ok := f.archives[s[1]]; !ok {
+			f.writeError(w, http.StatusNotFound, "VaultNotExist")
+			return
+		}
+		if f.jobLimit > 0 && len(f.jobs) >= f.jobLimit {
+			f.writeError(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		var req map[string]string
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			f.writeError(w, http.StatusBadRequest, "InvalidParameter")
+			return
+		}
+		j := &fakeOasJob{
+			JobId:      f.nextId("job"),
+			ArchiveId:  req["ArchiveId"],
+			StatusCode: "InProgress",
+			vaultId:    s[1],
+			bucket:     req["OSSBucket"],
+			object:     req["OSSObject"],
+			desc:       req["Description"],
+		}
+		switch req["Type"] {
+		case "pull-from-oss":
+			j.Action = "PullFromOSS"
+		case "push-to-oss":
+			j.Action = "PushToOSS"
+		case "inventory-retrieval":
+			j.Action = "InventoryRetrieval"
+		default:
+			f.writeError(w, http.StatusBadRequest, "InvalidParameter")
+			return
+		}
+		f.jobs[j.JobId] = j
+		w.Header().Set("x-oas-job-id", j.JobId)
+		w.WriteHeader(http.StatusAccepted)
+
+	case len(s) == 4 && s[2] == "jobs" && r.Method == "GET":
+		j, ok := f.jobs[s[3]]
+		if !ok || j.vaultId != s[1] {
+			f.writeError(w, http.StatusNotFound, "JobNotExist")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(j)
+
+	case len(s) == 5 && s[2] == "jobs" && s[4] == "output" && r.Method == "GET":
+		j, ok := f.jobs[s[3]]
+		if !ok || j.vaultId != s[1] {
+			f.writeError(w, http.StatusNotFound, "JobNotExist")
+			return
+		}
+		if j.output == nil {
+			f.writeError(w, http.StatusConflict, "JobNotReady")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(j.output)
+
+	case len(s) == 4 && s[2] == "archives" && r.Method == "DELETE":
+		if _, ok := f.archives[s[1]][s[3]]; !ok {
+			f.writeError(w, http.StatusNotFound, "ArchiveNotExist")
+			return
+		}
+		delete(f.archives[s[1]], s[3])
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		f.writeError(w, http.StatusNotFound, "NotFound")
+	}
+}
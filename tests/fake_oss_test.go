@@ -0,0 +1,235 @@
+package test
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeObject is an object kept by fakeOss.
+type fakeObject struct {
+	Data         []byte
+	ETag         string
+	LastModified time.Time
+}
+
+// fakeOss is an in-process OSS bucket API, path style only:
+// http://127.0.0.1:port/bucket/key. Signatures are not checked.
+type fakeOss struct {
+	*httptest.Server
+	lock    sync.Mutex
+	buckets map[string]map[string]*fakeObject
+	fails   map[string]int
+}
+
+func newFakeOss() *fakeOss {
+	f := &fakeOss{
+		buckets: make(map[string]map[string]*fakeObject),
+		fails:   make(map[string]int),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// Endpoint is host:port, what models.Oss wants.
+func (f *fakeOss) Endpoint() string {
+	return strings.TrimPrefix(f.URL, "http://")
+}
+
+// Put stores an object, as an agent uploading backup.
+func (f *fakeOss) Put(bucket, key string, data []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.buckets[bucket] == nil {
+		f.buckets[bucket] = make(map[string]*fakeObject)
+	}
+	h := md5.Sum(data)
+	f.buckets[bucket][key] = &fakeObject{
+		Data:         data,
+		ETag:         strings.ToUpper(hex.EncodeToString(h[:])),
+		LastModified: time.Now().UTC(),
+	}
+}
+
+// Touch sets last modified time of an object.
+func (f *fakeOss) Touch(bucket, key string, t time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if o, ok := f.buckets[bucket][key]; ok {
+		o.LastModified = t.UTC()
+	}
+}
+
+// Get returns data of an object, or nil.
+func (f *fakeOss) Get(bucket, key string) []byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	o, ok := f.buckets[bucket][key]
+	if !ok {
+		return nil
+	}
+	return o.Data
+}
+
+// Fail makes every request with method answer status from now on,
+// status 0 makes method work again.
+func (f *fakeOss) Fail(method string, status int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.fails[method] = status
+}
+
+type fakeOssError struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+type fakeOssContent struct {
+	Key          string `xml:"Key"`
+	Size         int64  `xml:"Size"`
+	ETag         string `xml:"ETag"`
+	LastModified string `xml:"LastModified"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type fakeOssList struct {
+	XMLName     xml.Name         `xml:"ListBucketResult"`
+	Name        string           `xml:"Name"`
+	Prefix      string           `xml:"Prefix"`
+	Marker      string           `xml:"Marker"`
+	MaxKeys     int              `xml:"MaxKeys"`
+	IsTruncated bool             `xml:"IsTruncated"`
+	NextMarker  string           `xml:"NextMarker"`
+	Contents    []fakeOssContent `xml:"Contents"`
+}
+
+func (f *fakeOss) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(fakeOssError{Code: code, Message: code})
+}
+
+func (f *fakeOss) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	status := f.fails[r.Method]
+	f.lock.Unlock()
+	if status != 0 {
+		f.writeError(w, status, "InjectedFailure")
+		return
+	}
+
+	s := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := s[0]
+	var key string
+	if len(s) == 2 {
+		key = s[1]
+	}
+	if key == "" {
+		if r.Method != "GET" {
+			f.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+			return
+		}
+		f.list(w, r, bucket)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			f.writeError(w, http.StatusBadRequest, "BadRequest")
+			return
+		}
+		f.Put(bucket, key, b)
+		w.Header().Set("ETag", fmt.Sprintf("%q", f.object(bucket, key).ETag))
+		w.WriteHeader(http.StatusOK)
+	case "GET", "HEAD":
+		o := f.object(bucket, key)
+		if o == nil {
+			f.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.Data)))
+		w.Header().Set("ETag", fmt.Sprintf("%q", o.ETag))
+		w.Header().Set("Last-Modified", o.LastModified.Format(http.TimeFormat))
+		w.Header().Set("x-oss-storage-class", "Standard")
+		h := md5.Sum(o.Data)
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(h[:]))
+		w.Header().Set("x-oss-hash-crc64ecma", strconv.FormatUint(
+			crc64.Checksum(o.Data, crc64.MakeTable(crc64.ECMA)), 10,
+		))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == "GET" {
+			w.Write(o.Data)
+		}
+	case "DELETE":
+		f.lock.Lock()
+		delete(f.buckets[bucket], key)
+		f.lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeOss) object(bucket, key string) *fakeObject {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.buckets[bucket][key]
+}
+
+func (f *fakeOss) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	prefix := r.URL.Query().Get("prefix")
+	marker := r.URL.Query().Get("marker")
+	maxKeys, err := strconv.Atoi(r.URL.Query().Get("max-keys"))
+	if err != nil || maxKeys <= 0 {
+		maxKeys = 100
+	}
+
+	f.lock.Lock()
+	keys := make([]string, 0)
+	for k := range f.buckets[bucket] {
+		if strings.HasPrefix(k, prefix) && k > marker {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	l := fakeOssList{
+		Name:    bucket,
+		Prefix:  prefix,
+		Marker:  marker,
+		MaxKeys: maxKeys,
+	}
+	for i, k := range keys {
+		if i == maxKeys {
+			l.IsTruncated = true
+			l.NextMarker = keys[i-1]
+			break
+		}
+		o := f.buckets[bucket][k]
+		l.Contents = append(l.Contents, fakeOssContent{
+			Key:          k,
+			Size:         int64(len(o.Data)),
+			ETag:         fmt.Sprintf("%q", o.ETag),
+			LastModified: o.LastModified.Format(time.RFC3339),
+			StorageClass: "Standard",
+		})
+	}
+	f.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(l)
+}
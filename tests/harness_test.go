@@ -0,0 +1,260 @@
+package test
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/cache"
+	"github.com/astaxie/beego/orm"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestMain boots the app against SQLite in a temporary directory and an
+// in-memory cache instead of redis, so no outside service is needed.
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "moduleab_test")
+	if err != nil {
+		panic(err)
+	}
+	code := func() int {
+		defer os.RemoveAll(dir)
+		err = orm.RegisterDataBase(
+			"default", "sqlite3",
+			filepath.Join(dir, "moduleab.db"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		err = orm.RunSyncdb("default", true, false)
+		if err != nil {
+			panic(err)
+		}
+		common.DefaultLocker = common.NewLocalLocker()
+		common.DefaultRedisClient, err = cache.NewCache(
+			"memory", `{"interval":60}`,
+		)
+		if err != nil {
+			panic(err)
+		}
+		return m.Run()
+	}()
+	os.Exit(code)
+}
+
+var (
+	fixtureSeq  int
+	fixtureLock sync.Mutex
+)
+
+// fixture is a backup set on fake OSS and OAS with one app set, host and
+// path, enough for a policy to run on.
+type fixture struct {
+	t   *testing.T
+	oss *fakeOss
+	oas *fakeOas
+
+	Oss       *models.Oss
+	Oas       *models.Oas
+	BackupSet *models.BackupSets
+	AppSet    *models.AppSets
+	Host      *models.Hosts
+	Path      *models.Paths
+	Hosts     []*models.Hosts // Host and ones added by AddHost
+}
+
+func newFixture(t *testing.T) *fixture {
+	fixtureLock.Lock()
+	fixtureSeq++
+	n := fixtureSeq
+	fixtureLock.Unlock()
+
+	f := &fixture{t: t}
+	f.oss = newFakeOss()
+	f.oas = newFakeOas(f.oss)
+
+	host, port := f.oas.Addr()
+	beego.AppConfig.Set("aliapi::oasport", port)
+	vaultName := fmt.Sprintf("vault%d", n)
+	f.oas.AddVault(vaultName)
+
+	f.Oss = &models.Oss{
+		Driver:     common.StorageDriverAliyun,
+		Endpoint:   f.oss.Endpoint(),
+		BucketName: fmt.Sprintf("bucket%d", n),
+	}
+	f.must(models.AddOss(f.Oss))
+
+	f.Oas = &models.Oas{
+		Driver:    common.ArchiveDriverOas,
+		Endpoint:  host,
+		VaultName: vaultName,
+	}
+	archive, err := f.Oas.Archive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Oas.VaultId, err = archive.LookupVault(vaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.must(models.AddOas(f.Oas))
+
+	f.BackupSet = &models.BackupSets{
+		Name: fmt.Sprintf("backupset%d", n),
+		Oss:  f.Oss,
+		Oas:  f.Oas,
+	}
+	f.must(models.AddBackupSet(f.BackupSet))
+
+	f.AppSet = &models.AppSets{
+		Name: fmt.Sprintf("appset%d", n),
+	}
+	f.must(models.AddAppSet(f.AppSet))
+
+	f.Path = &models.Paths{
+		Path:      fmt.Sprintf("/data/test%d", n),
+		BackupSet: f.BackupSet,
+		AppSet:    []*models.AppSets{f.AppSet},
+	}
+	f.must(models.AddPath(f.Path))
+
+	f.Host = &models.Hosts{
+		Name:   fmt.Sprintf("host%d", n),
+		IpAddr: fmt.Sprintf("10.0.%d.%d", n/250, n%250+1),
+		AppSet: f.AppSet,
+		Paths:  []*models.Paths{f.Path},
+	}
+	f.must(models.AddHost(f.Host))
+	f.Hosts = []*models.Hosts{f.Host}
+	return f
+}
+
+// AddHost adds another host with the same app set, and paths given or the
+// same path.
+func (f *fixture) AddHost(paths ...*models.Paths) *models.Hosts {
+	fixtureLock.Lock()
+	fixtureSeq++
+	n := fixtureSeq
+	fixtureLock.Unlock()
+	h := &models.Hosts{
+		Name:   fmt.Sprintf("host%d", n),
+		IpAddr: fmt.Sprintf("10.1.%d.%d", n/250, n%250+1),
+		AppSet: f.AppSet,
+		Paths:  paths,
+	}
+	if len(paths) == 0 {
+		h.Paths = []*models.Paths{f.Path}
+	}
+	f.must(models.AddHost(h))
+	f.Hosts = append(f.Hosts, h)
+	return h
+}
+
+func (f *fixture) must(id string, err error) {
+	f.check(err)
+}
+
+func (f *fixture) check(err error) {
+	if err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fixture) Close() {
+	f.oas.Close()
+	f.oss.Close()
+}
+
+// AddBackup uploads a file to fake OSS and records it, as an agent does.
+func (f *fixture) AddBackup(filename string, backupTime time.Time) *models.Records {
+	return f.AddBackupOn(f.Host, filename, backupTime)
+}
+
+// AddBackupOn is AddBackup of host.
+func (f *fixture) AddBackupOn(host *models.Hosts, filename string, backupTime time.Time) *models.Records {
+	r := &models.Records{
+		Host:       host,
+		BackupSet:  f.BackupSet,
+		AppSet:     f.AppSet,
+		Path:       f.Path,
+		Filename:   filename,
+		Type:       models.RecordTypeBackup,
+		BackupTime: backupTime,
+	}
+	f.oss.Put(f.Oss.BucketName, r.GetFullPath(), []byte("data of "+filename))
+	f.must(models.AddRecord(r))
+	return f.Record(r.Id)
+}
+
+// Record reloads a record from database.
+func (f *fixture) Record(id string) *models.Records {
+	records, err := models.GetRecords(
+		&models.Records{Id: id}, 0, 0,
+		models.OrderAsc, models.OrderAsc,
+	)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return records[0]
+}
+
+// Jobs gets oas jobs of fixture's vault.
+func (f *fixture) Jobs() []*models.OasJobs {
+	jobs, err := models.GetOasJobs(&models.OasJobs{Vault: f.Oas}, 0, 0)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return jobs
+}
+
+// AddPolicy saves a policy on fixture's app set, host and path.
+func (f *fixture) AddPolicy(p *models.Policies) *models.Policies {
+	fixtureLock.Lock()
+	fixtureSeq++
+	p.Name = fmt.Sprintf("policy%d", fixtureSeq)
+	fixtureLock.Unlock()
+	p.BackupSet = f.BackupSet
+	p.AppSets = []*models.AppSets{f.AppSet}
+	p.Hosts = f.Hosts
+	p.Paths = []*models.Paths{f.Path}
+	f.must(models.AddPolicy(p))
+	return p
+}
+
+// serve sends a request signed with loginkey, as agent does, to the app.
+func serve(method, url string, body io.Reader) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, url, body)
+	r.Header = signed(r.URL.Path)
+	w := httptest.NewRecorder()
+	beego.BeeApp.Handlers.ServeHTTP(w, r)
+	return w
+}
+
+// signed makes headers signing a request to path with loginkey.
+func signed(path string) http.Header {
+	date := time.Now().UTC().Format(time.RFC1123)
+	h := hmac.New(sha1.New, []byte(beego.AppConfig.String("loginkey")))
+	h.Write([]byte(date + path))
+	header := make(http.Header)
+	header.Set("Date", date)
+	header.Set("Signature", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	return header
+}
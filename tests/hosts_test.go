@@ -0,0 +1,98 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/controllers"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHostBackup(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	server := httptest.NewServer(beego.BeeApp.Handlers)
+	defer server.Close()
+	url := "/api/v1/hosts/" + f.Host.Name + "/backup"
+
+	a := connectAgent(t, server, f.Host.Name)
+	defer a.conn.Close()
+	a.Send(models.EnvelopeKindHello, "", &models.HelloBody{
+		Versions:     []int{1},
+		Capabilities: []string{"backup"},
+	})
+	a.NextEnvelope()
+
+	badPath := serve("POST", url, strings.NewReader(`{"path": "/nowhere"}`))
+	noHost := serve("POST", "/api/v1/hosts/nohost/backup",
+		strings.NewReader(`{"path": "/nowhere"}`))
+	w := serve("POST", url, strings.NewReader(
+		fmt.Sprintf(`{"path": %q}`, f.Path.Path),
+	))
+	var asked map[string]string
+	json.Unmarshal(w.Body.Bytes(), &asked)
+	id := asked["id"]
+
+	command := a.NextEnvelope()
+	backup := new(models.BackupCommand)
+	command.Decode(backup)
+	a.Send(models.EnvelopeKindProgress, id, &models.ProgressBody{Percent: 30})
+	waitSignalFor(f.Host.Id, id, func(s *models.Signals) bool {
+		return s.Progress == 30
+	})
+	running := serve("GET", url+"/"+id, nil)
+	var runningStatus controllers.BackupStatus
+	json.Unmarshal(running.Body.Bytes(), &runningStatus)
+
+	// Agent records backup it made, as for scheduled ones.
+	record := serve("POST", "/api/v1/records/", strings.NewReader(fmt.Sprintf(
+		`{"host": {"id": %q}, "backupset": {"id": %q}, "appset": {"id": %q},
+		"path": {"id": %q}, "filename": "now.tar.gz", "type": 1,
+		"backuptime": %q, "signalid": %q}`,
+		f.Host.Id, f.BackupSet.Id, f.AppSet.Id, f.Path.Id,
+		time.Now().Format(time.RFC3339), id,
+	)))
+	downloadId, err := models.AddSignal(f.Host.Id,
+		models.MakeDownloadSignal("/a.tar.gz", f.BackupSet.Oss, ""))
+	f.must(downloadId, err)
+	notBackup := serve("POST", "/api/v1/records/", strings.NewReader(fmt.Sprintf(
+		`{"host": {"id": %q}, "backupset": {"id": %q}, "appset": {"id": %q},
+		"path": {"id": %q}, "filename": "odd.tar.gz", "type": 1,
+		"backuptime": %q, "signalid": %q}`,
+		f.Host.Id, f.BackupSet.Id, f.AppSet.Id, f.Path.Id,
+		time.Now().Format(time.RFC3339), downloadId,
+	)))
+	a.Send(models.EnvelopeKindDone, id, &models.DoneBody{})
+	waitSignal(f.Host.Id, id, models.SignalStatusAcked)
+	done := serve("GET", url+"/"+id, nil)
+	var doneStatus controllers.BackupStatus
+	json.Unmarshal(done.Body.Bytes(), &doneStatus)
+	missing := serve("GET", url+"/00000000-0000-0000-0000-000000000000", nil)
+
+	Convey("Subject: Back host up now\n", t, func() {
+		So(badPath.Code, ShouldEqual, http.StatusBadRequest)
+		So(noHost.Code, ShouldEqual, http.StatusNotFound)
+		So(w.Code, ShouldEqual, http.StatusAccepted)
+		So(command.Type, ShouldEqual, "backup")
+		So(command.Id, ShouldEqual, id)
+		So(backup.Path, ShouldEqual, f.Path.Path)
+		So(backup.Bucket, ShouldEqual, f.Oss.BucketName)
+		So(running.Code, ShouldEqual, http.StatusOK)
+		So(runningStatus.Signal.Progress, ShouldEqual, 30)
+		So(len(runningStatus.Records), ShouldEqual, 0)
+		So(record.Code, ShouldEqual, http.StatusCreated)
+		So(notBackup.Code, ShouldEqual, http.StatusBadRequest)
+		So(doneStatus.Signal.Status, ShouldEqual, models.SignalStatusAcked)
+		So(len(doneStatus.Records), ShouldEqual, 1)
+		So(doneStatus.Records[0].Filename, ShouldEqual, "now.tar.gz")
+		So(missing.Code, ShouldEqual, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,136 @@
+package test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/controllers"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// receive reads n messages from c, or till it waits too long.
+func receive(c <-chan string, n int) []string {
+	r := make([]string, 0, n)
+	for len(r) < n {
+		select {
+		case msg, ok := <-c:
+			if !ok {
+				return r
+			}
+			r = append(r, msg)
+		case <-time.After(2 * time.Second):
+			return r
+		}
+	}
+	return r
+}
+
+func TestLocalHub(t *testing.T) {
+	h := common.NewLocalHub()
+	c1, stop1 := h.Subscribe("a")
+	c2, stop2 := h.Subscribe("a")
+	c3, stop3 := h.Subscribe("b")
+	defer stop3()
+
+	// Many publishers and a subscriber coming and going at once.
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				h.Publish("a", fmt.Sprintf("%d-%d", i, j))
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_, stop := h.Subscribe("a")
+			stop()
+		}
+	}()
+	wg.Wait()
+	got1 := receive(c1, 500)
+	got2 := receive(c2, 500)
+	stop1()
+	stop1()
+	_, open := <-c1
+	h.Publish("a", "after")
+	after := receive(c2, 1)
+	stop2()
+
+	Convey("Subject: Local hub\n", t, func() {
+		So(len(got1), ShouldEqual, 500)
+		So(len(got2), ShouldEqual, 500)
+		So(open, ShouldBeFalse)
+		So(after, ShouldResemble, []string{"after"})
+		So(len(c3), ShouldEqual, 0)
+	})
+}
+
+func TestRedisHub(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// Two server instances sharing one redis.
+	h1 := common.NewRedisHub(s.Addr(), "", "test")
+	h2 := common.NewRedisHub(s.Addr(), "", "test")
+	c1, stop1 := h1.Subscribe("signal:host")
+	defer stop1()
+	c2, stop2 := h2.Subscribe("signal:host")
+	defer stop2()
+
+	wg := new(sync.WaitGroup)
+	for i, h := range []common.Hub{h1, h2} {
+		wg.Add(1)
+		go func(i int, h common.Hub) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				h.Publish("signal:host", fmt.Sprintf("%d-%d", i, j))
+			}
+		}(i, h)
+	}
+	wg.Wait()
+	got1 := receive(c1, 40)
+	got2 := receive(c2, 40)
+
+	Convey("Subject: Redis hub\n", t, func() {
+		So(len(got1), ShouldEqual, 40)
+		So(len(got2), ShouldEqual, 40)
+	})
+}
+
+func TestClientStatus(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			controllers.ClientStatus.Set(
+				fmt.Sprintf("host%d", i), controllers.ClientRunStatusRunning,
+			)
+		}(i)
+		go func() {
+			defer wg.Done()
+			controllers.ClientStatus.All()
+		}()
+	}
+	wg.Wait()
+
+	Convey("Subject: Client status\n", t, func() {
+		So(
+			controllers.ClientStatus.Get("host3"),
+			ShouldEqual, controllers.ClientRunStatusRunning,
+		)
+		So(len(controllers.ClientStatus.All()), ShouldBeGreaterThanOrEqualTo, 10)
+	})
+}
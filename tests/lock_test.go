@@ -0,0 +1,77 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+
+	"github.com/alicebob/miniredis/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testLocker(l common.Locker) {
+	ok1, err1 := l.TryLock("test", "a", time.Minute)
+	ok2, err2 := l.TryLock("test", "b", time.Minute)
+	So(err1, ShouldBeNil)
+	So(err2, ShouldBeNil)
+	So(ok1, ShouldBeTrue)
+	So(ok2, ShouldBeFalse)
+
+	ok, err := l.Refresh("test", "b", time.Minute)
+	So(err, ShouldBeNil)
+	So(ok, ShouldBeFalse)
+	ok, err = l.Refresh("test", "a", time.Minute)
+	So(err, ShouldBeNil)
+	So(ok, ShouldBeTrue)
+
+	// Only owner can unlock.
+	So(l.Unlock("test", "b"), ShouldBeNil)
+	ok, _ = l.TryLock("test", "b", time.Minute)
+	So(ok, ShouldBeFalse)
+	So(l.Unlock("test", "a"), ShouldBeNil)
+	ok, _ = l.TryLock("test", "b", time.Minute)
+	So(ok, ShouldBeTrue)
+	So(l.Unlock("test", "b"), ShouldBeNil)
+}
+
+func TestLocalLocker(t *testing.T) {
+	Convey("Subject: Local locker\n", t, func() {
+		testLocker(common.NewLocalLocker())
+	})
+}
+
+func TestClaim(t *testing.T) {
+	// Scheduled slot of a policy is claimed by who runs it first.
+	name := fmt.Sprintf("policy:claim:%d", time.Now().UnixNano())
+	first := common.Claim(name, time.Minute)
+	again := common.Claim(name, time.Minute)
+
+	Convey("Subject: Claim\n", t, func() {
+		Convey("Claim should be kept till it expires", func() {
+			So(first, ShouldBeNil)
+			So(again, ShouldEqual, common.ErrorLocked)
+		})
+	})
+}
+
+func TestRedisLocker(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	l := common.NewRedisLocker(s.Addr(), "", "test")
+
+	Convey("Subject: Redis locker\n", t, func() {
+		testLocker(l)
+		Convey("Lease should expire", func() {
+			ok, _ := l.TryLock("expire", "a", time.Second)
+			So(ok, ShouldBeTrue)
+			s.FastForward(2 * time.Second)
+			ok, _ = l.TryLock("expire", "b", time.Second)
+			So(ok, ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,650 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
+
+	"github.com/astaxie/beego/orm"
+	"github.com/pborman/uuid"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func archivePolicy() *models.Policies {
+	return &models.Policies{
+		Target:      models.PolicyTargetBackup,
+		Action:      models.PolicyActionArchive,
+		TargetStart: models.PolicyTargetTimeNow,
+		TargetEnd:   models.PolicyTargetTimeLongLongAgo,
+		Step:        models.PolicyReserveAll,
+		Enabled:     true,
+	}
+}
+
+// archived runs archive policy on a new backup and completes the job.
+func archived(f *fixture, filename string) *models.Records {
+	f.AddPolicy(archivePolicy())
+	r := f.AddBackup(filename, time.Now().Add(-time.Hour))
+	policies.RunPolicies()
+	for _, v := range f.Jobs() {
+		if err := f.oas.Complete(v.JobId); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	policies.SweepOasJobs()
+	return f.Record(r.Id)
+}
+
+func TestArchivePolicy(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	f.AddPolicy(archivePolicy())
+	r := f.AddBackup("a.tar.gz", time.Now().Add(-time.Hour))
+
+	policies.RunPolicies()
+	made := f.Jobs()
+	for _, v := range made {
+		f.oas.Complete(v.JobId)
+	}
+	policies.SweepOasJobs()
+	done := f.Jobs()
+	record := f.Record(r.Id)
+
+	Convey("Subject: Archive policy on fake OSS and OAS\n", t, func() {
+		Convey("A pull from OSS job should be made", func() {
+			So(len(made), ShouldEqual, 1)
+			So(made[0].JobType, ShouldEqual, models.OasJobTypePullFromOSS)
+			So(made[0].State, ShouldEqual, models.OasJobStateRunning)
+		})
+		Convey("Record should be archived after job completed", func() {
+			So(len(done), ShouldEqual, 1)
+			So(done[0].State, ShouldEqual, models.OasJobStateSucceeded)
+			So(record.ArchiveId, ShouldNotBeEmpty)
+			So(
+				string(f.oas.Archive(f.Oas.VaultId, record.ArchiveId)),
+				ShouldEqual, "data of a.tar.gz",
+			)
+		})
+	})
+}
+
+func TestArchivePolicyJobFailed(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	f.AddPolicy(archivePolicy())
+	r := f.AddBackup("b.tar.gz", time.Now().Add(-time.Hour))
+
+	policies.RunPolicies()
+	for _, v := range f.Jobs() {
+		f.oas.Fail(v.JobId, "Injected")
+	}
+	policies.SweepOasJobs()
+	failed := f.Jobs()
+	policies.SweepOasJobs()
+	waiting := f.Jobs()
+	record := f.Record(r.Id)
+
+	// Time to retry.
+	waiting[0].NextRetry = time.Now().Add(-time.Second)
+	f.check(models.UpdateOasJobs(waiting[0]))
+	policies.SweepOasJobs()
+	retried := f.Jobs()
+	f.oas.Complete(retried[0].JobId)
+	policies.SweepOasJobs()
+	done := f.Jobs()
+	archivedRecord := f.Record(r.Id)
+
+	Convey("Subject: Failed archive job\n", t, func() {
+		Convey("Job should wait to be retried", func() {
+			So(len(failed), ShouldEqual, 1)
+			So(failed[0].State, ShouldEqual, models.OasJobStatePending)
+			So(failed[0].Message, ShouldEqual, "Injected")
+			So(failed[0].NextRetry, ShouldHappenAfter, time.Now())
+			So(waiting[0].JobId, ShouldEqual, failed[0].JobId)
+			So(record.ArchiveId, ShouldBeEmpty)
+		})
+		Convey("Job should be submitted again", func() {
+			So(len(retried), ShouldEqual, 1)
+			So(retried[0].State, ShouldEqual, models.OasJobStateRunning)
+			So(retried[0].Attempts, ShouldEqual, 2)
+			So(retried[0].JobId, ShouldNotEqual, failed[0].JobId)
+			So(done[0].State, ShouldEqual, models.OasJobStateSucceeded)
+			So(archivedRecord.ArchiveId, ShouldNotBeEmpty)
+		})
+	})
+}
+
+func TestOasJobGivesUp(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	f.AddPolicy(archivePolicy())
+	f.AddBackup("g.tar.gz", time.Now().Add(-time.Hour))
+
+	policies.RunPolicies()
+	attempts := make([]int, 0)
+	for i := 0; i < 5; i++ {
+		job := f.Jobs()[0]
+		if job.State == models.OasJobStatePending {
+			job.NextRetry = time.Now().Add(-time.Second)
+			f.check(models.UpdateOasJobs(job))
+			policies.SweepOasJobs()
+			job = f.Jobs()[0]
+		}
+		if job.State != models.OasJobStateRunning {
+			break
+		}
+		attempts = append(attempts, job.Attempts)
+		f.oas.Fail(job.JobId, "Injected")
+		policies.SweepOasJobs()
+	}
+	job := f.Jobs()[0]
+	logs, err := models.GetFailLogs(&models.FailLog{Host: f.Host})
+	f.check(err)
+	cancelOver := serve("POST", "/api/v1/oasJobs/"+job.JobId+"/cancel", nil)
+
+	// A running job may be cancelled.
+	f.AddBackup("h.tar.gz", time.Now().Add(-time.Hour))
+	policies.RunPolicies()
+	var running *models.OasJobs
+	for _, v := range f.Jobs() {
+		if v.State == models.OasJobStateRunning {
+			running = v
+		}
+	}
+	if running == nil {
+		t.Fatal("No job is running")
+	}
+	cancel := serve("POST", "/api/v1/oasJobs/"+running.JobId+"/cancel", nil)
+	f.oas.Fail(running.JobId, "Injected")
+	policies.SweepOasJobs()
+	var cancelled *models.OasJobs
+	for _, v := range f.Jobs() {
+		if v.Id == running.Id {
+			cancelled = v
+		}
+	}
+	missing := serve("POST", "/api/v1/oasJobs/nojob/cancel", nil)
+
+	Convey("Subject: Oas job out of attempts\n", t, func() {
+		Convey("Job should fail after all attempts", func() {
+			So(attempts, ShouldResemble, []int{1, 2, 3})
+			So(job.State, ShouldEqual, models.OasJobStateFailed)
+			So(job.Message, ShouldEqual, "Injected")
+		})
+		Convey("Failure should be logged", func() {
+			So(len(logs), ShouldEqual, 1)
+			So(logs[0].Log, ShouldContainSubstring, job.Id)
+			So(logs[0].Log, ShouldContainSubstring, "Injected")
+		})
+		Convey("Only job not over should be cancelled", func() {
+			So(cancelOver.Code, ShouldEqual, http.StatusConflict)
+			So(cancel.Code, ShouldEqual, http.StatusOK)
+			So(cancelled.State, ShouldEqual, models.OasJobStateCancelled)
+			So(cancelled.Message, ShouldEqual, "Cancelled by api")
+			So(missing.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+
+func TestMigrateOasJobs(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	f.AddPolicy(archivePolicy())
+	f.AddBackup("m1.tar.gz", time.Now().Add(-2*time.Hour))
+	f.AddBackup("m2.tar.gz", time.Now().Add(-time.Hour))
+	policies.RunPolicies()
+	jobs := f.Jobs()
+	if len(jobs) != 2 {
+		t.Fatal("Archive jobs are not made:", len(jobs))
+	}
+
+	// Database of old version, first job was complete.
+	o := orm.NewOrm()
+	_, err := o.Raw("ALTER TABLE oas_jobs ADD COLUMN status bool NOT NULL DEFAULT 0").Exec()
+	f.check(err)
+	_, err = o.Raw("UPDATE oas_jobs SET status = 1 WHERE id = ?", jobs[0].Id).Exec()
+	f.check(err)
+	f.check(models.MigrateOasJobs())
+	f.check(models.MigrateOasJobs())
+	states := make(map[string]int)
+	for _, v := range f.Jobs() {
+		states[v.Id] = v.State
+	}
+
+	Convey("Subject: Oas jobs of old version\n", t, func() {
+		Convey("Complete job should be succeeded, others running", func() {
+			So(states[jobs[0].Id], ShouldEqual, models.OasJobStateSucceeded)
+			So(states[jobs[1].Id], ShouldEqual, models.OasJobStateRunning)
+		})
+	})
+}
+
+func TestRecoverArchive(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	record := archived(f, "c.tar.gz")
+
+	// Backup is gone from OSS, only the archive is left.
+	record.Type = models.RecordTypeArchive
+	if err := models.UpdateRecord(record); err != nil {
+		t.Fatal(err)
+	}
+	storage, err := f.Oss.Storage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.DeleteObject(record.GetFullPath())
+
+	w := serve("GET", "/api/v1/records/"+record.Id+"/recover", nil)
+	var job *models.OasJobs
+	for _, v := range f.Jobs() {
+		if v.JobType == models.OasJobTypePushToOSS {
+			job = v
+			f.oas.Complete(v.JobId)
+		}
+	}
+	policies.SweepOasJobs()
+	recovered := f.Record(record.Id)
+	signals := models.GetSignals(f.Host.Id)
+	// Old backups are deleted, but not one retrieved to be downloaded.
+	decisions, err := policies.Plan(f.AddPolicy(&models.Policies{
+		Target:      models.PolicyTargetBackup,
+		Action:      models.PolicyActionDelete,
+		TargetStart: 0,
+		TargetEnd:   models.PolicyTargetTimeLongLongAgo,
+		Step:        models.PolicyReserveNone,
+	}))
+	f.check(err)
+
+	Convey("Subject: Recover archived record\n", t, func() {
+		Convey("Recover should be accepted", func() {
+			So(w.Code, ShouldEqual, http.StatusAccepted)
+			So(job, ShouldNotBeNil)
+		})
+		Convey("Backup should be in OSS and agent signaled", func() {
+			So(
+				string(f.oss.Get(f.Oss.BucketName, record.GetFullPath())),
+				ShouldEqual, "data of c.tar.gz",
+			)
+			So(recovered.Type, ShouldEqual, models.RecordTypeBackup)
+			So(len(signals), ShouldEqual, 1)
+			So(signals[0]["type"], ShouldEqual, models.SignalTypeDownload)
+		})
+		Convey("Backup time should be kept", func() {
+			So(recovered.BackupTime.Unix(), ShouldEqual, record.BackupTime.Unix())
+			So(recovered.RetrieveTime, ShouldHappenAfter, record.BackupTime)
+			So(len(decisions), ShouldEqual, 1)
+			So(decisions[0].Action, ShouldEqual, policies.DecisionKeep)
+		})
+	})
+}
+
+func TestPolicySchedule(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	p := archivePolicy()
+	p.Schedule = "* * * * * *"
+	p.TimeZone = "UTC"
+	f.AddPolicy(p)
+	f.AddBackup("e.tar.gz", time.Now().Add(-time.Hour))
+	bad := &models.Policies{Name: "bad", Schedule: "every day"}
+
+	err := policies.Reload()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.Jobs()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	jobs := f.Jobs()
+	p.Enabled = false
+	models.UpdatePolicy(p)
+	policies.Reload()
+
+	// Other servers hear of reload by hub.
+	reloads, stop := common.DefaultHub.Subscribe("schedule")
+	defer stop()
+	reloadErr := policies.ReloadAll()
+	var told bool
+	select {
+	case <-reloads:
+		told = true
+	case <-time.After(5 * time.Second):
+	}
+
+	Convey("Subject: Policy on its own schedule\n", t, func() {
+		Convey("Policy should be run by scheduler", func() {
+			So(err, ShouldBeNil)
+			So(len(jobs), ShouldBeGreaterThan, 0)
+		})
+		Convey("Bad schedule should be refused", func() {
+			_, err := models.AddPolicy(bad)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Every server should be told to reload", func() {
+			So(reloadErr, ShouldBeNil)
+			So(told, ShouldBeTrue)
+		})
+	})
+}
+
+func TestPolicyPreview(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	p := f.AddPolicy(&models.Policies{
+		Target:      models.PolicyTargetBackup,
+		Action:      models.PolicyActionDelete,
+		TargetStart: 2 * 3600,
+		TargetEnd:   models.PolicyTargetTimeLongLongAgo,
+		Step:        models.PolicyReserveNone,
+	})
+	old := f.AddBackup("f.tar.gz", time.Now().Add(-3*time.Hour))
+	f.AddBackup("g.tar.gz", time.Now().Add(-time.Hour))
+
+	w := serve("POST", "/api/v1/policies/"+p.Name+"/preview", nil)
+	var preview map[string][]*policies.Decision
+	err := json.Unmarshal(w.Body.Bytes(), &preview)
+
+	Convey("Subject: Preview delete policy\n", t, func() {
+		Convey("Old backup should be deleted", func() {
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(err, ShouldBeNil)
+			So(len(preview[policies.DecisionDelete]), ShouldEqual, 1)
+			So(preview[policies.DecisionDelete][0].Record.Id, ShouldEqual, old.Id)
+			So(preview[policies.DecisionDelete][0].Reason, ShouldNotBeEmpty)
+			So(len(preview[policies.DecisionArchive]), ShouldEqual, 0)
+		})
+		Convey("Nothing should be touched", func() {
+			So(f.Record(old.Id), ShouldNotBeNil)
+			So(f.oss.Get(f.Oss.BucketName, old.GetFullPath()), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPolicyPaging(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	p := f.AddPolicy(archivePolicy())
+	// One more than a page, inserted at once as AddBackup is slow.
+	start := time.Now().Add(-2000 * time.Minute)
+	records := make([]*models.Records, 0, 1001)
+	for i := 0; i <= 1000; i++ {
+		records = append(records, &models.Records{
+			Id:          uuid.New(),
+			Host:        f.Host,
+			BackupSet:   f.BackupSet,
+			AppSet:      f.AppSet,
+			Path:        f.Path,
+			Filename:    fmt.Sprintf("p%04d.tar.gz", i),
+			Type:        models.RecordTypeBackup,
+			BackupTime:  start.Add(time.Duration(i) * time.Minute),
+			VerifyState: models.RecordVerifyPending,
+		})
+	}
+	_, err := orm.NewOrm().InsertMulti(20, records)
+	f.check(err)
+	last := records[len(records)-1]
+	decisions, err := policies.Plan(p)
+	planned := make(map[string]bool)
+	for _, v := range decisions {
+		planned[v.Record.Id] = true
+	}
+
+	Convey("Subject: Policy on more records than a page\n", t, func() {
+		Convey("Records of every page should be planned once", func() {
+			So(err, ShouldBeNil)
+			So(len(decisions), ShouldEqual, 1001)
+			So(len(planned), ShouldEqual, 1001)
+			So(planned[last.Id], ShouldBeTrue)
+		})
+	})
+}
+
+func TestPolicyRuns(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	p := f.AddPolicy(archivePolicy())
+	f.AddBackup("h.tar.gz", time.Now().Add(-2*time.Hour))
+	f.AddBackup("i.tar.gz", time.Now().Add(-time.Hour))
+
+	// Second archive job cannot be made.
+	f.oas.FailJobsAfter(1)
+	policies.RunPolicy(p)
+	w := serve("GET", "/api/v1/policies/"+p.Name+"/runs", nil)
+	var runs []*models.PolicyRuns
+	err := json.Unmarshal(w.Body.Bytes(), &runs)
+
+	Convey("Subject: Policy run history\n", t, func() {
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(err, ShouldBeNil)
+		So(len(runs), ShouldEqual, 1)
+		So(runs[0].Archived, ShouldEqual, 1)
+		So(runs[0].Failed, ShouldEqual, 1)
+		So(runs[0].Log, ShouldNotBeEmpty)
+		So(runs[0].EndTime.IsZero(), ShouldBeFalse)
+	})
+}
+
+func TestPolicyGFS(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	p := f.AddPolicy(&models.Policies{
+		Target:      models.PolicyTargetBackup,
+		Action:      models.PolicyActionDelete,
+		TargetStart: models.PolicyTargetTimeNow,
+		TargetEnd:   models.PolicyTargetTimeLongLongAgo,
+		TimeZone:    "UTC",
+		Retention:   models.PolicyRetentionGFS,
+		KeepDaily:   2,
+		KeepMonthly: 2,
+	})
+	at := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339, s)
+		return t
+	}
+	kept := []*models.Records{
+		f.AddBackup("gfs-a.tar.gz", at("2026-01-10T10:00:00Z")),
+		f.AddBackup("gfs-b.tar.gz", at("2026-01-09T12:00:00Z")),
+		f.AddBackup("gfs-c.tar.gz", at("2025-11-20T00:00:00Z")),
+	}
+	deleted := []*models.Records{
+		f.AddBackup("gfs-d.tar.gz", at("2026-01-10T08:00:00Z")),
+		f.AddBackup("gfs-e.tar.gz", at("2026-01-07T00:00:00Z")),
+	}
+
+	decisions, err := policies.Plan(p)
+	actions := make(map[string]string)
+	for _, d := range decisions {
+		actions[d.Record.Id] = d.Action
+	}
+	bad := &models.Policies{
+		Name:      "badgfs",
+		Retention: models.PolicyRetentionGFS,
+	}
+
+	Convey("Subject: GFS retention\n", t, func() {
+		So(err, ShouldBeNil)
+		So(len(decisions), ShouldEqual, 5)
+		Convey("Newest of each day and month should be kept", func() {
+			for _, r := range kept {
+				So(actions[r.Id], ShouldEqual, policies.DecisionKeep)
+			}
+		})
+		Convey("Others should be deleted", func() {
+			for _, r := range deleted {
+				So(actions[r.Id], ShouldEqual, policies.DecisionDelete)
+			}
+		})
+		Convey("GFS keeping nothing should be refused", func() {
+			_, err := models.AddPolicy(bad)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPolicyKeepCount(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	p := f.AddPolicy(&models.Policies{
+		Target:      models.PolicyTargetAll,
+		Action:      models.PolicyActionDelete,
+		TargetStart: models.PolicyTargetTimeNow,
+		TargetEnd:   models.PolicyTargetTimeLongLongAgo,
+		Retention:   models.PolicyRetentionCount,
+		KeepCount:   2,
+	})
+	oldest := f.AddBackup("cnt-a.tar.gz", time.Now().Add(-72*time.Hour))
+	f.AddBackup("cnt-b.tar.gz", time.Now().Add(-48*time.Hour))
+	f.AddBackup("cnt-c.tar.gz", time.Now().Add(-24*time.Hour))
+	archives := make([]*models.Records, 0)
+	for _, name := range []string{"cnt-d.tar.gz", "cnt-e.tar.gz"} {
+		r := f.AddBackup(name, time.Now().Add(-96*time.Hour))
+		r.Type = models.RecordTypeArchive
+		r.ArchiveId = name
+		r.ArchivedTime = time.Now().Add(-90 * time.Hour)
+		models.UpdateRecord(r)
+		archives = append(archives, r)
+	}
+
+	policies.RunPolicy(p)
+	var left []*models.Records
+	for _, r := range archives {
+		left = append(left, f.Record(r.Id))
+	}
+
+	Convey("Subject: Keep count retention\n", t, func() {
+		Convey("Oldest backup over count should be deleted", func() {
+			So(f.Record(oldest.Id), ShouldBeNil)
+			So(f.oss.Get(f.Oss.BucketName, oldest.GetFullPath()), ShouldBeNil)
+		})
+		Convey("Archives are counted on their own", func() {
+			for _, r := range left {
+				So(r, ShouldNotBeNil)
+			}
+		})
+		Convey("Keep count of 0 should be refused", func() {
+			_, err := models.AddPolicy(&models.Policies{
+				Name:      "badcount",
+				Retention: models.PolicyRetentionCount,
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPolicyLocked(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	p := f.AddPolicy(archivePolicy())
+	f.AddBackup("lock-a.tar.gz", time.Now().Add(-time.Hour))
+
+	// Other server is running it.
+	_, release, err := common.Acquire(context.Background(), "policy:"+p.Id)
+	policies.RunPolicy(p)
+	skipped := f.Jobs()
+	release()
+	policies.RunPolicy(p)
+	policies.RunPolicy(p)
+	jobs := f.Jobs()
+
+	Convey("Subject: Policy locked by other server\n", t, func() {
+		So(err, ShouldBeNil)
+		Convey("Policy should not run while locked", func() {
+			So(len(skipped), ShouldEqual, 0)
+		})
+		Convey("Record being archived should not be archived again", func() {
+			So(len(jobs), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestPolicyWorkers(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	for i := 0; i < 5; i++ {
+		f.AddHost()
+	}
+	p := f.AddPolicy(archivePolicy())
+	for i, h := range f.Hosts {
+		for j := 0; j < 3; j++ {
+			f.AddBackupOn(h, fmt.Sprintf("pool-%d-%d.tar.gz", i, j),
+				time.Now().Add(-time.Duration(j+1)*time.Hour))
+		}
+	}
+
+	// Hosts take turns, so their records are submitted at the same time.
+	f.oas.SetDelay(10 * time.Millisecond)
+	policies.RunPolicy(p)
+	jobs := f.Jobs()
+	runs, err := models.GetPolicyRuns(
+		&models.PolicyRuns{Policy: p}, 0, 0,
+	)
+	// Fake OAS numbers jobs as they are submitted.
+	jobIds := make(map[string][]string)
+	for _, v := range f.Hosts {
+		records, err := models.GetRecords(
+			&models.Records{Host: v}, 0, 0,
+			models.OrderAsc, models.OrderAsc,
+		)
+		f.check(err)
+		for _, r := range records {
+			for _, j := range jobs {
+				if j.Records != nil && j.Records.Id == r.Id {
+					jobIds[v.Id] = append(jobIds[v.Id], j.JobId)
+				}
+			}
+		}
+	}
+
+	Convey("Subject: Policy run by worker pool\n", t, func() {
+		So(err, ShouldBeNil)
+		So(len(jobs), ShouldEqual, 18)
+		So(len(runs), ShouldEqual, 1)
+		So(runs[0].Archived, ShouldEqual, 18)
+		So(runs[0].Failed, ShouldEqual, 0)
+		Convey("Records of a host and path should go oldest first", func() {
+			So(len(jobIds), ShouldEqual, 6)
+			for _, v := range jobIds {
+				So(len(v), ShouldEqual, 3)
+				So(sort.StringsAreSorted(v), ShouldBeTrue)
+			}
+		})
+	})
+}
+
+func TestPolicyCancel(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	p := f.AddPolicy(archivePolicy())
+	for i := 0; i < 10; i++ {
+		f.AddBackup(fmt.Sprintf("cancel-%d.tar.gz", i),
+			time.Now().Add(-time.Duration(i+1)*time.Hour))
+	}
+
+	// Slow OAS makes run last long enough to be cancelled.
+	f.oas.SetDelay(100 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		policies.RunPolicy(p)
+		close(done)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	cancelled := policies.Cancel(p.Id)
+	<-done
+	runs, _ := models.GetPolicyRuns(&models.PolicyRuns{Policy: p}, 0, 0)
+	jobs := f.Jobs()
+
+	Convey("Subject: Cancel policy run\n", t, func() {
+		So(cancelled, ShouldBeTrue)
+		So(len(jobs), ShouldBeLessThan, 10)
+		So(len(runs), ShouldEqual, 1)
+		So(runs[0].Error, ShouldEqual, "Cancelled")
+		So(policies.Cancel(p.Id), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := common.NewRateLimiter(20)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.Wait(context.Background())
+	}
+	took := time.Since(start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := common.NewRateLimiter(0.1)
+	slow.Wait(ctx)
+	err := slow.Wait(ctx)
+	// Cancelled requests should not have taken a turn.
+	free := slow.Wait(context.Background())
+
+	// Turn of request given up while waiting goes to the next one.
+	l = common.NewRateLimiter(2)
+	l.Wait(context.Background())
+	start = time.Now()
+	timeout, stop := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer stop()
+	timedOut := l.Wait(timeout)
+	l.Wait(context.Background())
+	gaveBack := time.Since(start)
+
+	Convey("Subject: Rate limiter\n", t, func() {
+		So(took, ShouldBeGreaterThanOrEqualTo, 200*time.Millisecond)
+		So(err, ShouldEqual, context.Canceled)
+		So(free, ShouldBeNil)
+		So(timedOut == context.DeadlineExceeded, ShouldBeTrue)
+		So(gaveBack, ShouldBeLessThan, 800*time.Millisecond)
+		So(common.NewRateLimiter(0).Wait(context.Background()), ShouldBeNil)
+	})
+}
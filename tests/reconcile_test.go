@@ -0,0 +1,178 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReconcileVault(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	f.AddPolicy(archivePolicy())
+	records := make([]*models.Records, 0)
+	for _, name := range []string{"inv-a.tar.gz", "inv-b.tar.gz", "inv-c.tar.gz"} {
+		records = append(records, f.AddBackup(name, time.Now().Add(-2*time.Hour)))
+	}
+	policies.RunPolicies()
+	for _, v := range f.Jobs() {
+		f.check(f.oas.Complete(v.JobId))
+	}
+	policies.SweepOasJobs()
+	for i, v := range records {
+		records[i] = f.Record(v.Id)
+		records[i].ArchivedTime = time.Now().Add(-time.Hour)
+		f.check(models.UpdateRecord(records[i]))
+	}
+	a, b, c := records[0], records[1], records[2]
+
+	// a is only in archive now, and deleted by policy.
+	a.Type = models.RecordTypeArchive
+	f.check(models.UpdateRecord(a))
+	p := f.AddPolicy(&models.Policies{
+		Target:      models.PolicyTargetArchive,
+		Action:      models.PolicyActionDelete,
+		TargetStart: models.PolicyTargetTimeNow,
+		TargetEnd:   models.PolicyTargetTimeLongLongAgo,
+		Step:        models.PolicyReserveNone,
+	})
+	policies.RunPolicy(p)
+	deletes, err := models.GetOasJobs(&models.OasJobs{
+		JobType:   models.OasJobTypeDeleteArchive,
+		ArchiveId: a.ArchiveId,
+	}, 0, 0)
+	f.check(err)
+
+	// An archive nobody records, and one recorded but lost.
+	stray := f.oas.PutArchive(f.Oas.VaultId, "stray", []byte("stray"))
+	archive, err := f.Oas.Archive()
+	f.check(err)
+	_, err = archive.DeleteArchive(c.ArchiveId)
+	f.check(err)
+
+	url := "/api/v1/oas/" + f.Oas.VaultName + "/inventory"
+	submitted := serve("POST", url, nil)
+	var job models.OasJobs
+	json.Unmarshal(submitted.Body.Bytes(), &job)
+	again := serve("POST", url, nil)
+	f.check(f.oas.Complete(job.JobId))
+	policies.SweepOasJobs()
+
+	list := serve("GET", "/api/v1/reconciliations?vault="+f.Oas.VaultName, nil)
+	var reconciliations []*models.Reconciliations
+	json.Unmarshal(list.Body.Bytes(), &reconciliations)
+	if len(reconciliations) == 0 {
+		t.Fatal("Vault is not reconciled:", list.Body.String())
+	}
+	got := serve("GET", "/api/v1/reconciliations/"+reconciliations[0].Id, nil)
+	var reconciliation models.Reconciliations
+	json.Unmarshal(got.Body.Bytes(), &reconciliation)
+	problems := make(map[string]int)
+	for _, v := range reconciliation.Items {
+		problems[v.Key] = v.Problem
+	}
+
+	Convey("Subject: Reconcile vault with records\n", t, func() {
+		Convey("Deleting archive should be recorded as a job", func() {
+			So(len(deletes), ShouldEqual, 1)
+			So(deletes[0].State, ShouldEqual, models.OasJobStateSucceeded)
+			So(f.Record(a.Id), ShouldBeNil)
+			So(f.oas.Archive(f.Oas.VaultId, a.ArchiveId), ShouldBeNil)
+		})
+		Convey("Only one inventory job should run at once", func() {
+			So(submitted.Code, ShouldEqual, http.StatusAccepted)
+			So(job.JobType, ShouldEqual, models.OasJobTypeInventoryRetrieval)
+			So(again.Code, ShouldEqual, http.StatusConflict)
+		})
+		Convey("Orphan and missing archives should be reported", func() {
+			So(len(reconciliations), ShouldEqual, 1)
+			So(reconciliations[0].Stored, ShouldEqual, 2)
+			So(reconciliations[0].Records, ShouldEqual, 2)
+			So(reconciliations[0].Orphans, ShouldEqual, 1)
+			So(reconciliations[0].Missing, ShouldEqual, 1)
+			So(problems, ShouldResemble, map[string]int{
+				stray:       models.ReconcileProblemOrphan,
+				c.ArchiveId: models.ReconcileProblemMissing,
+			})
+			So(problems, ShouldNotContainKey, b.ArchiveId)
+		})
+	})
+}
+
+func TestReconcileBucket(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	storage, err := f.Oss.Storage()
+	f.check(err)
+	kept := f.AddBackup("rb-kept.tar.gz", time.Now().Add(-3*time.Hour))
+	lost := f.AddBackup("rb-lost.tar.gz", time.Now().Add(-3*time.Hour))
+	f.check(storage.DeleteObject(lost.GetFullPath()))
+	archivedLost := f.AddBackup("rb-archived.tar.gz", time.Now().Add(-3*time.Hour))
+	archivedLost.ArchiveId = "rb-archived"
+	f.check(models.UpdateRecord(archivedLost))
+	f.check(storage.DeleteObject(archivedLost.GetFullPath()))
+
+	prefix := models.StoragePrefix(f.AppSet.Name, f.Host.Name, f.Path.Path)
+	stray := prefix + "rb-stray.tar.gz"
+	f.oss.Put(f.Oss.BucketName, stray, []byte("stray"))
+	f.oss.Touch(f.Oss.BucketName, stray, time.Now().Add(-2*time.Hour))
+	// Just uploaded, not recorded yet.
+	f.oss.Put(f.Oss.BucketName, prefix+"rb-new.tar.gz", []byte("new"))
+	// Of a deeper path, not this one.
+	f.oss.Put(f.Oss.BucketName, prefix+"sub/rb-deeper.tar.gz", []byte("deeper"))
+	f.oss.Touch(f.Oss.BucketName, prefix+"sub/rb-deeper.tar.gz", time.Now().Add(-2*time.Hour))
+
+	report, err := policies.ReconcileBucket(f.Oss, false)
+	f.check(err)
+	problems := make(map[string]int)
+	for _, v := range report.Items {
+		problems[v.Key] = v.Problem
+	}
+	strayLeft := f.oss.Get(f.Oss.BucketName, stray)
+
+	url := "/api/v1/oss/" + f.Oss.BucketName + "/reconcile?fix=true"
+	started := serve("POST", url, nil)
+	var reconciliations []*models.Reconciliations
+	for i := 0; i < 50 && len(reconciliations) < 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+		list := serve("GET", "/api/v1/reconciliations?oss="+f.Oss.BucketName, nil)
+		json.Unmarshal(list.Body.Bytes(), &reconciliations)
+	}
+	if len(reconciliations) < 2 {
+		t.Fatal("Bucket is not reconciled with fix")
+	}
+	got := serve("GET", "/api/v1/reconciliations/"+reconciliations[0].Id, nil)
+	var fix models.Reconciliations
+	json.Unmarshal(got.Body.Bytes(), &fix)
+	turned := f.Record(archivedLost.Id)
+
+	Convey("Subject: Reconcile bucket with records\n", t, func() {
+		Convey("Orphan and missing objects should be reported", func() {
+			So(report.Stored, ShouldEqual, 3)
+			So(report.Records, ShouldEqual, 3)
+			So(report.Fixed, ShouldEqual, 0)
+			So(problems, ShouldResemble, map[string]int{
+				stray:                      models.ReconcileProblemOrphan,
+				lost.GetFullPath():         models.ReconcileProblemMissing,
+				archivedLost.GetFullPath(): models.ReconcileProblemMissing,
+			})
+			So(strayLeft, ShouldNotBeNil)
+		})
+		Convey("Problems should be fixed if asked", func() {
+			So(started.Code, ShouldEqual, http.StatusAccepted)
+			So(fix.Fix, ShouldBeTrue)
+			So(fix.Fixed, ShouldEqual, 3)
+			So(len(fix.Items), ShouldEqual, 3)
+			So(f.oss.Get(f.Oss.BucketName, stray), ShouldBeNil)
+			So(f.Record(lost.Id), ShouldBeNil)
+			So(turned.Type, ShouldEqual, models.RecordTypeArchive)
+			So(f.Record(kept.Id), ShouldNotBeNil)
+		})
+	})
+}
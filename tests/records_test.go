@@ -0,0 +1,280 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordHold(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	p := f.AddPolicy(&models.Policies{
+		Target:      models.PolicyTargetBackup,
+		Action:      models.PolicyActionDelete,
+		TargetStart: models.PolicyTargetTimeNow,
+		TargetEnd:   models.PolicyTargetTimeLongLongAgo,
+		Step:        models.PolicyReserveNone,
+	})
+	held := f.AddBackup("hold-a.tar.gz", time.Now().Add(-2*time.Hour))
+	free := f.AddBackup("hold-b.tar.gz", time.Now().Add(-time.Hour))
+
+	wHold := serve("PUT", "/api/v1/records/"+held.Id+"/hold",
+		strings.NewReader(`{"reason": "Audit 2016"}`))
+	wBad := serve("PUT", "/api/v1/records/"+free.Id+"/hold",
+		strings.NewReader(`{}`))
+	decisions, _ := policies.Plan(p)
+	var preview *policies.Decision
+	for _, d := range decisions {
+		if d.Record.Id == held.Id {
+			preview = d
+		}
+	}
+	policies.RunPolicy(p)
+	afterRun := f.Record(held.Id)
+	freeAfterRun := f.Record(free.Id)
+	wDelete := serve("DELETE", "/api/v1/records/"+held.Id, nil)
+	wRelease := serve("DELETE", "/api/v1/records/"+held.Id+"/hold", nil)
+	wDeleteAgain := serve("DELETE", "/api/v1/records/"+held.Id, nil)
+
+	Convey("Subject: Hold record\n", t, func() {
+		Convey("Hold should be set", func() {
+			So(wHold.Code, ShouldEqual, http.StatusOK)
+			So(wBad.Code, ShouldEqual, http.StatusBadRequest)
+			So(afterRun, ShouldNotBeNil)
+			So(afterRun.IsHeld(), ShouldBeTrue)
+			So(afterRun.HoldBy, ShouldEqual, "api")
+		})
+		Convey("Preview should show record is held", func() {
+			So(preview, ShouldNotBeNil)
+			So(preview.Held, ShouldBeTrue)
+			So(preview.Action, ShouldEqual, policies.DecisionKeep)
+			So(preview.Reason, ShouldContainSubstring, "Audit 2016")
+		})
+		Convey("Policy should delete only record not held", func() {
+			So(freeAfterRun, ShouldBeNil)
+			So(f.oss.Get(f.Oss.BucketName, held.GetFullPath()), ShouldNotBeNil)
+		})
+		Convey("Held record cannot be deleted until released", func() {
+			So(wDelete.Code, ShouldEqual, http.StatusConflict)
+			So(wRelease.Code, ShouldEqual, http.StatusNoContent)
+			So(wDeleteAgain.Code, ShouldEqual, http.StatusNoContent)
+		})
+	})
+}
+
+func TestRecordHeldMeanwhile(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	backup := f.AddBackup("meanwhile-a.tar.gz", time.Now().Add(-2*time.Hour))
+	stale := f.Record(backup.Id)
+	serve("PUT", "/api/v1/records/"+backup.Id+"/hold",
+		strings.NewReader(`{"reason": "Audit"}`))
+	errDelete := models.DeleteRecord(stale)
+
+	// Deleting archive failed, and is retried after record is held.
+	archived := f.AddBackup("meanwhile-b.tar.gz", time.Now().Add(-2*time.Hour))
+	archived.Type = models.RecordTypeArchive
+	archived.ArchiveId = f.oas.PutArchive(f.Oas.VaultId, "meanwhile", []byte("b"))
+	f.check(models.UpdateRecord(archived))
+	job := &models.OasJobs{
+		Vault:     f.Oas,
+		JobId:     archived.ArchiveId,
+		JobType:   models.OasJobTypeDeleteArchive,
+		ArchiveId: archived.ArchiveId,
+		Records:   archived,
+	}
+	f.must(models.AddOasJobs(job))
+	job.State = models.OasJobStatePending
+	job.NextRetry = time.Now().Add(-time.Minute)
+	f.check(models.UpdateOasJobs(job))
+	serve("PUT", "/api/v1/records/"+archived.Id+"/hold",
+		strings.NewReader(`{"reason": "Audit"}`))
+	policies.SweepOasJobs()
+	jobs, err := models.GetOasJobs(&models.OasJobs{Id: job.Id}, 0, 0)
+	f.check(err)
+
+	Convey("Subject: Hold record after it is loaded\n", t, func() {
+		Convey("Record loaded before hold should not be deleted", func() {
+			So(errDelete, ShouldEqual, models.ErrorRecordHeld)
+			So(f.Record(backup.Id), ShouldNotBeNil)
+		})
+		Convey("Retried archive deletion should be cancelled", func() {
+			So(len(jobs), ShouldEqual, 1)
+			So(jobs[0].State, ShouldEqual, models.OasJobStateCancelled)
+			So(f.oas.Archive(f.Oas.VaultId, archived.ArchiveId), ShouldNotBeNil)
+			So(f.Record(archived.Id), ShouldNotBeNil)
+			So(f.Record(archived.Id).ArchiveId, ShouldEqual, archived.ArchiveId)
+		})
+	})
+}
+
+func TestRecordHoldExpire(t *testing.T) {
+	r := &models.Records{
+		Hold:       true,
+		HoldExpire: time.Now().Add(-time.Minute),
+	}
+	Convey("Subject: Expired hold\n", t, func() {
+		So(r.IsHeld(), ShouldBeFalse)
+	})
+}
+
+func TestRecoverTo(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	staging := &models.Paths{
+		Path:      "/staging/" + f.Host.Name,
+		BackupSet: f.BackupSet,
+		AppSet:    []*models.AppSets{f.AppSet},
+	}
+	f.must(models.AddPath(staging))
+	stagingHost := f.AddHost(staging)
+	url := "/api/v1/records/%s/recover?host=%s&target=%s"
+
+	record := f.AddBackup("d.tar.gz", time.Now())
+	w := serve("GET", fmt.Sprintf(url,
+		record.Id, stagingHost.Name, staging.Path+"/d"), nil)
+	signals := models.GetSignals(stagingHost.Id)
+	notPermitted := serve("GET", fmt.Sprintf(url,
+		record.Id, stagingHost.Name, ""), nil)
+	outside := serve("GET", fmt.Sprintf(url,
+		record.Id, "", staging.Path+"/../etc"), nil)
+	noHost := serve("GET", fmt.Sprintf(url,
+		record.Id, "nohost", ""), nil)
+	own := serve("GET", "/api/v1/records/"+record.Id+"/recover", nil)
+	ownSignals := models.GetSignals(f.Host.Id)
+
+	// Archive is pushed back to OSS first, signal goes out after.
+	archive := archived(f, "e.tar.gz")
+	archive.Type = models.RecordTypeArchive
+	f.check(models.UpdateRecord(archive))
+	wArchive := serve("GET", fmt.Sprintf(url,
+		archive.Id, stagingHost.Name, staging.Path), nil)
+	for _, v := range f.Jobs() {
+		if v.JobType == models.OasJobTypePushToOSS {
+			f.oas.Complete(v.JobId)
+		}
+	}
+	policies.SweepOasJobs()
+	archiveSignals := models.GetSignals(stagingHost.Id)
+
+	Convey("Subject: Recover record to another host\n", t, func() {
+		Convey("Backup should be sent to target on staging host", func() {
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(len(signals), ShouldEqual, 1)
+			So(signals[0]["path"], ShouldEqual, record.GetFullPath())
+			So(signals[0]["target"], ShouldEqual, staging.Path+"/d")
+		})
+		Convey("Target should be a path of host", func() {
+			So(notPermitted.Code, ShouldEqual, http.StatusBadRequest)
+			So(outside.Code, ShouldEqual, http.StatusBadRequest)
+			So(noHost.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Default should be where record is from", func() {
+			So(own.Code, ShouldEqual, http.StatusOK)
+			So(len(ownSignals), ShouldEqual, 1)
+			So(ownSignals[0]["target"], ShouldEqual, f.Path.Path)
+		})
+		Convey("Archive should be sent to staging host when in OSS", func() {
+			So(wArchive.Code, ShouldEqual, http.StatusAccepted)
+			So(len(archiveSignals), ShouldEqual, 2)
+			So(archiveSignals[1]["path"], ShouldEqual, archive.GetFullPath())
+			So(archiveSignals[1]["target"], ShouldEqual, staging.Path)
+		})
+	})
+}
+
+func TestRestoreTarget(t *testing.T) {
+	record := &models.Records{
+		Host: &models.Hosts{Id: "a"},
+		Path: &models.Paths{Path: "/var/data"},
+	}
+	root := &models.Hosts{Id: "b", Paths: []*models.Paths{{Path: "/"}}}
+	slash := &models.Hosts{Id: "c", Paths: []*models.Paths{{Path: "/srv/"}}}
+	underRoot, rootErr := record.RestoreTarget(root, "/tmp/x")
+	underSlash, slashErr := record.RestoreTarget(slash, "/srv/x/")
+	_, outsideErr := record.RestoreTarget(slash, "/srvx")
+	_, escapeErr := record.RestoreTarget(slash, "/srv/../etc")
+
+	Convey("Subject: Where records may be restored\n", t, func() {
+		Convey("Root should permit everything", func() {
+			So(rootErr, ShouldBeNil)
+			So(underRoot, ShouldEqual, "/tmp/x")
+		})
+		Convey("Path ending in slash should permit what is under it", func() {
+			So(slashErr, ShouldBeNil)
+			So(underSlash, ShouldEqual, "/srv/x")
+			So(outsideErr, ShouldEqual, models.ErrorRestoreTarget)
+			So(escapeErr, ShouldEqual, models.ErrorRestoreTarget)
+		})
+	})
+}
+
+func TestVerifyRecords(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	ok := f.AddBackup("vr-ok.tar.gz", time.Now().Add(-time.Hour))
+	changed := f.AddBackup("vr-changed.tar.gz", time.Now().Add(-time.Hour))
+	lost := f.AddBackup("vr-lost.tar.gz", time.Now().Add(-time.Hour))
+
+	policies.VerifyRecords()
+	first := f.Record(changed.Id)
+	f.oss.Put(f.Oss.BucketName, changed.GetFullPath(), []byte("changed"))
+	storage, err := f.Oss.Storage()
+	f.check(err)
+	f.check(storage.DeleteObject(lost.GetFullPath()))
+	policies.VerifyRecords()
+	ok, changed, lost = f.Record(ok.Id), f.Record(changed.Id), f.Record(lost.Id)
+
+	// Held after it was loaded for verifying.
+	stale := f.Record(ok.Id)
+	held := f.Record(ok.Id)
+	held.Hold = true
+	held.HoldReason = "audit"
+	f.check(models.UpdateRecord(held))
+	f.check(policies.VerifyRecord(storage, stale))
+	held = f.Record(ok.Id)
+
+	w := serve("GET", fmt.Sprintf("/api/v1/records?host=%s&verify=%d",
+		f.Host.Name, models.RecordVerifyMismatch), nil)
+	var mismatches []*models.Records
+	json.Unmarshal(w.Body.Bytes(), &mismatches)
+
+	Convey("Subject: Verify backups in storage\n", t, func() {
+		Convey("Metadata should be taken from storage at first", func() {
+			So(first.VerifyState, ShouldEqual, models.RecordVerifyOk)
+			So(ok.VerifyState, ShouldEqual, models.RecordVerifyOk)
+			So(ok.Size, ShouldEqual, len("data of vr-ok.tar.gz"))
+			So(ok.Md5, ShouldHaveLength, 32)
+			So(ok.Crc64, ShouldNotBeBlank)
+			So(ok.ETag, ShouldEqual, strings.ToUpper(ok.Md5))
+			So(ok.StorageClass, ShouldEqual, "Standard")
+			So(ok.VerifiedTime.IsZero(), ShouldBeFalse)
+		})
+		Convey("Object not as recorded should be flagged", func() {
+			So(changed.VerifyState, ShouldEqual, models.RecordVerifyMismatch)
+			So(changed.VerifyMessage, ShouldContainSubstring, "size")
+			So(changed.VerifyMessage, ShouldContainSubstring, "md5")
+			So(changed.Md5, ShouldEqual, first.Md5)
+			So(lost.VerifyState, ShouldEqual, models.RecordVerifyMissing)
+		})
+		Convey("Verifying should not touch what it does not check", func() {
+			So(held.Hold, ShouldBeTrue)
+			So(held.HoldReason, ShouldEqual, "audit")
+			So(held.VerifyState, ShouldEqual, models.RecordVerifyOk)
+		})
+		Convey("Records should be filtered by verification", func() {
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(len(mismatches), ShouldEqual, 1)
+			So(mismatches[0].Id, ShouldEqual, changed.Id)
+		})
+	})
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUsageReport(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	backup := f.AddBackup("u-backup.tar.gz", time.Now().Add(-2*time.Hour))
+	backup.Size = 2 << 30
+	f.check(models.UpdateRecord(backup))
+	// Archived, and still in OSS.
+	both := f.AddBackup("u-both.tar.gz", time.Now().Add(-time.Hour))
+	both.Size = 1 << 30
+	both.ArchiveId = "u-both"
+	f.check(models.UpdateRecord(both))
+
+	var usage []*models.UsageSnapshots
+	w := serve("GET", "/api/v1/reports/usage?by=host", nil)
+	json.Unmarshal(w.Body.Bytes(), &usage)
+	tiers := make(map[int]*models.UsageSnapshots)
+	for _, v := range usage {
+		if v.Name == f.Host.Name {
+			tiers[v.Tier] = v
+		}
+	}
+	if len(tiers) != 2 {
+		t.Fatal("Usage of host is missing:", w.Body.String())
+	}
+	badDimension := serve("GET", "/api/v1/reports/usage?by=nothing", nil)
+	var total []*models.UsageSnapshots
+	json.Unmarshal(
+		serve("GET", "/api/v1/reports/usage?by=total", nil).Body.Bytes(), &total)
+
+	policies.SnapshotUsage()
+	policies.SnapshotUsage()
+	var snapshots []*models.UsageSnapshots
+	w = serve("GET", "/api/v1/reports/usage/history?by=appSet&name="+
+		f.AppSet.Name, nil)
+	json.Unmarshal(w.Body.Bytes(), &snapshots)
+	last, err := models.LastUsageDate()
+	f.check(err)
+
+	Convey("Subject: Report usage of storage\n", t, func() {
+		Convey("Usage should be summed up by tier", func() {
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(tiers[models.UsageTierOss].Objects, ShouldEqual, 2)
+			So(tiers[models.UsageTierOss].Bytes, ShouldEqual, 3<<30)
+			So(tiers[models.UsageTierOss].Cost, ShouldAlmostEqual,
+				3*models.UsagePrice(models.UsageTierOss))
+			So(tiers[models.UsageTierOas].Objects, ShouldEqual, 1)
+			So(tiers[models.UsageTierOas].Bytes, ShouldEqual, 1<<30)
+			So(badDimension.Code, ShouldEqual, http.StatusBadRequest)
+			So(len(total), ShouldEqual, 2)
+			So(total[0].Key, ShouldEqual, "")
+			So(total[0].Objects, ShouldBeGreaterThanOrEqualTo, 2)
+		})
+		Convey("Snapshot should be taken once a day", func() {
+			So(len(snapshots), ShouldEqual, 2)
+			So(snapshots[0].Tier, ShouldEqual, models.UsageTierOss)
+			So(snapshots[0].Bytes, ShouldEqual, 3<<30)
+			So(snapshots[1].Tier, ShouldEqual, models.UsageTierOas)
+			So(snapshots[1].Date.Format("2006-01-02"), ShouldEqual,
+				time.Now().Format("2006-01-02"))
+			So(last.Format("2006-01-02"), ShouldEqual,
+				time.Now().Format("2006-01-02"))
+		})
+	})
+}
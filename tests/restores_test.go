@@ -0,0 +1,324 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/models"
+	"github.com/ModuleAB/ModuleAB/server/policies"
+
+	"github.com/astaxie/beego"
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// restoreOf reads restore of response, and its job of host.
+func restoreOf(w *httptest.ResponseRecorder, host *models.Hosts) (*models.Restores, *models.RestoreJobs) {
+	restore := new(models.Restores)
+	json.Unmarshal(w.Body.Bytes(), restore)
+	for _, v := range restore.Jobs {
+		if v.Host != nil && v.Host.Id == host.Id {
+			return restore, v
+		}
+	}
+	return restore, nil
+}
+
+func TestRestoreAppSet(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	path := "/api/v1/appSets/" + f.AppSet.Name + "/restore"
+	at := time.Now().Add(-30 * time.Minute)
+
+	// Latest record of host is archived, of other host is in OSS.
+	f.AddBackup("a-old.tar.gz", time.Now().Add(-3*time.Hour))
+	archive := archived(f, "a.tar.gz")
+	archive.Type = models.RecordTypeArchive
+	f.check(models.UpdateRecord(archive))
+	other := f.AddHost()
+	backup := f.AddBackupOn(other, "b.tar.gz", time.Now().Add(-2*time.Hour))
+	f.AddBackupOn(other, "b-new.tar.gz", time.Now())
+
+	badTime := serve("POST", path+"?at=yesterday", nil)
+	tooEarly := serve("POST", path+"?at="+
+		url.QueryEscape(at.Add(-24*time.Hour).Format(time.RFC3339)), nil)
+	noAppSet := serve("POST", "/api/v1/appSets/noappset/restore", nil)
+	w := serve("POST", path+"?at="+
+		url.QueryEscape(at.Format(time.RFC3339)), nil)
+	started, archiveJob := restoreOf(w, f.Host)
+	_, backupJob := restoreOf(w, other)
+	if archiveJob == nil || backupJob == nil {
+		t.Fatal("Restore jobs are missing:", w.Body.String())
+	}
+	f.check(models.AckSignal(other.Id, backupJob.SignalId))
+
+	for _, v := range f.Jobs() {
+		if v.JobType == models.OasJobTypePushToOSS {
+			f.oas.Complete(v.JobId)
+		}
+	}
+	policies.SweepOasJobs()
+	_, retrievedJob := restoreOf(serve("GET", path+"/"+started.Id, nil), f.Host)
+	signals := models.GetSignals(f.Host.Id)
+	f.check(models.FailSignal(f.Host.Id, retrievedJob.SignalId, "Disk full"))
+	done := serve("GET", path+"/"+started.Id, nil)
+	finished, failedJob := restoreOf(done, f.Host)
+	_, doneJob := restoreOf(done, other)
+	list := serve("GET", path, nil)
+	var restores []*models.Restores
+	json.Unmarshal(list.Body.Bytes(), &restores)
+
+	Convey("Subject: Restore app set to a point in time\n", t, func() {
+		Convey("Bad requests should be refused", func() {
+			So(badTime.Code, ShouldEqual, http.StatusBadRequest)
+			So(tooEarly.Code, ShouldEqual, http.StatusNotFound)
+			So(noAppSet.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Latest record before time should be restored", func() {
+			So(w.Code, ShouldEqual, http.StatusAccepted)
+			So(len(started.Jobs), ShouldEqual, 2)
+			So(started.Stage, ShouldEqual, models.RestoreStageRetrieving)
+			So(archiveJob.Record.Id, ShouldEqual, archive.Id)
+			So(archiveJob.Stage, ShouldEqual, models.RestoreStageRetrieving)
+			So(backupJob.Record.Id, ShouldEqual, backup.Id)
+			So(backupJob.Stage, ShouldEqual, models.RestoreStageSignalled)
+		})
+		Convey("Archive should be signalled when in OSS", func() {
+			So(retrievedJob.Stage, ShouldEqual, models.RestoreStageSignalled)
+			So(retrievedJob.InOssTime.IsZero(), ShouldBeFalse)
+			So(len(signals), ShouldEqual, 1)
+			So(signals[0]["id"], ShouldEqual, retrievedJob.SignalId)
+			So(signals[0]["target"], ShouldEqual, f.Path.Path)
+		})
+		Convey("Restore should follow its jobs", func() {
+			So(done.Code, ShouldEqual, http.StatusOK)
+			So(doneJob.Stage, ShouldEqual, models.RestoreStageDone)
+			So(failedJob.Stage, ShouldEqual, models.RestoreStageFailed)
+			So(failedJob.Error, ShouldEqual, "Disk full")
+			So(finished.Stage, ShouldEqual, models.RestoreStageFailed)
+			So(finished.Operator, ShouldEqual, "api")
+			So(len(restores), ShouldEqual, 1)
+		})
+	})
+}
+
+// feedUntil reads restore job with id from feed till it is at stage, nil
+// if it is not in time.
+func feedUntil(feed *websocket.Conn, id string, stage int) *models.RestoreJobs {
+	for {
+		feed.SetReadDeadline(time.Now().Add(2 * time.Second))
+		j := new(models.RestoreJobs)
+		if feed.ReadJSON(j) != nil {
+			return nil
+		}
+		if j.Id == id && j.Stage == stage {
+			return j
+		}
+	}
+}
+
+func TestRestoreJobFeed(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	server := httptest.NewServer(beego.BeeApp.Handlers)
+	defer server.Close()
+	record := f.AddBackup("f.tar.gz", time.Now())
+
+	a := connectAgent(t, server, f.Host.Name)
+	defer a.conn.Close()
+	a.Send(models.EnvelopeKindHello, "", &models.HelloBody{
+		Versions:     []int{1},
+		Capabilities: []string{"download"},
+	})
+	a.NextEnvelope()
+	feedPath := "/api/v1/restoreJobs/ws"
+	feed, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(server.URL, "http")+feedPath, signed(feedPath),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+
+	w := serve("GET", "/api/v1/records/"+record.Id+"/recover", nil)
+	var recovered map[string]string
+	json.Unmarshal(w.Body.Bytes(), &recovered)
+	id := recovered["restore_job_id"]
+	command := a.NextEnvelope()
+	signalled := feedUntil(feed, id, models.RestoreStageSignalled)
+	a.Send(models.EnvelopeKindProgress, command.Id, &models.ProgressBody{Percent: 40})
+	downloading := feedUntil(feed, id, models.RestoreStageDownloading)
+	a.Send(models.EnvelopeKindDone, command.Id, &models.DoneBody{})
+	finished := feedUntil(feed, id, models.RestoreStageDone)
+	got := new(models.RestoreJobs)
+	json.Unmarshal(serve("GET", "/api/v1/restoreJobs/"+id, nil).Body.Bytes(), got)
+	var done []*models.RestoreJobs
+	json.Unmarshal(serve("GET", "/api/v1/restoreJobs/?stage="+
+		fmt.Sprint(models.RestoreStageDone), nil).Body.Bytes(), &done)
+	found := false
+	for _, v := range done {
+		found = found || v.Id == id
+	}
+
+	Convey("Subject: Restore job feed\n", t, func() {
+		Convey("Feed should tell each stage", func() {
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(command.Id, ShouldEqual, recovered["job_id"])
+			So(signalled, ShouldNotBeNil)
+			So(downloading, ShouldNotBeNil)
+			So(downloading.Progress, ShouldEqual, 40)
+			So(finished, ShouldNotBeNil)
+		})
+		Convey("Job should have time of each stage", func() {
+			So(got.Stage, ShouldEqual, models.RestoreStageDone)
+			So(got.Progress, ShouldEqual, 100)
+			So(got.Record.Id, ShouldEqual, record.Id)
+			So(got.RetrievingTime.IsZero(), ShouldBeTrue)
+			So(got.SignalledTime.IsZero(), ShouldBeFalse)
+			So(got.DownloadingTime.IsZero(), ShouldBeFalse)
+			So(got.DoneTime.IsZero(), ShouldBeFalse)
+			So(found, ShouldBeTrue)
+		})
+	})
+}
+
+func TestRestoreTests(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	record := f.AddBackup("rt.tar.gz", time.Now().Add(-time.Hour))
+	record.Md5 = "0123456789abcdef0123456789abcdef"
+	f.check(models.UpdateRecord(record))
+	testHost := f.AddHost()
+	path := "/api/v1/restoreTests?backupSet=" + f.BackupSet.Name
+
+	noHost := serve("POST", path+"&host=nohost", nil)
+	noBackupSet := serve("POST", "/api/v1/restoreTests?backupSet=nobackupset", nil)
+	var passed, failed []*models.RestoreTests
+	w := serve("POST", path+"&host="+testHost.Name, nil)
+	json.Unmarshal(w.Body.Bytes(), &passed)
+	signals := models.GetSignals(testHost.Id)
+	if len(passed) != 1 || len(signals) != 1 {
+		t.Fatal("Restore test is not started:", w.Body.String())
+	}
+	stored, err := models.GetSignalRecord(testHost.Id, passed[0].SignalId)
+	f.check(err)
+
+	// Agent which can verify does it, and says done.
+	server := httptest.NewServer(beego.BeeApp.Handlers)
+	defer server.Close()
+	a := connectAgent(t, server, testHost.Name)
+	a.Send(models.EnvelopeKindHello, "", &models.HelloBody{
+		Versions:     []int{1},
+		Capabilities: []string{"verify"},
+	})
+	a.NextEnvelope()
+	command := a.NextEnvelope()
+	a.Send(models.EnvelopeKindDone, command.Id, &models.DoneBody{})
+	waitSignal(testHost.Id, passed[0].SignalId, models.SignalStatusAcked)
+	a.conn.Close()
+
+	w = serve("POST", path+"&host="+testHost.Name, nil)
+	json.Unmarshal(w.Body.Bytes(), &failed)
+	if len(failed) != 1 {
+		t.Fatal("Restore test is not started:", w.Body.String())
+	}
+	f.check(models.FailSignal(testHost.Id, failed[0].SignalId, "md5 differs"))
+
+	// Old agent acks without verifying.
+	var legacy []*models.RestoreTests
+	w = serve("POST", path+"&host="+testHost.Name, nil)
+	json.Unmarshal(w.Body.Bytes(), &legacy)
+	if len(legacy) != 1 {
+		t.Fatal("Restore test is not started:", w.Body.String())
+	}
+	s, err := models.GetSignalRecord(testHost.Id, legacy[0].SignalId)
+	f.check(err)
+	f.check(models.DeliverSignal(s, 0))
+	f.check(models.AckSignal(testHost.Id, legacy[0].SignalId))
+
+	var ofRecord []*models.RestoreTests
+	w = serve("GET", "/api/v1/restoreTests?record="+record.Id, nil)
+	json.Unmarshal(w.Body.Bytes(), &ofRecord)
+	results := make(map[string]*models.RestoreTests)
+	for _, v := range ofRecord {
+		results[v.Id] = v
+	}
+	var summaries []*models.RestoreTestSummaries
+	w = serve("GET", "/api/v1/restoreTests/summary", nil)
+	json.Unmarshal(w.Body.Bytes(), &summaries)
+	var summary *models.RestoreTestSummaries
+	for _, v := range summaries {
+		if v.BackupSet.Id == f.BackupSet.Id {
+			summary = v
+		}
+	}
+	if summary == nil {
+		t.Fatal("Backup set is not summed up:", w.Body.String())
+	}
+
+	Convey("Subject: Test restoring backups\n", t, func() {
+		Convey("Test host should be signalled to verify a record", func() {
+			So(noHost.Code, ShouldEqual, http.StatusBadRequest)
+			So(noBackupSet.Code, ShouldEqual, http.StatusNotFound)
+			So(signals[0]["type"], ShouldEqual, float64(models.SignalTypeVerify))
+			So(signals[0]["path"], ShouldEqual, record.GetFullPath())
+			So(signals[0]["md5"], ShouldEqual, record.Md5)
+			So(signals[0]["url"], ShouldNotBeBlank)
+			So(stored.Data, ShouldNotContainSubstring, "url")
+			So(command.Type, ShouldEqual, "verify")
+		})
+		Convey("Results should be kept per record", func() {
+			So(len(ofRecord), ShouldEqual, 3)
+			So(results, ShouldContainKey, passed[0].Id)
+			So(results, ShouldContainKey, failed[0].Id)
+			So(results, ShouldContainKey, legacy[0].Id)
+			So(results[passed[0].Id].Result, ShouldEqual, models.RestoreTestPassed)
+			So(results[failed[0].Id].Result, ShouldEqual, models.RestoreTestFailed)
+			So(results[failed[0].Id].Error, ShouldEqual, "md5 differs")
+		})
+		Convey("Ack without verifying should not pass", func() {
+			So(results[legacy[0].Id].Result, ShouldEqual, models.RestoreTestFailed)
+			So(results[legacy[0].Id].Error, ShouldContainSubstring, "version 0")
+		})
+		Convey("Results should be summed up per backup set", func() {
+			So(summary.Tests, ShouldEqual, 3)
+			So(summary.Passed, ShouldEqual, 1)
+			So(summary.Failed, ShouldEqual, 2)
+			So(summary.LastResult, ShouldEqual, models.RestoreTestFailed)
+		})
+	})
+}
+
+func TestRestoreTestExpired(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	f.AddBackup("rt-expired.tar.gz", time.Now().Add(-time.Hour))
+	// Test host stays offline until its signal expires.
+	testHost := f.AddHost()
+	var tests []*models.RestoreTests
+	w := serve("POST", "/api/v1/restoreTests?backupSet="+f.BackupSet.Name+
+		"&host="+testHost.Name, nil)
+	json.Unmarshal(w.Body.Bytes(), &tests)
+	if len(tests) != 1 {
+		t.Fatal("Restore test is not started:", w.Body.String())
+	}
+	s, err := models.GetSignalRecord(testHost.Id, tests[0].SignalId)
+	f.check(err)
+	s.ExpireTime = time.Now().Add(-time.Minute)
+	f.check(models.UpdateSignal(s))
+	models.ExpireSignals()
+	got, err := models.GetRestoreTests(&models.RestoreTests{Id: tests[0].Id}, 0, 0)
+	f.check(err)
+
+	Convey("Subject: Restore test of signal expired\n", t, func() {
+		So(len(got), ShouldEqual, 1)
+		So(got[0].Result, ShouldEqual, models.RestoreTestFailed)
+		So(got[0].Error, ShouldEqual, "Signal expired")
+	})
+}
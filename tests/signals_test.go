@@ -0,0 +1,319 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	"github.com/astaxie/beego"
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// agent is an agent connected to signal websocket of a host.
+type agent struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func connectAgent(t *testing.T, server *httptest.Server, host string) *agent {
+	path := "/api/v1/client/signal/" + host + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(server.URL, "http")+path, signed(path),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &agent{t: t, conn: conn}
+}
+
+// Next reads next signal, empty if none comes in time.
+func (a *agent) Next() models.Signal {
+	a.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var s models.Signal
+	err := a.conn.ReadJSON(&s)
+	if err != nil {
+		return models.Signal{}
+	}
+	return s
+}
+
+func (a *agent) Reply(reply string) {
+	err := a.conn.WriteMessage(websocket.TextMessage, []byte(reply))
+	if err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+// Send writes an envelope of current version.
+func (a *agent) Send(kind, id string, body interface{}) {
+	e, err := models.NewEnvelope(kind, id, body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	err = a.conn.WriteJSON(e)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+// NextEnvelope reads next envelope, empty if none comes in time.
+func (a *agent) NextEnvelope() *models.Envelope {
+	a.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	e := new(models.Envelope)
+	a.conn.ReadJSON(e)
+	return e
+}
+
+// waitSignal waits a while for signal to be in status, as agent's replies
+// are handled on their own.
+func waitSignal(hostId, id string, status int) *models.Signals {
+	return waitSignalFor(hostId, id, func(s *models.Signals) bool {
+		return s.Status == status
+	})
+}
+
+func waitSignalFor(hostId, id string, cond func(*models.Signals) bool) *models.Signals {
+	var s *models.Signals
+	for i := 0; i < 100; i++ {
+		s, _ = models.GetSignalRecord(hostId, id)
+		if s != nil && cond(s) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return s
+}
+
+func TestSignalRedelivery(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	server := httptest.NewServer(beego.BeeApp.Handlers)
+	defer server.Close()
+	hostId := f.Host.Id
+
+	// Added while agent is offline.
+	id1, err := models.AddSignal(hostId, models.Signal{"path": "/a"})
+	f.must(id1, err)
+	f.check(models.NotifySignal(hostId, id1))
+
+	a := connectAgent(t, server, f.Host.Name)
+	got1 := a.Next()
+	a.Reply("NACK " + id1 + " disk full")
+	again1 := a.Next()
+	a.Reply("ACK " + id1)
+	acked := waitSignal(hostId, id1, models.SignalStatusAcked)
+
+	id2, err := models.AddSignal(hostId, models.Signal{"path": "/b"})
+	f.must(id2, err)
+	f.check(models.NotifySignal(hostId, id2))
+	got2 := a.Next()
+	a.conn.Close()
+
+	// Not acked before disconnecting, so sent again.
+	b := connectAgent(t, server, f.Host.Name)
+	again2 := b.Next()
+	b.conn.Close()
+	delivered := waitSignal(hostId, id2, models.SignalStatusDelivered)
+
+	w := serve("GET", "/api/v1/client/signal/"+f.Host.Name+"/history", nil)
+	var history []*models.Signals
+	json.Unmarshal(w.Body.Bytes(), &history)
+
+	Convey("Subject: Signal redelivery\n", t, func() {
+		So(got1["id"], ShouldEqual, id1)
+		So(got1["path"], ShouldEqual, "/a")
+		So(again1["id"], ShouldEqual, id1)
+		So(acked.Status, ShouldEqual, models.SignalStatusAcked)
+		So(acked.Attempts, ShouldEqual, 2)
+		So(acked.Error, ShouldEqual, "disk full")
+		So(got2["id"], ShouldEqual, id2)
+		So(again2["id"], ShouldEqual, id2)
+		So(delivered.Attempts, ShouldEqual, 2)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(len(history), ShouldEqual, 2)
+		So(history[0].Id, ShouldEqual, id2)
+		So(history[1].Status, ShouldEqual, models.SignalStatusAcked)
+	})
+}
+
+func TestLegacyAgent(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	server := httptest.NewServer(beego.BeeApp.Handlers)
+	defer server.Close()
+	hostId := f.Host.Id
+
+	backupId, err := models.AddSignal(hostId, models.MakeBackupSignal(f.Path))
+	f.must(backupId, err)
+	downloadId, err := models.AddSignal(
+		hostId, models.MakeDownloadSignal("/a.tar.gz", f.BackupSet.Oss, ""),
+	)
+	f.must(downloadId, err)
+
+	// Agent says no hello, and acks whatever it gets.
+	a := connectAgent(t, server, f.Host.Name)
+	got := a.Next()
+	nothingMore := a.Next()
+	a.Reply("ACK " + downloadId)
+	acked := waitSignal(hostId, downloadId, models.SignalStatusAcked)
+	held, _ := models.GetSignalRecord(hostId, backupId)
+	a.Reply("ACK " + backupId)
+	failed := waitSignal(hostId, backupId, models.SignalStatusFailed)
+	a.conn.Close()
+
+	Convey("Subject: Agent of protocol version 0\n", t, func() {
+		Convey("Only signals it knows should be sent", func() {
+			So(got["id"], ShouldEqual, downloadId)
+			So(nothingMore, ShouldBeEmpty)
+			So(acked.Status, ShouldEqual, models.SignalStatusAcked)
+			So(held.Status, ShouldEqual, models.SignalStatusPending)
+			So(held.Attempts, ShouldEqual, 0)
+		})
+		Convey("Ack of signal it cannot do should fail it", func() {
+			So(failed.Status, ShouldEqual, models.SignalStatusFailed)
+			So(failed.Error, ShouldStartWith, models.SignalErrorUnsupported)
+		})
+	})
+}
+
+func TestSignalRetryAndExpire(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	hostId := f.Host.Id
+
+	id1, err := models.AddSignal(hostId, models.Signal{"path": "/a"})
+	f.must(id1, err)
+	for i := 0; i < 3; i++ {
+		s, err := models.GetSignalRecord(hostId, id1)
+		f.check(err)
+		f.check(models.DeliverSignal(s, 0))
+		f.check(models.NackSignal(hostId, id1, "no space"))
+	}
+	failed, _ := models.GetSignalRecord(hostId, id1)
+
+	id2, err := models.AddSignal(hostId, models.Signal{"path": "/b"})
+	f.must(id2, err)
+	s, err := models.GetSignalRecord(hostId, id2)
+	f.check(err)
+	s.ExpireTime = time.Now().Add(-time.Minute)
+	f.check(models.UpdateSignal(s))
+	pending, err := models.PendingSignals(hostId)
+	f.check(err)
+	expired, _ := models.GetSignalRecord(hostId, id2)
+
+	Convey("Subject: Signal retry limit and expiry\n", t, func() {
+		So(failed.Status, ShouldEqual, models.SignalStatusFailed)
+		So(failed.Attempts, ShouldEqual, 3)
+		So(failed.Error, ShouldEqual, "no space")
+		So(models.AckSignal(hostId, id1), ShouldEqual, models.ErrorSignalDone)
+		So(len(pending), ShouldEqual, 0)
+		So(expired.Status, ShouldEqual, models.SignalStatusExpired)
+	})
+}
+
+func TestSignalProtocol(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	server := httptest.NewServer(beego.BeeApp.Handlers)
+	defer server.Close()
+	hostId := f.Host.Id
+
+	id1, err := models.AddSignal(
+		hostId, models.MakeDownloadSignal("/a.tar.gz", f.BackupSet.Oss, ""),
+	)
+	f.must(id1, err)
+	id2, err := models.AddSignal(hostId, models.Signal{})
+	f.must(id2, err)
+
+	a := connectAgent(t, server, f.Host.Name)
+	a.Send(models.EnvelopeKindHello, "", &models.HelloBody{
+		Versions:     []int{1, 2},
+		Capabilities: []string{"download", "teleport"},
+	})
+	welcome := new(models.WelcomeBody)
+	welcomeEnvelope := a.NextEnvelope()
+	welcomeEnvelope.Decode(welcome)
+	command := a.NextEnvelope()
+	download := new(models.DownloadCommand)
+	command.Decode(download)
+
+	a.Send(models.EnvelopeKindProgress, id1, &models.ProgressBody{
+		Percent: 50, Message: "halfway",
+	})
+	progress := waitSignalFor(hostId, id1, func(s *models.Signals) bool {
+		return s.Progress == 50
+	})
+	a.Send(models.EnvelopeKindDone, id1, &models.DoneBody{Message: "ok"})
+	done := waitSignal(hostId, id1, models.SignalStatusAcked)
+
+	id3, err := models.AddSignal(
+		hostId, models.MakeDownloadSignal("/b.tar.gz", f.BackupSet.Oss, ""),
+	)
+	f.must(id3, err)
+	f.check(models.NotifySignal(hostId, id3))
+	command3 := a.NextEnvelope()
+	a.Send(models.EnvelopeKindError, id3, &models.ErrorBody{
+		Code: models.SignalErrorFailed, Message: "no space",
+	})
+	failed := waitSignal(hostId, id3, models.SignalStatusFailed)
+	a.conn.Close()
+	// Agent cannot do nothing signal, so it is not sent.
+	nothing, _ := models.GetSignalRecord(hostId, id2)
+
+	b := connectAgent(t, server, f.Host.Name)
+	b.Send(models.EnvelopeKindHello, "", &models.HelloBody{
+		Versions: []int{7},
+	})
+	refused := b.NextEnvelope()
+	refusal := new(models.ErrorBody)
+	refused.Decode(refusal)
+	b.conn.Close()
+
+	Convey("Subject: Signal protocol version 1\n", t, func() {
+		So(welcomeEnvelope.Kind, ShouldEqual, models.EnvelopeKindWelcome)
+		So(welcome.Version, ShouldEqual, 1)
+		So(welcome.Capabilities, ShouldResemble, []string{"download"})
+		So(command.Kind, ShouldEqual, models.EnvelopeKindCommand)
+		So(command.Id, ShouldEqual, id1)
+		So(command.Type, ShouldEqual, "download")
+		So(download.Path, ShouldEqual, "/a.tar.gz")
+		So(download.URL, ShouldNotBeEmpty)
+		So(progress.Message, ShouldEqual, "halfway")
+		So(done.Progress, ShouldEqual, 100)
+		So(done.Message, ShouldEqual, "ok")
+		So(command3.Id, ShouldEqual, id3)
+		So(failed.Error, ShouldEqual, "failed: no space")
+		So(failed.Attempts, ShouldEqual, 1)
+		So(nothing.Status, ShouldEqual, models.SignalStatusPending)
+		So(nothing.Attempts, ShouldEqual, 0)
+		So(refused.Kind, ShouldEqual, models.EnvelopeKindError)
+		So(refusal.Code, ShouldEqual, models.SignalErrorUnsupported)
+	})
+}
+
+func TestPostSignal(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	url := "/api/v1/client/signal/" + f.Host.Name
+	good := serve("POST", url, strings.NewReader(
+		`{"type": 1, "path": "/a", "endpoint": "e", "bucket": "b"}`,
+	))
+	noPath := serve("POST", url, strings.NewReader(
+		`{"type": 1, "endpoint": "e", "bucket": "b"}`,
+	))
+	unknown := serve("POST", url, strings.NewReader(`{"type": 9}`))
+	badType := serve("POST", url, strings.NewReader(`{"type": "download"}`))
+
+	Convey("Subject: Validate posted signal\n", t, func() {
+		So(good.Code, ShouldEqual, http.StatusCreated)
+		So(noPath.Code, ShouldEqual, http.StatusBadRequest)
+		So(unknown.Code, ShouldEqual, http.StatusBadRequest)
+		So(badType.Code, ShouldEqual, http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,159 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ModuleAB/ModuleAB/server/common"
+	"github.com/ModuleAB/ModuleAB/server/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := common.NewStorage(&common.StorageConfig{
+		Driver:   common.StorageDriverLocal,
+		Endpoint: dir,
+		Bucket:   "bucket",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.PutObject("a/b.tmp", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, listErr := s.ListObjects("a/")
+	info, statErr := s.StatObject("a/b.tmp")
+	_, signErr := s.SignURL("a/b.tmp", 0)
+	_, reserved := common.NewStorage(&common.StorageConfig{
+		Driver:   common.StorageDriverLocal,
+		Endpoint: dir,
+		Bucket:   ".tmp",
+	})
+
+	Convey("Subject: Local storage\n", t, func() {
+		Convey("Keys ending in .tmp should be objects too", func() {
+			So(listErr, ShouldBeNil)
+			So(len(list), ShouldEqual, 1)
+			So(list[0].Key, ShouldEqual, "a/b.tmp")
+			So(list[0].Size, ShouldEqual, 4)
+			So(statErr, ShouldBeNil)
+			So(info.Md5, ShouldEqual, "8d777f385d3dfec8815d20f7496026dc")
+		})
+		Convey("Temporary directory should not be a bucket", func() {
+			So(reserved, ShouldNotBeNil)
+		})
+		Convey("Server files should not be given by URL", func() {
+			So(signErr, ShouldEqual, common.ErrorSignURL)
+		})
+	})
+}
+
+func TestLocalArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := common.NewStorage(&common.StorageConfig{
+		Driver:   common.StorageDriverLocal,
+		Endpoint: dir,
+		Bucket:   "bucket",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := common.NewArchive(&common.ArchiveConfig{
+		Driver:   common.ArchiveDriverLocal,
+		Endpoint: dir,
+		VaultId:  "vault",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(dir+"/secret", []byte("secret"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, recoverErr := a.RecoverTo("../../secret", s, "stolen", "")
+	_, deleteErr := a.DeleteArchive("../../secret")
+	_, jobErr := a.GetJob("../../secret")
+	_, inventoryErr := a.GetInventory("../../secret")
+	_, statErr := s.StatObject("stolen")
+	_, kept := os.Stat(dir + "/secret")
+
+	Convey("Subject: Local archive\n", t, func() {
+		Convey("Ids reaching outside vault should be refused", func() {
+			So(recoverErr, ShouldNotBeNil)
+			So(deleteErr, ShouldNotBeNil)
+			So(jobErr, ShouldNotBeNil)
+			So(inventoryErr, ShouldNotBeNil)
+			So(statErr, ShouldEqual, common.ErrorObjectNotFound)
+			So(kept, ShouldBeNil)
+		})
+	})
+}
+
+func TestOssDriver(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	added := serve("POST", "/api/v1/oss", strings.NewReader(
+		`{"driver": "aliyn", "endpoint": "127.0.0.1", "bucket": "typo"}`,
+	))
+	updated := serve("PUT", "/api/v1/oss/"+f.Oss.BucketName, strings.NewReader(
+		`{"driver": "aliyn", "endpoint": "127.0.0.1", "bucket": "`+
+			f.Oss.BucketName+`"}`,
+	))
+	kept, err := models.GetOss(&models.Oss{BucketName: f.Oss.BucketName}, 1, 0)
+	f.check(err)
+	withSecret := &models.Oss{BucketName: "b", SecretKey: "s3cr3t"}
+	logged := fmt.Sprint(withSecret)
+	shown, err := json.Marshal(withSecret)
+	f.check(err)
+
+	Convey("Subject: Storage driver of OSS\n", t, func() {
+		Convey("Unknown driver should be refused", func() {
+			So(added.Code, ShouldEqual, http.StatusBadRequest)
+			So(updated.Code, ShouldEqual, http.StatusBadRequest)
+			So(kept[0].Driver, ShouldEqual, common.StorageDriverAliyun)
+		})
+		Convey("Secret key should be neither logged nor shown", func() {
+			So(logged, ShouldContainSubstring, "b")
+			So(logged, ShouldNotContainSubstring, "s3cr3t")
+			So(string(shown), ShouldNotContainSubstring, "s3cr3t")
+		})
+	})
+}
+
+func TestOasDriver(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	added := serve("POST", "/api/v1/oas", strings.NewReader(
+		`{"driver": "osa", "endpoint": "127.0.0.1", "vaultName": "typo"}`,
+	))
+	updated := serve("PUT", "/api/v1/oas/"+f.Oas.VaultName, strings.NewReader(
+		`{"driver": "osa", "endpoint": "127.0.0.1", "vaultName": "`+
+			f.Oas.VaultName+`"}`,
+	))
+	kept, err := models.GetOas(&models.Oas{VaultName: f.Oas.VaultName}, 1, 0)
+	f.check(err)
+
+	Convey("Subject: Archive driver of OAS\n", t, func() {
+		Convey("Unknown driver should be refused", func() {
+			So(added.Code, ShouldEqual, http.StatusBadRequest)
+			So(updated.Code, ShouldEqual, http.StatusBadRequest)
+			So(kept[0].Driver, ShouldEqual, common.ArchiveDriverOas)
+		})
+	})
+}